@@ -0,0 +1,205 @@
+//Package apktest builds small APKs for tests: a binary AndroidManifest.xml
+//in a zip archive.
+package apktest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+//Manifest describes the AndroidManifest.xml of a test APK
+type Manifest struct {
+	Package     string
+	VersionCode int
+	VersionName string
+	MinSdk      int //omitted when 0
+	TargetSdk   int //omitted when 0
+
+	//makes versionName a reference to this resource id, like
+	//android:versionName="@string/version"
+	VersionNameRef uint32
+
+	//encode the string pool as UTF-8 instead of UTF-16
+	UTF8 bool
+}
+
+//File is an entry of a test APK
+type File struct {
+	Name string
+	Data []byte
+}
+
+//attribute ids of the android framework, in the order of the first strings
+//of the pool
+var resourceIDs = []uint32{0x0101021b, 0x0101021c, 0x0101020c, 0x01010270}
+
+//indexes into the string pool
+const (
+	strVersionCode = iota
+	strVersionName
+	strMinSdkVersion
+	strTargetSdkVersion
+	strPackage
+	strManifest
+	strUsesSdk
+	strPackageValue
+	strVersionNameValue
+)
+
+const (
+	typeReference = 0x01
+	typeString    = 0x03
+	typeIntDec    = 0x10
+	noIndex       = 0xffffffff
+)
+
+//Bytes encodes the manifest in Android's binary XML format
+func (m Manifest) Bytes() []byte {
+	strings := []string{"versionCode", "versionName", "minSdkVersion", "targetSdkVersion", "package", "manifest", "uses-sdk", m.Package, m.VersionName}
+
+	manifestAttrs := [][]byte{
+		attribute(strVersionCode, noIndex, typeIntDec, uint32(m.VersionCode)),
+	}
+	if m.VersionNameRef != 0 {
+		manifestAttrs = append(manifestAttrs, attribute(strVersionName, noIndex, typeReference, m.VersionNameRef))
+	} else {
+		manifestAttrs = append(manifestAttrs, attribute(strVersionName, strVersionNameValue, typeString, strVersionNameValue))
+	}
+	if m.Package != "" {
+		manifestAttrs = append(manifestAttrs, attribute(strPackage, strPackageValue, typeString, strPackageValue))
+	}
+
+	var sdkAttrs [][]byte
+	if m.MinSdk != 0 {
+		sdkAttrs = append(sdkAttrs, attribute(strMinSdkVersion, noIndex, typeIntDec, uint32(m.MinSdk)))
+	}
+	if m.TargetSdk != 0 {
+		sdkAttrs = append(sdkAttrs, attribute(strTargetSdkVersion, noIndex, typeIntDec, uint32(m.TargetSdk)))
+	}
+
+	var resourceMap []byte
+	for _, id := range resourceIDs {
+		resourceMap = binary.LittleEndian.AppendUint32(resourceMap, id)
+	}
+
+	body := concat(
+		stringPool(strings, m.UTF8),
+		chunk(0x0180, nil, resourceMap),
+		startElement(strManifest, manifestAttrs),
+		startElement(strUsesSdk, sdkAttrs),
+		endElement(strUsesSdk),
+		endElement(strManifest),
+	)
+	return chunk(0x0003, nil, body)
+}
+
+//chunk frames a binary XML chunk: type, header size and total size,
+//followed by the rest of the header and the body
+func chunk(chunkType uint16, header, body []byte) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, chunkType)
+	b = binary.LittleEndian.AppendUint16(b, uint16(8+len(header)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(8+len(header)+len(body)))
+	return concat(b, header, body)
+}
+
+func stringPool(strings []string, utf8 bool) []byte {
+	var offsets, data []byte
+	for _, s := range strings {
+		offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+		if utf8 {
+			data = append(data, byte(len(utf16.Encode([]rune(s)))), byte(len(s)))
+			data = append(append(data, s...), 0)
+			continue
+		}
+		units := utf16.Encode([]rune(s))
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(units)))
+		for _, unit := range units {
+			data = binary.LittleEndian.AppendUint16(data, unit)
+		}
+		data = append(data, 0, 0)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	var flags uint32
+	if utf8 {
+		flags = 1 << 8
+	}
+	header := binary.LittleEndian.AppendUint32(nil, uint32(len(strings)))
+	header = binary.LittleEndian.AppendUint32(header, 0) //style count
+	header = binary.LittleEndian.AppendUint32(header, flags)
+	header = binary.LittleEndian.AppendUint32(header, uint32(28+len(offsets)))
+	header = binary.LittleEndian.AppendUint32(header, 0) //styles start
+	return chunk(0x0001, header, concat(offsets, data))
+}
+
+func attribute(name, rawValue uint32, dataType byte, value uint32) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, noIndex)
+	b = binary.LittleEndian.AppendUint32(b, name)
+	b = binary.LittleEndian.AppendUint32(b, rawValue)
+	b = binary.LittleEndian.AppendUint16(b, 8)
+	b = append(b, 0, dataType)
+	return binary.LittleEndian.AppendUint32(b, value)
+}
+
+//elementHeader is the line number and comment every node starts with
+func elementHeader() []byte {
+	return binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 1), noIndex)
+}
+
+func startElement(name uint32, attrs [][]byte) []byte {
+	ext := binary.LittleEndian.AppendUint32(nil, noIndex)
+	ext = binary.LittleEndian.AppendUint32(ext, name)
+	ext = binary.LittleEndian.AppendUint16(ext, 20) //attribute start
+	ext = binary.LittleEndian.AppendUint16(ext, 20) //attribute size
+	ext = binary.LittleEndian.AppendUint16(ext, uint16(len(attrs)))
+	ext = append(ext, make([]byte, 6)...) //id, class and style index
+	return chunk(0x0102, elementHeader(), concat(append([][]byte{ext}, attrs...)...))
+}
+
+func endElement(name uint32) []byte {
+	ext := binary.LittleEndian.AppendUint32(nil, noIndex)
+	ext = binary.LittleEndian.AppendUint32(ext, name)
+	return chunk(0x0103, elementHeader(), ext)
+}
+
+//Zip stores files in a zip archive
+func Zip(files ...File) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			panic(err)
+		}
+		w.Write(f.Data)
+	}
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+//Files are the entries of an APK with the manifest m
+func Files(m Manifest) []File {
+	return []File{
+		{"AndroidManifest.xml", m.Bytes()},
+		{"classes.dex", []byte("dex\n035\x00")},
+	}
+}
+
+//New builds an unsigned APK with the manifest m
+func New(m Manifest) []byte {
+	return Zip(Files(m)...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
\ No newline at end of file
@@ -0,0 +1,277 @@
+package apktest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//Key is a signing key with a self-signed certificate
+type Key struct {
+	Signer      crypto.Signer
+	Certificate *x509.Certificate
+}
+
+//RSAKey generates a 2048 bit RSA key for a certificate with the common
+//name cn
+func RSAKey(cn string) *Key {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return newKey(key, cn)
+}
+
+//ECKey generates a P-256 key for a certificate with the common name cn
+func ECKey(cn string) *Key {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return newKey(key, cn)
+}
+
+func newKey(signer crypto.Signer, cn string) *Key {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return &Key{Signer: signer, Certificate: cert}
+}
+
+//Fingerprint is the lowercase hex SHA-256 of the certificate
+func (k *Key) Fingerprint() string {
+	sum := sha256.Sum256(k.Certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+//sign signs the SHA-256 digest of data, PKCS#1 v1.5 for RSA keys and ASN.1
+//encoded for EC keys
+func (k *Key) sign(data []byte) []byte {
+	digest := sha256.Sum256(data)
+	sig, err := k.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+//tagged wraps DER in the [0] tag PKCS#7 uses for content and certificates
+func tagged(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type detachedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      detachedContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+//pkcs7 is a detached PKCS#7 signature over content, without authenticated
+//attributes
+func (k *Key) pkcs7(content []byte) []byte {
+	encryption := oidRSAEncryption
+	if _, ok := k.Signer.(*ecdsa.PrivateKey); ok {
+		encryption = oidECDSAWithSHA256
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		ContentInfo:      detachedContentInfo{ContentType: oidData},
+		Certificates:     tagged(k.Certificate.Raw),
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: k.Certificate.RawIssuer},
+				SerialNumber: k.Certificate.SerialNumber,
+			},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: encryption},
+			EncryptedDigest:           k.sign(content),
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	der, err := asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: tagged(sd)})
+	if err != nil {
+		panic(err)
+	}
+	return der
+}
+
+func sha256Base64(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//JARSignature returns the META-INF files of a v1 JAR signature over files
+func JARSignature(key *Key, files ...File) []File {
+	var manifest strings.Builder
+	manifest.WriteString("Manifest-Version: 1.0\r\nCreated-By: apktest\r\n\r\n")
+	for _, f := range files {
+		fmt.Fprintf(&manifest, "Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", f.Name, sha256Base64(f.Data))
+	}
+	sf := fmt.Sprintf("Signature-Version: 1.0\r\nSHA-256-Digest-Manifest: %s\r\nCreated-By: apktest\r\n\r\n", sha256Base64([]byte(manifest.String())))
+
+	block := "META-INF/CERT.RSA"
+	if _, ok := key.Signer.(*ecdsa.PrivateKey); ok {
+		block = "META-INF/CERT.EC"
+	}
+	return []File{
+		{"META-INF/MANIFEST.MF", []byte(manifest.String())},
+		{"META-INF/CERT.SF", []byte(sf)},
+		{block, key.pkcs7([]byte(sf))},
+	}
+}
+
+//SignV1 builds an APK with the manifest m signed with a v1 JAR signature
+func SignV1(m Manifest, key *Key) []byte {
+	files := Files(m)
+	return Zip(append(files, JARSignature(key, files...)...)...)
+}
+
+//signature algorithm ids of APK Signature Scheme v2/v3
+const (
+	sigRSAPKCS1SHA256 = 0x0103
+	sigECDSASHA256    = 0x0201
+)
+
+//Sign adds an APK Signing Block to apk with a v2 and/or v3 signature, per
+//the schemes given
+func Sign(apk []byte, key *Key, schemes ...int) []byte {
+	eocdOffset := bytes.LastIndex(apk, []byte("PK\x05\x06"))
+	if eocdOffset < 0 {
+		panic("apktest: end of central directory not found")
+	}
+	eocd := bytes.Clone(apk[eocdOffset:])
+	cdSize := int(binary.LittleEndian.Uint32(eocd[12:]))
+	cdOffset := int(binary.LittleEndian.Uint32(eocd[16:]))
+	entries, cd := apk[:cdOffset], apk[cdOffset:cdOffset+cdSize]
+
+	algorithm := uint32(sigRSAPKCS1SHA256)
+	if _, ok := key.Signer.(*ecdsa.PrivateKey); ok {
+		algorithm = sigECDSASHA256
+	}
+	digest := contentDigest(entries, cd, eocd)
+
+	var pairs []byte
+	for _, scheme := range schemes {
+		id := uint32(0x7109871a)
+		if scheme == 3 {
+			id = 0xf05368c0
+		}
+		value := lengthPrefixed(lengthPrefixed(schemeSigner(key, algorithm, digest, scheme)))
+		pairs = binary.LittleEndian.AppendUint64(pairs, uint64(4+len(value)))
+		pairs = binary.LittleEndian.AppendUint32(pairs, id)
+		pairs = append(pairs, value...)
+	}
+
+	size := binary.LittleEndian.AppendUint64(nil, uint64(len(pairs)+8+16))
+	block := concat(size, pairs, size, []byte("APK Sig Block 42"))
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(cdOffset+len(block)))
+	return concat(entries, block, cd, eocd)
+}
+
+//schemeSigner encodes one signer of a v2 or v3 block
+func schemeSigner(key *Key, algorithm uint32, digest []byte, scheme int) []byte {
+	sdkRange := concat(binary.LittleEndian.AppendUint32(nil, 24), binary.LittleEndian.AppendUint32(nil, 0x7fffffff))
+
+	digests := lengthPrefixed(lengthPrefixed(concat(binary.LittleEndian.AppendUint32(nil, algorithm), lengthPrefixed(digest))))
+	certificates := lengthPrefixed(lengthPrefixed(key.Certificate.Raw))
+	signed := concat(digests, certificates)
+	if scheme == 3 {
+		signed = concat(signed, sdkRange)
+	}
+	signed = concat(signed, lengthPrefixed(nil)) //additional attributes
+
+	signer := lengthPrefixed(signed)
+	if scheme == 3 {
+		signer = concat(signer, sdkRange)
+	}
+	signatures := lengthPrefixed(lengthPrefixed(concat(binary.LittleEndian.AppendUint32(nil, algorithm), lengthPrefixed(key.sign(signed)))))
+	return concat(signer, signatures, lengthPrefixed(key.Certificate.RawSubjectPublicKeyInfo))
+}
+
+//contentDigest is the chunked SHA-256 digest v2 and v3 sign, with the end of
+//central directory record pointing at the signing block
+func contentDigest(entries, cd, eocd []byte) []byte {
+	var digests []byte
+	var count uint32
+	for _, section := range [][]byte{entries, cd, eocd} {
+		for len(section) > 0 {
+			chunk := section[:min(len(section), 1<<20)]
+			section = section[len(chunk):]
+			digests = append(digests, chunkDigest(0xa5, uint32(len(chunk)), chunk)...)
+			count++
+		}
+	}
+	return chunkDigest(0x5a, count, digests)
+}
+
+func chunkDigest(prefix byte, n uint32, data []byte) []byte {
+	h := sha256.New()
+	h.Write(binary.LittleEndian.AppendUint32([]byte{prefix}, n))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func lengthPrefixed(b []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
\ No newline at end of file
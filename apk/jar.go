@@ -0,0 +1,346 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1" //registers SHA-1, still the digest of many v1 signatures
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"path"
+	"strings"
+)
+
+//v1 signature files are small, entries are hashed while streaming
+const maxSignatureFileSize = 16 << 20
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidDigestAlgorithms = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+
+	//digest attribute names used in MANIFEST.MF and .SF files
+	jarDigestNames = []struct {
+		prefix string
+		hash   crypto.Hash
+	}{
+		{"SHA-512", crypto.SHA512},
+		{"SHA-384", crypto.SHA384},
+		{"SHA-256", crypto.SHA256},
+		{"SHA1", crypto.SHA1},
+		{"SHA-1", crypto.SHA1},
+	}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+//verifyJAR verifies a v1 JAR signature: the PKCS#7 signature over the .SF
+//file, the .SF digest of MANIFEST.MF and the manifest digest of every entry.
+//Every entry outside META-INF has to be covered by the manifest.
+func verifyJAR(r io.ReaderAt, size int64) ([]Signer, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading APK archive: %w", err)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	manifest := files["META-INF/MANIFEST.MF"]
+	if manifest == nil {
+		return nil, ErrNotSigned
+	}
+	manifestData, err := readEntry(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var signers []Signer
+	for _, f := range zr.File {
+		dir, name := path.Split(f.Name)
+		ext := strings.ToUpper(path.Ext(name))
+		if dir != "META-INF/" || (ext != ".RSA" && ext != ".EC" && ext != ".DSA") {
+			continue
+		}
+		sf := files["META-INF/"+strings.TrimSuffix(name, path.Ext(name))+".SF"]
+		if sf == nil {
+			return nil, fmt.Errorf("JAR signature %s has no signature file", f.Name)
+		}
+
+		block, err := readEntry(f)
+		if err != nil {
+			return nil, err
+		}
+		sfData, err := readEntry(sf)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := verifyPKCS7(block, sfData)
+		if err != nil {
+			return nil, fmt.Errorf("JAR signature %s: %w", f.Name, err)
+		}
+
+		if err := checkDigestAttribute(parseJarSections(sfData)[0], "-Digest-Manifest", manifestData); err != nil {
+			return nil, fmt.Errorf("%s: MANIFEST.MF %w", sf.Name, err)
+		}
+
+		sum := sha256.Sum256(cert.Raw)
+		signers = append(signers, Signer{Certificate: cert, Fingerprint: hex.EncodeToString(sum[:])})
+	}
+	if len(signers) == 0 {
+		return nil, ErrNotSigned
+	}
+
+	//the manifest is signed, now make sure the entries match it
+	sections := parseJarSections(manifestData)
+	covered := make(map[string]bool)
+	for _, section := range sections[1:] {
+		name := section["Name"]
+		if name == "" {
+			continue
+		}
+		f := files[name]
+		if f == nil {
+			return nil, fmt.Errorf("MANIFEST.MF lists %s, which is not in the APK", name)
+		}
+		h, expected, err := digestAttribute(section, "-Digest")
+		if err != nil {
+			return nil, fmt.Errorf("MANIFEST.MF entry %s: %w", name, err)
+		}
+		actual, err := hashEntry(f, h)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(actual, expected) {
+			return nil, fmt.Errorf("%s doesn't match its digest in MANIFEST.MF", name)
+		}
+		covered[name] = true
+	}
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "META-INF/") && !strings.HasSuffix(f.Name, "/") && !covered[f.Name] {
+			return nil, fmt.Errorf("%s is not covered by the JAR signature", f.Name)
+		}
+	}
+	return signers, nil
+}
+
+//verifyPKCS7 checks a detached PKCS#7 signature over content and returns the
+//signing certificate
+func verifyPKCS7(der, content []byte) (*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("parsing PKCS#7: %w", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("PKCS#7 content is not signed data")
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("parsing PKCS#7 signed data: %w", err)
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificates: %w", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("expected one signer, found %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if c.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 && bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("signer certificate not found")
+	}
+
+	h, ok := oidDigestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+	if !ok || !h.Available() {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+	hasher := h.New()
+	hasher.Write(content)
+	digest := hasher.Sum(nil)
+
+	//with authenticated attributes the signature covers them instead, and
+	//they carry the content digest
+	signed := digest
+	if len(si.AuthenticatedAttributes.FullBytes) > 0 {
+		if err := checkMessageDigest(si.AuthenticatedAttributes.Bytes, digest); err != nil {
+			return nil, err
+		}
+		attrs := bytes.Clone(si.AuthenticatedAttributes.FullBytes)
+		attrs[0] = 0x31 //signed as a SET OF, not the implicit [0] tag
+		hasher.Reset()
+		hasher.Write(attrs)
+		signed = hasher.Sum(nil)
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, h, signed, si.EncryptedDigest)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, signed, si.EncryptedDigest) {
+			err = fmt.Errorf("invalid ECDSA signature")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+	return cert, nil
+}
+
+func checkMessageDigest(attrs, digest []byte) error {
+	for len(attrs) > 0 {
+		var attr pkcs7Attribute
+		var err error
+		if attrs, err = asn1.Unmarshal(attrs, &attr); err != nil {
+			return fmt.Errorf("parsing authenticated attributes: %w", err)
+		}
+		if !attr.Type.Equal(oidMessageDigest) {
+			continue
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(attr.Values.Bytes, &value); err != nil {
+			return fmt.Errorf("parsing message digest: %w", err)
+		}
+		if !bytes.Equal(value, digest) {
+			return fmt.Errorf("signature file doesn't match the signed digest")
+		}
+		return nil
+	}
+	return fmt.Errorf("authenticated attributes have no message digest")
+}
+
+//parseJarSections splits a manifest or signature file into its main section
+//and the per-entry sections, joining continuation lines
+func parseJarSections(data []byte) []map[string]string {
+	sections := []map[string]string{{}}
+	current := sections[0]
+	lastKey := ""
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for _, line := range lines {
+		switch {
+		case line == "":
+			if len(current) > 0 {
+				current = map[string]string{}
+				sections = append(sections, current)
+			}
+			lastKey = ""
+		case strings.HasPrefix(line, " ") && lastKey != "":
+			current[lastKey] += line[1:]
+		default:
+			key, value, _ := strings.Cut(line, ": ")
+			current[key] = value
+			lastKey = key
+		}
+	}
+	return sections
+}
+
+//digestAttribute finds the strongest "<ALG>-Digest" style attribute
+func digestAttribute(section map[string]string, suffix string) (crypto.Hash, []byte, error) {
+	for _, d := range jarDigestNames {
+		if value, ok := section[d.prefix+suffix]; ok {
+			digest, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid digest")
+			}
+			return d.hash, digest, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("has no supported digest")
+}
+
+func checkDigestAttribute(section map[string]string, suffix string, data []byte) error {
+	h, expected, err := digestAttribute(section, suffix)
+	if err != nil {
+		return err
+	}
+	hasher := h.New()
+	hasher.Write(data)
+	if !bytes.Equal(hasher.Sum(nil), expected) {
+		return fmt.Errorf("doesn't match the signed digest")
+	}
+	return nil
+}
+
+func readEntry(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxSignatureFileSize {
+		return nil, fmt.Errorf("%s is too large", f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxSignatureFileSize))
+}
+
+func hashEntry(f *zip.File, h crypto.Hash) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	hasher := h.New()
+	if _, err := io.Copy(hasher, rc); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+	}
+	return hasher.Sum(nil), nil
+}
\ No newline at end of file
@@ -0,0 +1,284 @@
+package apk
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+//Manifest holds the fields of AndroidManifest.xml the service checks
+type Manifest struct {
+	Package     string
+	VersionCode int
+	VersionName string
+	MinSdk      int
+	TargetSdk   int
+
+	//set when versionName is a resource reference that can't be resolved
+	//without resources.arsc
+	VersionNameIsRef bool
+}
+
+var ErrNoManifest = errors.New("AndroidManifest.xml not found, the file is not an APK")
+
+//the manifest is small, refuse anything that looks like a zip bomb
+const maxManifestSize = 4 << 20
+
+//android framework attribute ids, stable across platform versions. Attribute
+//names may be stripped or obfuscated, the ids are what the platform reads.
+const (
+	attrVersionCode      = 0x0101021b
+	attrVersionName      = 0x0101021c
+	attrMinSdkVersion    = 0x0101020c
+	attrTargetSdkVersion = 0x01010270
+)
+
+//chunk types of the binary XML format
+const (
+	chunkStringPool   = 0x0001
+	chunkXML          = 0x0003
+	chunkResourceMap  = 0x0180
+	chunkStartElement = 0x0102
+)
+
+//typed value types
+const (
+	typeReference = 0x01
+	typeString    = 0x03
+	typeIntDec    = 0x10
+	typeIntHex    = 0x11
+)
+
+const noIndex = 0xffffffff
+
+//ReadManifest extracts and parses the binary AndroidManifest.xml of an APK
+func ReadManifest(r io.ReaderAt, size int64) (*Manifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading APK archive: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "AndroidManifest.xml" {
+			continue
+		}
+		if f.UncompressedSize64 > maxManifestSize {
+			return nil, fmt.Errorf("AndroidManifest.xml is too large")
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening AndroidManifest.xml: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxManifestSize))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading AndroidManifest.xml: %w", err)
+		}
+		return ParseManifest(data)
+	}
+	return nil, ErrNoManifest
+}
+
+//ParseManifest decodes a manifest in Android's binary XML format
+func ParseManifest(data []byte) (*Manifest, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint16(data) != chunkXML {
+		return nil, fmt.Errorf("AndroidManifest.xml is not binary XML")
+	}
+
+	m := &Manifest{MinSdk: 1}
+	var strings []string
+	var resourceIDs []uint32
+
+	offset := int(binary.LittleEndian.Uint16(data[2:]))
+	for offset+8 <= len(data) {
+		chunkType := binary.LittleEndian.Uint16(data[offset:])
+		headerSize := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if chunkSize < 8 || headerSize > chunkSize || offset+chunkSize > len(data) {
+			return nil, fmt.Errorf("AndroidManifest.xml is malformed")
+		}
+		chunk := data[offset : offset+chunkSize]
+
+		switch chunkType {
+		case chunkStringPool:
+			var err error
+			if strings, err = parseStringPool(chunk); err != nil {
+				return nil, err
+			}
+
+		case chunkResourceMap:
+			for i := headerSize; i+4 <= len(chunk); i += 4 {
+				resourceIDs = append(resourceIDs, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+
+		case chunkStartElement:
+			if err := m.readElement(chunk, headerSize, strings, resourceIDs); err != nil {
+				return nil, err
+			}
+		}
+
+		offset += chunkSize
+	}
+
+	if m.Package == "" {
+		return nil, fmt.Errorf("AndroidManifest.xml has no package name")
+	}
+	return m, nil
+}
+
+//readElement picks the attributes of <manifest> and <uses-sdk>
+func (m *Manifest) readElement(chunk []byte, headerSize int, strings []string, resourceIDs []uint32) error {
+	if len(chunk) < headerSize+20 {
+		return fmt.Errorf("AndroidManifest.xml is malformed")
+	}
+	ext := chunk[headerSize:]
+	name := lookup(strings, binary.LittleEndian.Uint32(ext[4:]))
+	if name != "manifest" && name != "uses-sdk" {
+		return nil
+	}
+
+	attrStart := int(binary.LittleEndian.Uint16(ext[8:]))
+	attrSize := int(binary.LittleEndian.Uint16(ext[10:]))
+	attrCount := int(binary.LittleEndian.Uint16(ext[12:]))
+	if attrSize < 20 || headerSize+attrStart+attrCount*attrSize > len(chunk) {
+		return fmt.Errorf("AndroidManifest.xml is malformed")
+	}
+
+	for i := 0; i < attrCount; i++ {
+		attr := chunk[headerSize+attrStart+i*attrSize:]
+		nameIndex := binary.LittleEndian.Uint32(attr[4:])
+		rawValue := binary.LittleEndian.Uint32(attr[8:])
+		dataType := attr[15]
+		value := binary.LittleEndian.Uint32(attr[16:])
+
+		var resourceID uint32
+		if int(nameIndex) < len(resourceIDs) {
+			resourceID = resourceIDs[nameIndex]
+		}
+
+		asString := func() string {
+			if rawValue != noIndex {
+				return lookup(strings, rawValue)
+			}
+			if dataType == typeString {
+				return lookup(strings, value)
+			}
+			return ""
+		}
+		asInt := func() int {
+			if dataType == typeIntDec || dataType == typeIntHex {
+				return int(int32(value))
+			}
+			return 0
+		}
+
+		switch {
+		case name == "manifest" && resourceID == 0 && lookup(strings, nameIndex) == "package":
+			m.Package = asString()
+		case name == "manifest" && resourceID == attrVersionCode:
+			m.VersionCode = asInt()
+		case name == "manifest" && resourceID == attrVersionName:
+			m.VersionName = asString()
+			m.VersionNameIsRef = dataType == typeReference
+		case name == "uses-sdk" && resourceID == attrMinSdkVersion:
+			//a codename such as "Tiramisu" marks a preview SDK, leave the default
+			if n := asInt(); n > 0 {
+				m.MinSdk = n
+			}
+		case name == "uses-sdk" && resourceID == attrTargetSdkVersion:
+			m.TargetSdk = asInt()
+		}
+	}
+	return nil
+}
+
+//parseStringPool decodes a string pool chunk in UTF-8 or UTF-16
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, fmt.Errorf("AndroidManifest.xml has a malformed string pool")
+	}
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	count := int(binary.LittleEndian.Uint32(chunk[8:]))
+	utf8 := binary.LittleEndian.Uint32(chunk[16:])&(1<<8) != 0
+	stringsStart := int(binary.LittleEndian.Uint32(chunk[20:]))
+	if headerSize+count*4 > len(chunk) || stringsStart > len(chunk) {
+		return nil, fmt.Errorf("AndroidManifest.xml has a malformed string pool")
+	}
+
+	strings := make([]string, count)
+	for i := range strings {
+		pos := stringsStart + int(binary.LittleEndian.Uint32(chunk[headerSize+i*4:]))
+		var s string
+		var ok bool
+		if utf8 {
+			s, ok = decodeUTF8(chunk, pos)
+		} else {
+			s, ok = decodeUTF16(chunk, pos)
+		}
+		if !ok {
+			return nil, fmt.Errorf("AndroidManifest.xml has a malformed string pool")
+		}
+		strings[i] = s
+	}
+	return strings, nil
+}
+
+func decodeUTF8(b []byte, pos int) (string, bool) {
+	//the UTF-16 length comes first, then the byte length, each one or two bytes
+	length := func() (int, bool) {
+		if pos >= len(b) {
+			return 0, false
+		}
+		n := int(b[pos])
+		pos++
+		if n&0x80 != 0 {
+			if pos >= len(b) {
+				return 0, false
+			}
+			n = (n&0x7f)<<8 | int(b[pos])
+			pos++
+		}
+		return n, true
+	}
+	if _, ok := length(); !ok {
+		return "", false
+	}
+	n, ok := length()
+	if !ok || pos+n > len(b) {
+		return "", false
+	}
+	return string(b[pos : pos+n]), true
+}
+
+func decodeUTF16(b []byte, pos int) (string, bool) {
+	if pos+2 > len(b) {
+		return "", false
+	}
+	n := int(binary.LittleEndian.Uint16(b[pos:]))
+	pos += 2
+	if n&0x8000 != 0 {
+		if pos+2 > len(b) {
+			return "", false
+		}
+		n = (n&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b[pos:]))
+		pos += 2
+	}
+	if pos+n*2 > len(b) {
+		return "", false
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[pos+i*2:])
+	}
+	return string(utf16.Decode(units)), true
+}
+
+func lookup(strings []string, index uint32) string {
+	if int(index) < len(strings) {
+		return strings[index]
+	}
+	return ""
+}
\ No newline at end of file
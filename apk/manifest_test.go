@@ -0,0 +1,82 @@
+package apk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"sono-version-service/apk/apktest"
+)
+
+func TestParseManifest(t *testing.T) {
+	valid := apktest.Manifest{Package: "com.sono.app", VersionCode: 42, VersionName: "1.2.0", MinSdk: 24, TargetSdk: 34}
+
+	tests := []struct {
+		name     string
+		manifest apktest.Manifest
+		want     Manifest
+	}{
+		{"utf-16 string pool", valid, Manifest{Package: "com.sono.app", VersionCode: 42, VersionName: "1.2.0", MinSdk: 24, TargetSdk: 34}},
+		{"utf-8 string pool", apktest.Manifest{Package: "com.sono.app", VersionCode: 42, VersionName: "1.2.0-ü", MinSdk: 24, UTF8: true}, Manifest{Package: "com.sono.app", VersionCode: 42, VersionName: "1.2.0-ü", MinSdk: 24}},
+		{"versionName reference", apktest.Manifest{Package: "com.sono.app", VersionCode: 7, VersionNameRef: 0x7f0e0001}, Manifest{Package: "com.sono.app", VersionCode: 7, MinSdk: 1, VersionNameIsRef: true}},
+		{"minSdk defaults to 1", apktest.Manifest{Package: "com.sono.app", VersionCode: 1, VersionName: "1.0"}, Manifest{Package: "com.sono.app", VersionCode: 1, VersionName: "1.0", MinSdk: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManifest(tt.manifest.Bytes())
+			if err != nil {
+				t.Fatalf("ParseManifest: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	valid := apktest.Manifest{Package: "com.sono.app", VersionCode: 42, VersionName: "1.2.0"}.Bytes()
+
+	//a chunk that claims to be larger than the file
+	oversized := bytes.Clone(valid)
+	oversized[8+4] = 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"text XML", []byte(`<?xml version="1.0"?><manifest package="com.sono.app"/>`)},
+		{"no package", apktest.Manifest{VersionCode: 1, VersionName: "1.0"}.Bytes()},
+		{"truncated", valid[:len(valid)/2]},
+		{"oversized chunk", oversized},
+	}
+	for _, tt := range tests {
+		if m, err := ParseManifest(tt.data); err == nil {
+			t.Errorf("%s: ParseManifest = %+v, want an error", tt.name, m)
+		}
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    []byte
+		wantErr bool
+		errIs   error
+	}{
+		{"apk", apktest.New(apktest.Manifest{Package: "com.sono.app", VersionCode: 3, VersionName: "3.0"}), false, nil},
+		{"zip without manifest", apktest.Zip(apktest.File{Name: "classes.dex", Data: []byte("dex")}), true, ErrNoManifest},
+		{"not a zip", []byte("MZ this is an exe"), true, nil},
+	}
+	for _, tt := range tests {
+		m, err := ReadManifest(bytes.NewReader(tt.file), int64(len(tt.file)))
+		if (err != nil) != tt.wantErr || (tt.errIs != nil && !errors.Is(err, tt.errIs)) {
+			t.Errorf("%s: err = %v, want error %v (%v)", tt.name, err, tt.wantErr, tt.errIs)
+			continue
+		}
+		if err == nil && (m.Package != "com.sono.app" || m.VersionCode != 3 || m.VersionName != "3.0") {
+			t.Errorf("%s: ReadManifest = %+v", tt.name, m)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,432 @@
+package apk
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" //registers SHA-512 for crypto.SHA512.New
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+//Signer is a certificate an APK is signed with
+type Signer struct {
+	Certificate *x509.Certificate
+
+	//lowercase hex SHA-256 of the DER encoded certificate, the form
+	//apksigner and keytool print
+	Fingerprint string
+}
+
+//Signature is the verified signature of an APK
+type Signature struct {
+	Scheme  int //1, 2 or 3
+	Signers []Signer
+}
+
+var ErrNotSigned = errors.New("APK is not signed")
+
+const (
+	sigBlockMagic = "APK Sig Block 42"
+	blockIDv2     = 0x7109871a
+	blockIDv3     = 0xf05368c0
+
+	//the signing block is loaded into memory, real ones are a few KB
+	maxSigBlockSize = 16 << 20
+
+	contentChunkSize = 1 << 20
+)
+
+//signature algorithm ids of APK Signature Scheme v2/v3. The verity based
+//variants and DSA aren't supported, signers always include one of these.
+const (
+	sigRSAPSSSHA256   = 0x0101
+	sigRSAPSSSHA512   = 0x0102
+	sigRSAPKCS1SHA256 = 0x0103
+	sigRSAPKCS1SHA512 = 0x0104
+	sigECDSASHA256    = 0x0201
+	sigECDSASHA512    = 0x0202
+)
+
+func sigHash(algorithm uint32) (crypto.Hash, bool) {
+	switch algorithm {
+	case sigRSAPSSSHA256, sigRSAPKCS1SHA256, sigECDSASHA256:
+		return crypto.SHA256, true
+	case sigRSAPSSSHA512, sigRSAPKCS1SHA512, sigECDSASHA512:
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+//VerifySignature checks the signature of an APK. APK Signature Scheme v3 is
+//preferred over v2, and the v1 JAR signature is only used for APKs without a
+//signing block. An invalid v2/v3 signature never falls back to v1.
+func VerifySignature(r io.ReaderAt, size int64) (*Signature, error) {
+	layout, err := readZipLayout(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	block, blockStart, err := readSigningBlock(r, layout.cdOffset)
+	if err != nil {
+		return nil, err
+	}
+	if block != nil {
+		for _, scheme := range []struct {
+			id      uint32
+			version int
+		}{{blockIDv3, 3}, {blockIDv2, 2}} {
+			value := findBlockValue(block, scheme.id)
+			if value == nil {
+				continue
+			}
+			signers, err := verifySchemeBlock(value, scheme.version, r, layout, blockStart)
+			if err != nil {
+				return nil, fmt.Errorf("APK Signature Scheme v%d: %w", scheme.version, err)
+			}
+			return &Signature{Scheme: scheme.version, Signers: signers}, nil
+		}
+	}
+
+	signers, err := verifyJAR(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{Scheme: 1, Signers: signers}, nil
+}
+
+//zipLayout locates the central directory and end of central directory record
+type zipLayout struct {
+	cdOffset   int64
+	cdSize     int64
+	eocdOffset int64
+	eocd       []byte
+}
+
+func readZipLayout(r io.ReaderAt, size int64) (*zipLayout, error) {
+	const eocdSize = 22
+	if size < eocdSize {
+		return nil, fmt.Errorf("reading APK archive: file too small")
+	}
+
+	//the record sits at the end, followed by a comment of up to 64 KB
+	tail := min(size, eocdSize+0xffff)
+	buf := make([]byte, tail)
+	if _, err := r.ReadAt(buf, size-tail); err != nil {
+		return nil, err
+	}
+	for i := len(buf) - eocdSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) != 0x06054b50 {
+			continue
+		}
+		commentLength := int(binary.LittleEndian.Uint16(buf[i+20:]))
+		if i+eocdSize+commentLength != len(buf) {
+			continue
+		}
+		layout := &zipLayout{
+			cdSize:     int64(binary.LittleEndian.Uint32(buf[i+12:])),
+			cdOffset:   int64(binary.LittleEndian.Uint32(buf[i+16:])),
+			eocdOffset: size - tail + int64(i),
+			eocd:       buf[i:],
+		}
+		if layout.cdOffset+layout.cdSize != layout.eocdOffset {
+			return nil, fmt.Errorf("reading APK archive: central directory is not followed by its end record")
+		}
+		return layout, nil
+	}
+	return nil, fmt.Errorf("reading APK archive: end of central directory not found")
+}
+
+//readSigningBlock returns the id-value pairs of the APK Signing Block that
+//precedes the central directory, or nil if there is none
+func readSigningBlock(r io.ReaderAt, cdOffset int64) ([]byte, int64, error) {
+	if cdOffset < 32 {
+		return nil, 0, nil
+	}
+	footer := make([]byte, 24)
+	if _, err := r.ReadAt(footer, cdOffset-24); err != nil {
+		return nil, 0, err
+	}
+	if string(footer[8:]) != sigBlockMagic {
+		return nil, 0, nil
+	}
+
+	blockSize := binary.LittleEndian.Uint64(footer)
+	if blockSize < 24 || blockSize > maxSigBlockSize || int64(blockSize)+8 > cdOffset {
+		return nil, 0, fmt.Errorf("APK Signing Block has an invalid size")
+	}
+	blockStart := cdOffset - int64(blockSize) - 8
+	block := make([]byte, blockSize+8)
+	if _, err := r.ReadAt(block, blockStart); err != nil {
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint64(block) != blockSize {
+		return nil, 0, fmt.Errorf("APK Signing Block sizes don't match")
+	}
+	return block[8 : len(block)-24], blockStart, nil
+}
+
+func findBlockValue(pairs []byte, id uint32) []byte {
+	for len(pairs) >= 12 {
+		length := binary.LittleEndian.Uint64(pairs)
+		if length < 4 || length > uint64(len(pairs)-8) {
+			return nil
+		}
+		if binary.LittleEndian.Uint32(pairs[8:]) == id {
+			return pairs[12 : 8+length]
+		}
+		pairs = pairs[8+length:]
+	}
+	return nil
+}
+
+var errMalformed = errors.New("malformed signature data")
+
+//lengthPrefixed splits off a uint32 length prefixed value
+func lengthPrefixed(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errMalformed
+	}
+	n := binary.LittleEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, nil, errMalformed
+	}
+	return b[4 : 4+n], b[4+n:], nil
+}
+
+func readUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, errMalformed
+	}
+	return binary.LittleEndian.Uint32(b), b[4:], nil
+}
+
+//verifySchemeBlock verifies every signer of a v2 or v3 block and the digest of
+//the APK contents they sign
+func verifySchemeBlock(value []byte, version int, r io.ReaderAt, layout *zipLayout, blockStart int64) ([]Signer, error) {
+	signersSeq, _, err := lengthPrefixed(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var signers []Signer
+	contentDigests := make(map[crypto.Hash][]byte)
+	for len(signersSeq) > 0 {
+		var signer []byte
+		if signer, signersSeq, err = lengthPrefixed(signersSeq); err != nil {
+			return nil, err
+		}
+
+		cert, digests, err := verifySigner(signer, version)
+		if err != nil {
+			return nil, err
+		}
+
+		for algorithm, expected := range digests {
+			h, _ := sigHash(algorithm)
+			actual, ok := contentDigests[h]
+			if !ok {
+				if actual, err = contentDigest(r, layout, blockStart, h); err != nil {
+					return nil, err
+				}
+				contentDigests[h] = actual
+			}
+			if !bytes.Equal(actual, expected) {
+				return nil, fmt.Errorf("APK contents don't match the signed digest")
+			}
+		}
+
+		sum := sha256.Sum256(cert.Raw)
+		signers = append(signers, Signer{Certificate: cert, Fingerprint: hex.EncodeToString(sum[:])})
+	}
+
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no signers")
+	}
+	return signers, nil
+}
+
+//verifySigner checks the signatures of one signer over its signed data and
+//returns its certificate and the content digests of the verified algorithms
+func verifySigner(signer []byte, version int) (*x509.Certificate, map[uint32][]byte, error) {
+	signedData, rest, err := lengthPrefixed(signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == 3 {
+		//minSdkVersion and maxSdkVersion of the signer
+		if len(rest) < 8 {
+			return nil, nil, errMalformed
+		}
+		rest = rest[8:]
+	}
+	signatures, rest, err := lengthPrefixed(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKeyDER, _, err := lengthPrefixed(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	//verify every signature made with a supported algorithm
+	var algorithms []uint32
+	for len(signatures) > 0 {
+		var entry, sig []byte
+		if entry, signatures, err = lengthPrefixed(signatures); err != nil {
+			return nil, nil, err
+		}
+		algorithm, entry, err := readUint32(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sig, _, err = lengthPrefixed(entry); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := sigHash(algorithm); !ok {
+			continue
+		}
+		if err := verifyWith(publicKey, algorithm, signedData, sig); err != nil {
+			return nil, nil, err
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	if len(algorithms) == 0 {
+		return nil, nil, fmt.Errorf("no signature with a supported algorithm")
+	}
+
+	//only now that it is authentic, parse the signed data
+	digestsSeq, rest, err := lengthPrefixed(signedData)
+	if err != nil {
+		return nil, nil, err
+	}
+	certsSeq, _, err := lengthPrefixed(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	digests := make(map[uint32][]byte)
+	for len(digestsSeq) > 0 {
+		var entry, digest []byte
+		if entry, digestsSeq, err = lengthPrefixed(digestsSeq); err != nil {
+			return nil, nil, err
+		}
+		algorithm, entry, err := readUint32(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		if digest, _, err = lengthPrefixed(entry); err != nil {
+			return nil, nil, err
+		}
+		digests[algorithm] = digest
+	}
+	verified := make(map[uint32][]byte)
+	for _, algorithm := range algorithms {
+		digest, ok := digests[algorithm]
+		if !ok {
+			return nil, nil, fmt.Errorf("signed data has no digest for algorithm %#x", algorithm)
+		}
+		verified[algorithm] = digest
+	}
+
+	certDER, _, err := lengthPrefixed(certsSeq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signer has no certificate")
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, publicKeyDER) {
+		return nil, nil, fmt.Errorf("certificate doesn't match the signing key")
+	}
+	return cert, verified, nil
+}
+
+func verifyWith(publicKey interface{}, algorithm uint32, data, sig []byte) error {
+	h, _ := sigHash(algorithm)
+	hasher := h.New()
+	hasher.Write(data)
+	digest := hasher.Sum(nil)
+
+	var err error
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch algorithm {
+		case sigRSAPSSSHA256, sigRSAPSSSHA512:
+			err = rsa.VerifyPSS(key, h, digest, sig, &rsa.PSSOptions{SaltLength: h.Size(), Hash: h})
+		case sigRSAPKCS1SHA256, sigRSAPKCS1SHA512:
+			err = rsa.VerifyPKCS1v15(key, h, digest, sig)
+		default:
+			err = fmt.Errorf("algorithm %#x doesn't match an RSA key", algorithm)
+		}
+	case *ecdsa.PublicKey:
+		if algorithm != sigECDSASHA256 && algorithm != sigECDSASHA512 {
+			err = fmt.Errorf("algorithm %#x doesn't match an EC key", algorithm)
+		} else if !ecdsa.VerifyASN1(key, digest, sig) {
+			err = fmt.Errorf("invalid ECDSA signature")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	return nil
+}
+
+//contentDigest computes the chunked digest the v2/v3 schemes sign over the
+//zip entries, the central directory and the end of central directory record,
+//with the record's central directory offset pointing at the signing block
+func contentDigest(r io.ReaderAt, layout *zipLayout, blockStart int64, h crypto.Hash) ([]byte, error) {
+	eocd := bytes.Clone(layout.eocd)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(blockStart))
+
+	sections := []*io.SectionReader{
+		io.NewSectionReader(r, 0, blockStart),
+		io.NewSectionReader(r, layout.cdOffset, layout.cdSize),
+		io.NewSectionReader(bytes.NewReader(eocd), 0, int64(len(eocd))),
+	}
+
+	var chunkDigests []byte
+	var count uint32
+	chunk := make([]byte, contentChunkSize)
+	hasher := h.New()
+	for _, section := range sections {
+		for {
+			n, err := io.ReadFull(section, chunk)
+			if n > 0 {
+				chunkDigests = append(chunkDigests, digestChunk(hasher, 0xa5, uint32(n), chunk[:n])...)
+				count++
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return digestChunk(hasher, 0x5a, count, chunkDigests), nil
+}
+
+func digestChunk(hasher hash.Hash, prefix byte, n uint32, data []byte) []byte {
+	hasher.Reset()
+	var header [5]byte
+	header[0] = prefix
+	binary.LittleEndian.PutUint32(header[1:], n)
+	hasher.Write(header[:])
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
\ No newline at end of file
@@ -0,0 +1,81 @@
+package apk
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"sono-version-service/apk/apktest"
+)
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, ecKey := apktest.RSAKey("rsa"), apktest.ECKey("ec")
+	manifest := apktest.Manifest{Package: "com.sono.app", VersionCode: 1, VersionName: "1.0"}
+
+	tests := []struct {
+		name   string
+		apk    []byte
+		key    *apktest.Key
+		scheme int
+	}{
+		{"v1 rsa", apktest.SignV1(manifest, rsaKey), rsaKey, 1},
+		{"v1 ec", apktest.SignV1(manifest, ecKey), ecKey, 1},
+		{"v2 rsa", apktest.Sign(apktest.New(manifest), rsaKey, 2), rsaKey, 2},
+		{"v2 ec", apktest.Sign(apktest.New(manifest), ecKey, 2), ecKey, 2},
+		{"v3 rsa", apktest.Sign(apktest.New(manifest), rsaKey, 3), rsaKey, 3},
+		{"v3 ec", apktest.Sign(apktest.New(manifest), ecKey, 3), ecKey, 3},
+		{"v3 preferred over v2", apktest.Sign(apktest.New(manifest), ecKey, 2, 3), ecKey, 3},
+		{"v2 preferred over v1", apktest.Sign(apktest.SignV1(manifest, ecKey), ecKey, 2), ecKey, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := VerifySignature(bytes.NewReader(tt.apk), int64(len(tt.apk)))
+			if err != nil {
+				t.Fatalf("VerifySignature: %v", err)
+			}
+			if sig.Scheme != tt.scheme {
+				t.Errorf("scheme = %d, want %d", sig.Scheme, tt.scheme)
+			}
+			if len(sig.Signers) != 1 || sig.Signers[0].Fingerprint != tt.key.Fingerprint() {
+				t.Errorf("signers = %+v, want %s", sig.Signers, tt.key.Fingerprint())
+			}
+		})
+	}
+}
+
+func TestVerifySignatureErrors(t *testing.T) {
+	key := apktest.ECKey("ec")
+	manifest := apktest.Manifest{Package: "com.sono.app", VersionCode: 1, VersionName: "1.0"}
+	files := apktest.Files(manifest)
+
+	//a changed byte of classes.dex breaks the v2 digest, the valid v1
+	//signature must not be used instead
+	tampered := apktest.Sign(apktest.SignV1(manifest, key), key, 2)
+	tampered[bytes.Index(tampered, []byte("dex\n035"))] = 'D'
+
+	modified := []apktest.File{files[0], {Name: "classes.dex", Data: []byte("patched")}}
+	extra := append(append(files, apktest.JARSignature(key, files...)...), apktest.File{Name: "lib/arm64-v8a/libx.so", Data: []byte("elf")})
+
+	tests := []struct {
+		name  string
+		apk   []byte
+		errIs error
+	}{
+		{"unsigned", apktest.New(manifest), ErrNotSigned},
+		{"v2 digest mismatch", tampered, nil},
+		{"v1 entry modified", apktest.Zip(append(modified, apktest.JARSignature(key, files...)...)...), nil},
+		{"v1 entry not signed", apktest.Zip(extra...), nil},
+		{"not a zip", []byte(strings.Repeat("not a zip", 10)), nil},
+	}
+	for _, tt := range tests {
+		sig, err := VerifySignature(bytes.NewReader(tt.apk), int64(len(tt.apk)))
+		if err == nil {
+			t.Errorf("%s: VerifySignature = %+v, want an error", tt.name, sig)
+			continue
+		}
+		if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.errIs)
+		}
+	}
+}
\ No newline at end of file
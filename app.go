@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/config"
+	"sono-version-service/database"
+	"sono-version-service/fetch"
+	"sono-version-service/handlers"
+	"sono-version-service/middleware"
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+//app holds the handlers of one Android app served by this deployment. Every
+//app has its own version store, storage prefix, webhook secret and DB scope.
+type app struct {
+	name        string
+	apiURL      string
+	auth        *middleware.Verifier
+	idempotency func(http.Handler) http.Handler
+	db          *database.DB
+
+	uploadHandler    *handlers.UploadHandler
+	versionHandler   *handlers.VersionHandler
+	downloadHandler  *handlers.DownloadHandler
+	releasesHandler  *handlers.ReleasesHandler
+	channelsHandler  *handlers.ChannelsHandler
+	checkHandler     *handlers.CheckHandler
+	changelogHandler *handlers.ChangelogHandler
+	scheduleHandler  *handlers.ScheduleHandler
+	tusHandler       *handlers.TusHandler
+
+	scheduler *handlers.Scheduler
+	poller    *handlers.GitHubPoller
+}
+
+func newApp(cfg *config.Config, appCfg config.AppConfig, baseStore storage.Storage, baseDB *database.DB, queue *handlers.JobQueue) (*app, error) {
+	apiURL := cfg.BaseURL + "/api/v1"
+	if appCfg.Name != cfg.DefaultApp {
+		apiURL = cfg.BaseURL + "/api/v1/apps/" + appCfg.Name
+	}
+
+	if err := os.MkdirAll(filepath.Dir(appCfg.VersionsFile), 0755); err != nil {
+		return nil, err
+	}
+
+	versionStore, err := models.NewVersionStore(appCfg.VersionsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, channel := range cfg.Channels {
+		if !models.Channel(channel.Name).IsWellFormed() {
+			return nil, fmt.Errorf("invalid channel name in configuration: %q", channel.Name)
+		}
+		for _, fingerprint := range channel.AllowedSigners {
+			if _, ok := models.NormalizeFingerprint(fingerprint); !ok {
+				return nil, fmt.Errorf("invalid signer fingerprint for channel %s: %q", channel.Name, fingerprint)
+			}
+		}
+		if err := versionStore.EnsureChannel(models.Channel(channel.Name), models.ChannelSettings{
+			DisplayName:    channel.DisplayName,
+			Visibility:     models.Visibility(channel.Visibility),
+			Retention:      channel.Retention,
+			AllowedSigners: channel.AllowedSigners,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	db := baseDB.WithApp(appCfg.Name)
+	if db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := handlers.BackfillHistory(ctx, versionStore, db, apiURL); err != nil {
+			log.Printf("Warning: Failed to backfill release history of %s: %v", appCfg.Name, err)
+		}
+		if err := handlers.FailInterruptedJobs(ctx, db); err != nil {
+			log.Printf("Warning: Failed to clean up upload jobs of %s: %v", appCfg.Name, err)
+		}
+		cancel()
+	}
+
+	store := storage.NewPrefixedStorage(baseStore, appCfg.StoragePrefix)
+	fetcher := fetch.NewClient(fetch.Config{
+		AllowedHosts:    cfg.FetchAllowedHosts,
+		HTTPSOnly:       cfg.FetchHTTPSOnly,
+		MaxRedirects:    cfg.FetchMaxRedirects,
+		AllowPrivateIPs: cfg.FetchAllowPrivateIPs,
+	}, 5*time.Minute)
+	uploadHandler := handlers.NewUploadHandler(store, versionStore, db, appCfg.Name, appCfg.PackageName, apiURL, cfg.MaxUploadSize, fetcher, queue)
+
+	tusHandler, err := handlers.NewTusHandler(uploadHandler, filepath.Join(cfg.TusStagingPath, appCfg.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []handlers.GitHubSource
+	for _, source := range appCfg.GitHub {
+		rules := handlers.DefaultGitHubRules()
+		if len(source.Rules) > 0 {
+			rules = nil
+			for _, rule := range source.Rules {
+				rules = append(rules, handlers.GitHubRule(rule))
+			}
+		}
+		sources = append(sources, handlers.GitHubSource{
+			Repo:         source.Repo,
+			Token:        source.Token,
+			AssetPattern: source.AssetPattern,
+			Rules:        rules,
+		})
+	}
+	statePath := filepath.Join(filepath.Dir(appCfg.VersionsFile), "github-"+appCfg.Name+".json")
+	poller, err := handlers.NewGitHubPoller(uploadHandler, cfg.GitHubAPIURL, sources, statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	//base64 bodies are a third larger than the APK
+	maxBody := cfg.MaxUploadSize/3*4 + 1<<20
+	auth := middleware.NewVerifier(appCfg.WebhookSecret, middleware.SignatureConfig{
+		Tolerance:        cfg.SignatureTolerance,
+		RequireSignature: cfg.RequireSignature,
+		MaxBody:          maxBody,
+	})
+	idempotency := middleware.Idempotency(middleware.NewIdempotencyStore(db), cfg.IdempotencyTTL, maxBody)
+
+	return &app{
+		name:        appCfg.Name,
+		apiURL:      apiURL,
+		auth:        auth,
+		idempotency: idempotency,
+		db:          db,
+
+		uploadHandler:    uploadHandler,
+		versionHandler:   handlers.NewVersionHandler(versionStore),
+		downloadHandler:  handlers.NewDownloadHandler(store, versionStore, db, appCfg.Name, auth),
+		releasesHandler:  handlers.NewReleasesHandler(store, versionStore, db, appCfg.Name, apiURL),
+		channelsHandler:  handlers.NewChannelsHandler(store, versionStore, db),
+		checkHandler:     handlers.NewCheckHandler(versionStore),
+		changelogHandler: handlers.NewChangelogHandler(versionStore, db),
+		scheduleHandler:  handlers.NewScheduleHandler(store, versionStore, db),
+		tusHandler:       tusHandler,
+
+		scheduler: handlers.NewScheduler(store, versionStore, db, appCfg.Name),
+		poller:    poller,
+	}, nil
+}
+
+//mount registers the app's routes below prefix
+func (a *app) mount(r chi.Router, prefix string) {
+	r.Get(prefix+"/version/{channel}", a.versionHandler.Handle)
+	r.Get(prefix+"/version/{channel}/{version}", a.releasesHandler.Get)
+	r.Get(prefix+"/releases/{channel}", a.releasesHandler.List)
+	r.Get(prefix+"/check/{channel}", a.checkHandler.Handle)
+	r.Get(prefix+"/changelog/{channel}", a.changelogHandler.Handle)
+	r.Get(prefix+"/channels", a.channelsHandler.List)
+	r.Get(prefix+"/download/{channel}", a.downloadHandler.Handle)
+	r.Get(prefix+"/download/{channel}/{version}", a.downloadHandler.HandleVersion)
+	r.Options(prefix+"/tus", a.tusHandler.Options)
+	r.Options(prefix+"/tus/{id}", a.tusHandler.Options)
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.WebhookAuth(a.auth))
+		r.With(a.idempotency).Post(prefix+"/upload", a.uploadHandler.Handle)
+		r.Get(prefix+"/uploads/{id}", a.uploadHandler.Job)
+		r.Post(prefix+"/tus", a.tusHandler.Create)
+		r.Head(prefix+"/tus/{id}", a.tusHandler.Head)
+		r.Patch(prefix+"/tus/{id}", a.tusHandler.Patch)
+		r.Delete(prefix+"/tus/{id}", a.tusHandler.Delete)
+		r.Post(prefix+"/channels", a.channelsHandler.Create)
+		r.Post(prefix+"/channels/{channel}", a.channelsHandler.Update)
+		r.Post(prefix+"/channels/{channel}/rollback", a.channelsHandler.Rollback)
+		r.Post(prefix+"/channels/{channel}/rollout", a.channelsHandler.Rollout)
+		r.Post(prefix+"/channels/{channel}/policy", a.channelsHandler.Policy)
+		r.Post(prefix+"/releases/{channel}/{version}/promote", a.releasesHandler.Promote)
+		r.Post(prefix+"/releases/{channel}/{version}/mandatory", a.releasesHandler.SetMandatory)
+		r.Post(prefix+"/releases/{channel}/{version}/yank", a.releasesHandler.Yank)
+		r.Get(prefix+"/scheduled", a.scheduleHandler.List)
+		r.Post(prefix+"/scheduled/{channel}/{version}/reschedule", a.scheduleHandler.Reschedule)
+		r.Post(prefix+"/scheduled/{channel}/{version}/cancel", a.scheduleHandler.Cancel)
+	})
+
+	if a.db != nil {
+		r.Get(prefix+"/stats", func(w http.ResponseWriter, r *http.Request) {
+			stats, err := a.db.GetDownloadStats(r.Context())
+			if err != nil {
+				http.Error(w, "Failed to fetch stats", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stats)
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/config"
+	"sono-version-service/handlers"
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+func TestAppsAreIsolated(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		BaseURL:        "http://localhost",
+		GitHubAPIURL:   "https://api.github.com",
+		DefaultApp:     "sono",
+		TusStagingPath: filepath.Join(dir, "tus"),
+		MaxUploadSize:  1 << 20,
+		Channels:       []config.ChannelConfig{{Name: "stable"}},
+	}
+	apps := []config.AppConfig{
+		{Name: "sono", VersionsFile: filepath.Join(dir, "versions.json")},
+		{Name: "tv", StoragePrefix: "tv/", VersionsFile: filepath.Join(dir, "versions-tv.json")},
+	}
+
+	//only the tv app has a release
+	tvStore, err := models.NewVersionStore(apps[1].VersionsFile)
+	if err != nil {
+		t.Fatalf("NewVersionStore: %v", err)
+	}
+	if err := tvStore.Set(&models.VersionInfo{Channel: models.ChannelStable, Version: "2.0.0", VersionCode: 20, FileName: "stable/tv.apk"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	base, err := storage.NewLocalStorage(filepath.Join(dir, "apks"))
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	queue := handlers.NewJobQueue(1, 1)
+	r := chi.NewRouter()
+	for _, appCfg := range apps {
+		a, err := newApp(cfg, appCfg, base, nil, queue)
+		if err != nil {
+			t.Fatalf("newApp(%s): %v", appCfg.Name, err)
+		}
+		a.mount(r, "/api/v1/apps/"+appCfg.Name)
+		if appCfg.Name == cfg.DefaultApp {
+			a.mount(r, "/api/v1")
+		}
+	}
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/apps/tv/version/stable", http.StatusOK},
+		{"/api/v1/apps/tv/version/stable/2.0.0", http.StatusOK},
+		{"/api/v1/apps/sono/version/stable", http.StatusNotFound},
+		{"/api/v1/version/stable", http.StatusNotFound},
+		{"/api/v1/version/stable/2.0.0", http.StatusNotFound},
+		{"/api/v1/apps/watch/version/stable", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d: %s", tt.target, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
\ No newline at end of file
@@ -1,8 +1,14 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -32,12 +38,94 @@ type Config struct {
 
 	//database
 	DatabaseURL string
+
+	//channels
+	Channels []ChannelConfig
+
+	//how often scheduled releases are checked for activation
+	SchedulerInterval time.Duration
+
+	//largest APK accepted by the upload endpoint, in bytes
+	MaxUploadSize int64
+
+	//where partial resumable uploads are kept
+	TusStagingPath string
+
+	//X-Hub-Signature-256 timestamp window, and whether the plain
+	//X-Webhook-Secret header is refused
+	SignatureTolerance time.Duration
+	RequireSignature   bool
+
+	//how long an Idempotency-Key on upload is remembered
+	IdempotencyTTL time.Duration
+
+	//background workers and queue length for asynchronous uploads
+	UploadWorkers   int
+	UploadQueueSize int
+
+	//policy for fetching apk_url
+	FetchAllowedHosts    []string
+	FetchHTTPSOnly       bool
+	FetchMaxRedirects    int
+	FetchAllowPrivateIPs bool
+
+	//GitHub Releases polling
+	GitHubAPIURL       string
+	GitHubToken        string
+	GitHubPollInterval time.Duration
+
+	//apps
+	DefaultApp string
+	Apps       []AppConfig
+}
+
+type AppConfig struct {
+	Name          string `json:"name"`
+	DisplayName   string `json:"display_name"`
+	PackageName   string `json:"package_name"` //android package, empty accepts any
+	WebhookSecret string `json:"webhook_secret"`
+	StoragePrefix string `json:"storage_prefix"`
+	VersionsFile  string `json:"versions_file"`
+
+	//repositories whose releases are published automatically
+	GitHub []GitHubSourceConfig `json:"github"`
+}
+
+type GitHubSourceConfig struct {
+	Repo         string `json:"repo"`          //owner/name
+	Token        string `json:"token"`         //defaults to GITHUB_TOKEN
+	AssetPattern string `json:"asset_pattern"` //glob, defaults to *.apk
+
+	//first matching rule picks the channel, by default prereleases go to
+	//beta and releases to stable
+	Rules []GitHubRuleConfig `json:"rules"`
+}
+
+type GitHubRuleConfig struct {
+	Tag        string `json:"tag"`        //glob matched against the tag, empty matches any
+	Prerelease *bool  `json:"prerelease"` //omit to match both
+	Channel    string `json:"channel"`
+}
+
+type ChannelConfig struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Visibility  string `json:"visibility"` //"public" or "hidden"
+	Retention   int    `json:"retention"`  //releases to keep, 0 keeps all
+
+	//SHA-256 fingerprints of the accepted signing certificates
+	AllowedSigners []string `json:"allowed_signers"`
 }
 
 func Load() (*Config, error) {
 	_ = godotenv.Load()
 
-	return &Config{
+	channels, err := loadChannels(getEnv("CHANNELS_FILE", ""), getEnv("CHANNELS", "stable,beta,nightly"))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
 		Port:              getEnv("PORT", "8080"),
 		BaseURL:           getEnv("BASE_URL", "http://localhost:8080"),
 		StorageType:       getEnv("STORAGE_TYPE", "both"),
@@ -51,7 +139,144 @@ func Load() (*Config, error) {
 		WebhookSecret:     getEnv("WEBHOOK_SECRET", ""),
 		VersionsFile:      getEnv("VERSIONS_FILE", "./data/versions.json"),
 		DatabaseURL:       getEnv("DATABASE_URL", ""),
-	}, nil
+		Channels:          channels,
+		SchedulerInterval: getEnvDuration("SCHEDULER_INTERVAL", 30*time.Second),
+		MaxUploadSize:     getEnvInt64("MAX_UPLOAD_SIZE", 512<<20),
+		TusStagingPath:    getEnv("TUS_STAGING_PATH", "./data/tus"),
+
+		SignatureTolerance: getEnvDuration("WEBHOOK_SIGNATURE_TOLERANCE", 5*time.Minute),
+		RequireSignature:   getEnvBool("WEBHOOK_REQUIRE_SIGNATURE", false),
+
+		IdempotencyTTL: getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+		UploadWorkers:   int(getEnvInt64("UPLOAD_WORKERS", 2)),
+		UploadQueueSize: int(getEnvInt64("UPLOAD_QUEUE_SIZE", 100)),
+
+		FetchAllowedHosts:    getEnvList("FETCH_ALLOWED_HOSTS"),
+		FetchHTTPSOnly:       getEnvBool("FETCH_HTTPS_ONLY", false),
+		FetchMaxRedirects:    getEnvCount("FETCH_MAX_REDIRECTS", 5),
+		FetchAllowPrivateIPs: getEnvBool("FETCH_ALLOW_PRIVATE_IPS", false),
+
+		GitHubAPIURL:       getEnv("GITHUB_API_URL", "https://api.github.com"),
+		GitHubToken:        getEnv("GITHUB_TOKEN", ""),
+		GitHubPollInterval: getEnvDuration("GITHUB_POLL_INTERVAL", 5*time.Minute),
+		DefaultApp:         getEnv("DEFAULT_APP", "sono"),
+	}
+
+	apps, err := loadApps(getEnv("APPS_FILE", ""), cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Apps = apps
+
+	return cfg, nil
+}
+
+//appNamePattern follows channel names: app names become part of file names,
+//storage prefixes and routes
+var appNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,19}$`)
+
+//loadApps reads the app definitions from a JSON file. Without one the
+//service serves only the default app, configured from the environment. The
+//default app keeps the unprefixed storage layout and VERSIONS_FILE, other
+//apps get their own prefix and versions file unless configured otherwise.
+func loadApps(file string, cfg *Config) ([]AppConfig, error) {
+	apps := []AppConfig{{Name: cfg.DefaultApp, PackageName: getEnv("PACKAGE_NAME", "")}}
+	for _, repo := range getEnvList("GITHUB_REPOS") {
+		apps[0].GitHub = append(apps[0].GitHub, GitHubSourceConfig{
+			Repo:         repo,
+			AssetPattern: getEnv("GITHUB_ASSET_PATTERN", ""),
+		})
+	}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading apps file: %w", err)
+		}
+		apps = nil
+		if err := json.Unmarshal(data, &apps); err != nil {
+			return nil, fmt.Errorf("parsing apps file: %w", err)
+		}
+	}
+
+	hasDefault := false
+	seen := make(map[string]bool, len(apps))
+	for i := range apps {
+		app := &apps[i]
+		if app.Name == "" {
+			return nil, fmt.Errorf("app %d has no name", i)
+		}
+		if !appNamePattern.MatchString(app.Name) {
+			return nil, fmt.Errorf("invalid app name %q: use up to 20 lowercase letters, digits, '-' or '_'", app.Name)
+		}
+		if seen[app.Name] {
+			return nil, fmt.Errorf("app %s is defined twice", app.Name)
+		}
+		seen[app.Name] = true
+		if app.DisplayName == "" {
+			app.DisplayName = app.Name
+		}
+		if app.WebhookSecret == "" {
+			app.WebhookSecret = cfg.WebhookSecret
+		}
+		for j := range app.GitHub {
+			source := &app.GitHub[j]
+			if strings.Count(source.Repo, "/") != 1 {
+				return nil, fmt.Errorf("app %s: github repo must be owner/name, got %q", app.Name, source.Repo)
+			}
+			if source.Token == "" {
+				source.Token = cfg.GitHubToken
+			}
+			if source.AssetPattern == "" {
+				source.AssetPattern = "*.apk"
+			}
+		}
+
+		if app.Name == cfg.DefaultApp {
+			hasDefault = true
+			if app.VersionsFile == "" {
+				app.VersionsFile = cfg.VersionsFile
+			}
+			continue
+		}
+
+		if app.StoragePrefix == "" {
+			app.StoragePrefix = app.Name + "/"
+		}
+		if app.VersionsFile == "" {
+			app.VersionsFile = filepath.Join(filepath.Dir(cfg.VersionsFile), "versions-"+app.Name+".json")
+		}
+	}
+
+	if !hasDefault {
+		return nil, fmt.Errorf("default app %q is not defined in the apps file", cfg.DefaultApp)
+	}
+	return apps, nil
+}
+
+//loadChannels reads channel definitions from a JSON file, or falls back to a
+//comma separated list of names with default settings
+func loadChannels(file, names string) ([]ChannelConfig, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading channels file: %w", err)
+		}
+		var channels []ChannelConfig
+		if err := json.Unmarshal(data, &channels); err != nil {
+			return nil, fmt.Errorf("parsing channels file: %w", err)
+		}
+		return channels, nil
+	}
+
+	var channels []ChannelConfig
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			channels = append(channels, ChannelConfig{Name: name})
+		}
+	}
+	return channels, nil
 }
 
 func getEnv(key, fallback string) string {
@@ -70,4 +295,49 @@ func getEnvBool(key string, fallback bool) bool {
 		return b
 	}
 	return fallback
+}
+
+//getEnvList splits a comma separated variable, skipping empty items
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	if value, exists := os.LookupEnv(key); exists {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return fallback
+		}
+		return n
+	}
+	return fallback
+}
+
+//getEnvCount is like getEnvInt64 but accepts 0, e.g. to turn a limit off
+func getEnvCount(key string, fallback int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fallback
+		}
+		return n
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fallback
+		}
+		return d
+	}
+	return fallback
 }
\ No newline at end of file
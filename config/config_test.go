@@ -0,0 +1,159 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLoadChannels(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "channels.json")
+	data := `[{"name": "stable", "retention": 10}, {"name": "internal", "visibility": "hidden"}]`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		names   string
+		want    []ChannelConfig
+		wantErr bool
+	}{
+		{"default list", "", "stable,beta,nightly", []ChannelConfig{{Name: "stable"}, {Name: "beta"}, {Name: "nightly"}}, false},
+		{"spaces and empty items", "", " stable, ,qa ,", []ChannelConfig{{Name: "stable"}, {Name: "qa"}}, false},
+		{"file wins over names", file, "beta", []ChannelConfig{{Name: "stable", Retention: 10}, {Name: "internal", Visibility: "hidden"}}, false},
+		{"missing file", filepath.Join(t.TempDir(), "missing.json"), "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels, err := loadChannels(tt.file, tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if len(channels) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", channels, tt.want)
+			}
+			for i := range channels {
+				got, want := channels[i], tt.want[i]
+				if got.Name != want.Name || got.Visibility != want.Visibility || got.Retention != want.Retention {
+					t.Errorf("channels[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadApps(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	apps := writeFile("apps.json", `[
+		{"name": "sono", "package_name": "com.sono"},
+		{"name": "tv", "display_name": "Sono TV", "webhook_secret": "tv-secret", "github": [{"repo": "sono/tv"}]},
+		{"name": "watch", "storage_prefix": "wear/", "versions_file": "/srv/watch.json"}
+	]`)
+	noDefault := writeFile("no-default.json", `[{"name": "tv"}]`)
+	noName := writeFile("no-name.json", `[{"name": "sono"}, {"display_name": "Unnamed"}]`)
+	badRepo := writeFile("bad-repo.json", `[{"name": "sono", "github": [{"repo": "sono"}]}]`)
+	invalid := writeFile("invalid.json", `{"name": "sono"}`)
+	duplicate := writeFile("duplicate.json", `[{"name": "sono"}, {"name": "tv"}, {"name": "tv", "storage_prefix": "tv2/"}]`)
+
+	t.Setenv("PACKAGE_NAME", "com.sono.env")
+	t.Setenv("GITHUB_REPOS", "sono/android")
+	t.Setenv("GITHUB_ASSET_PATTERN", "")
+	cfg := &Config{
+		DefaultApp:    "sono",
+		VersionsFile:  "/data/versions.json",
+		WebhookSecret: "secret",
+		GitHubToken:   "token",
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		want    []AppConfig
+		wantErr bool
+	}{
+		{
+			name: "environment only",
+			want: []AppConfig{{
+				Name: "sono", DisplayName: "sono", PackageName: "com.sono.env", WebhookSecret: "secret",
+				VersionsFile: "/data/versions.json",
+				GitHub:       []GitHubSourceConfig{{Repo: "sono/android", Token: "token", AssetPattern: "*.apk"}},
+			}},
+		},
+		{
+			name: "apps file",
+			file: apps,
+			want: []AppConfig{
+				{Name: "sono", DisplayName: "sono", PackageName: "com.sono", WebhookSecret: "secret", VersionsFile: "/data/versions.json"},
+				{
+					Name: "tv", DisplayName: "Sono TV", WebhookSecret: "tv-secret", StoragePrefix: "tv/",
+					VersionsFile: "/data/versions-tv.json",
+					GitHub:       []GitHubSourceConfig{{Repo: "sono/tv", Token: "token", AssetPattern: "*.apk"}},
+				},
+				{Name: "watch", DisplayName: "watch", WebhookSecret: "secret", StoragePrefix: "wear/", VersionsFile: "/srv/watch.json"},
+			},
+		},
+		{name: "default app missing", file: noDefault, wantErr: true},
+		{name: "app without name", file: noName, wantErr: true},
+		{name: "invalid github repo", file: badRepo, wantErr: true},
+		{name: "not a list", file: invalid, wantErr: true},
+		{name: "duplicate app", file: duplicate, wantErr: true},
+		{name: "missing file", file: filepath.Join(dir, "missing.json"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadApps(tt.file, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+
+	//names end up in file paths, storage prefixes and routes
+	for i, name := range []string{"../tv", "tv/beta", "{app}", "TV", ".tv", "tv app", "a-very-long-app-name-1"} {
+		file := writeFile("invalid-name-"+strconv.Itoa(i)+".json", `[{"name": "sono"}, {"name": "`+name+`"}]`)
+		if _, err := loadApps(file, cfg); err == nil {
+			t.Errorf("app name %q accepted", name)
+		}
+	}
+}
+
+func TestGetEnvCount(t *testing.T) {
+	tests := []struct {
+		value string
+		set   bool
+		want  int
+	}{
+		{"", false, 5},
+		{"3", true, 3},
+		{"0", true, 0},
+		{"-1", true, 5},
+		{"many", true, 5},
+		{"", true, 5},
+	}
+	//restores the variable after the test
+	t.Setenv("FETCH_MAX_REDIRECTS", "")
+	for _, tt := range tests {
+		if tt.set {
+			t.Setenv("FETCH_MAX_REDIRECTS", tt.value)
+		} else {
+			os.Unsetenv("FETCH_MAX_REDIRECTS")
+		}
+		if got := getEnvCount("FETCH_MAX_REDIRECTS", 5); got != tt.want {
+			t.Errorf("getEnvCount(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
\ No newline at end of file
@@ -3,14 +3,28 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+//DefaultApp is the app rows written before multi-app support belong to
+const DefaultApp = "sono"
+
 type DB struct {
 	conn *sql.DB
+	app  string
+}
+
+//WithApp returns a handle that scopes releases, downloads, upload logs and
+//release events to one app. It shares the connection pool with db.
+func (db *DB) WithApp(app string) *DB {
+	if db == nil {
+		return nil
+	}
+	return &DB{conn: db.conn, app: app}
 }
 
 func New(databaseURL string) (*DB, error) {
@@ -37,7 +51,7 @@ func New(databaseURL string) (*DB, error) {
 
 	log.Println("Database: Connected successfully")
 
-	db := &DB{conn: conn}
+	db := &DB{conn: conn, app: DefaultApp}
 	if err := db.initSchema(); err != nil {
 		log.Printf("Database: Warning - failed to initialize schema: %v", err)
 	}
@@ -52,6 +66,7 @@ func (db *DB) initSchema() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS releases (
 		id SERIAL PRIMARY KEY,
+		app VARCHAR(50) NOT NULL DEFAULT 'sono',
 		channel VARCHAR(20) NOT NULL,
 		version VARCHAR(50) NOT NULL,
 		version_code INTEGER NOT NULL,
@@ -60,12 +75,20 @@ func (db *DB) initSchema() error {
 		sha256 VARCHAR(64),
 		release_notes TEXT,
 		published_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		yanked BOOLEAN NOT NULL DEFAULT FALSE,
+		yank_reason TEXT,
+		yanked_at TIMESTAMP WITH TIME ZONE,
+		signer_sha256 VARCHAR(64),
+		artifacts JSONB,
+		localized_release_notes JSONB,
+		default_locale VARCHAR(35),
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-		UNIQUE(channel, version)
+		UNIQUE(app, channel, version)
 	);
 
 	CREATE TABLE IF NOT EXISTS downloads (
 		id SERIAL PRIMARY KEY,
+		app VARCHAR(50) NOT NULL DEFAULT 'sono',
 		release_id INTEGER REFERENCES releases(id),
 		channel VARCHAR(20) NOT NULL,
 		version VARCHAR(50) NOT NULL,
@@ -76,6 +99,7 @@ func (db *DB) initSchema() error {
 
 	CREATE TABLE IF NOT EXISTS upload_logs (
 		id SERIAL PRIMARY KEY,
+		app VARCHAR(50) NOT NULL DEFAULT 'sono',
 		channel VARCHAR(20) NOT NULL,
 		version VARCHAR(50) NOT NULL,
 		status VARCHAR(20) NOT NULL,
@@ -84,6 +108,49 @@ func (db *DB) initSchema() error {
 		uploaded_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 	);
 
+	CREATE TABLE IF NOT EXISTS release_events (
+		id SERIAL PRIMARY KEY,
+		app VARCHAR(50) NOT NULL DEFAULT 'sono',
+		channel VARCHAR(20) NOT NULL,
+		version VARCHAR(50) NOT NULL,
+		event VARCHAR(20) NOT NULL,
+		previous_version VARCHAR(50),
+		source_channel VARCHAR(20),
+		actor VARCHAR(255),
+		reason TEXT,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS upload_jobs (
+		id VARCHAR(32) PRIMARY KEY,
+		app VARCHAR(50) NOT NULL DEFAULT 'sono',
+		status VARCHAR(20) NOT NULL,
+		channel VARCHAR(20) NOT NULL,
+		version VARCHAR(50) NOT NULL,
+		source_url TEXT,
+		bytes_received BIGINT NOT NULL DEFAULT 0,
+		total_bytes BIGINT NOT NULL DEFAULT 0,
+		result JSONB,
+		error TEXT,
+		owner VARCHAR(100),
+		heartbeat_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		app VARCHAR(50) NOT NULL DEFAULT 'sono',
+		key VARCHAR(255) NOT NULL,
+		fingerprint VARCHAR(64),
+		completed BOOLEAN NOT NULL DEFAULT FALSE,
+		status_code INTEGER,
+		headers JSONB,
+		body BYTEA,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		PRIMARY KEY (app, key)
+	);
+
 	CREATE TABLE IF NOT EXISTS request_logs (
 		id SERIAL PRIMARY KEY,
 		endpoint VARCHAR(255) NOT NULL,
@@ -95,9 +162,32 @@ func (db *DB) initSchema() error {
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 	);
 
-	CREATE INDEX IF NOT EXISTS idx_releases_channel ON releases(channel);
-	CREATE INDEX IF NOT EXISTS idx_downloads_channel ON downloads(channel);
+	--databases created before multi-app support
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS app VARCHAR(50) NOT NULL DEFAULT 'sono';
+	ALTER TABLE releases DROP CONSTRAINT IF EXISTS releases_channel_version_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS releases_app_channel_version_key ON releases(app, channel, version);
+	ALTER TABLE downloads ADD COLUMN IF NOT EXISTS app VARCHAR(50) NOT NULL DEFAULT 'sono';
+	ALTER TABLE upload_logs ADD COLUMN IF NOT EXISTS app VARCHAR(50) NOT NULL DEFAULT 'sono';
+	ALTER TABLE release_events ADD COLUMN IF NOT EXISTS app VARCHAR(50) NOT NULL DEFAULT 'sono';
+	DROP INDEX IF EXISTS idx_releases_channel;
+	DROP INDEX IF EXISTS idx_downloads_channel;
+	DROP INDEX IF EXISTS idx_release_events_channel;
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS yanked BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS yank_reason TEXT;
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS yanked_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS signer_sha256 VARCHAR(64);
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS artifacts JSONB;
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS localized_release_notes JSONB;
+	ALTER TABLE releases ADD COLUMN IF NOT EXISTS default_locale VARCHAR(35);
+	ALTER TABLE upload_jobs ADD COLUMN IF NOT EXISTS owner VARCHAR(100);
+	ALTER TABLE upload_jobs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP WITH TIME ZONE;
+
+	CREATE INDEX IF NOT EXISTS idx_releases_app_channel ON releases(app, channel);
+	CREATE INDEX IF NOT EXISTS idx_downloads_app_channel ON downloads(app, channel);
 	CREATE INDEX IF NOT EXISTS idx_downloads_date ON downloads(downloaded_at);
+	CREATE INDEX IF NOT EXISTS idx_release_events_app_channel ON release_events(app, channel);
+	CREATE INDEX IF NOT EXISTS idx_upload_jobs_status ON upload_jobs(app, status);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
 	CREATE INDEX IF NOT EXISTS idx_request_logs_endpoint ON request_logs(endpoint);
 	CREATE INDEX IF NOT EXISTS idx_request_logs_date ON request_logs(created_at);
 	`
@@ -128,6 +218,11 @@ type Release struct {
 	SHA256       string
 	ReleaseNotes string
 	PublishedAt  time.Time
+	SignerSHA256 string
+	Artifacts    string //JSON encoded, empty for single APK releases
+
+	LocalizedNotes string //JSON encoded locale to notes map, empty if untranslated
+	DefaultLocale  string
 }
 
 func (db *DB) InsertRelease(ctx context.Context, r *Release) (int, error) {
@@ -137,17 +232,24 @@ func (db *DB) InsertRelease(ctx context.Context, r *Release) (int, error) {
 
 	var id int
 	err := db.conn.QueryRowContext(ctx, `
-		INSERT INTO releases (channel, version, version_code, file_name, file_size, sha256, release_notes, published_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT (channel, version) DO UPDATE SET
+		INSERT INTO releases (app, channel, version, version_code, file_name, file_size, sha256, release_notes, published_at, signer_sha256, artifacts, localized_release_notes, default_locale)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), NULLIF($11, '')::jsonb, NULLIF($12, '')::jsonb, NULLIF($13, ''))
+		ON CONFLICT (app, channel, version) DO UPDATE SET
 			version_code = EXCLUDED.version_code,
 			file_name = EXCLUDED.file_name,
 			file_size = EXCLUDED.file_size,
 			sha256 = EXCLUDED.sha256,
 			release_notes = EXCLUDED.release_notes,
-			published_at = EXCLUDED.published_at
+			published_at = EXCLUDED.published_at,
+			signer_sha256 = EXCLUDED.signer_sha256,
+			artifacts = EXCLUDED.artifacts,
+			localized_release_notes = EXCLUDED.localized_release_notes,
+			default_locale = EXCLUDED.default_locale,
+			yanked = FALSE,
+			yank_reason = NULL,
+			yanked_at = NULL
 		RETURNING id
-	`, r.Channel, r.Version, r.VersionCode, r.FileName, r.FileSize, r.SHA256, r.ReleaseNotes, r.PublishedAt).Scan(&id)
+	`, db.app, r.Channel, r.Version, r.VersionCode, r.FileName, r.FileSize, r.SHA256, r.ReleaseNotes, r.PublishedAt, r.SignerSHA256, r.Artifacts, r.LocalizedNotes, r.DefaultLocale).Scan(&id)
 
 	return id, err
 }
@@ -161,10 +263,10 @@ func (db *DB) GetLatestRelease(ctx context.Context, channel string) (*Release, e
 	err := db.conn.QueryRowContext(ctx, `
 		SELECT id, channel, version, version_code, file_name, file_size, sha256, release_notes, published_at
 		FROM releases
-		WHERE channel = $1
+		WHERE app = $1 AND channel = $2
 		ORDER BY published_at DESC
 		LIMIT 1
-	`, channel).Scan(&r.ID, &r.Channel, &r.Version, &r.VersionCode, &r.FileName, &r.FileSize, &r.SHA256, &r.ReleaseNotes, &r.PublishedAt)
+	`, db.app, channel).Scan(&r.ID, &r.Channel, &r.Version, &r.VersionCode, &r.FileName, &r.FileSize, &r.SHA256, &r.ReleaseNotes, &r.PublishedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -172,15 +274,86 @@ func (db *DB) GetLatestRelease(ctx context.Context, channel string) (*Release, e
 	return r, err
 }
 
+func (db *DB) ListReleases(ctx context.Context) ([]Release, error) {
+	if db == nil || db.conn == nil {
+		return nil, nil
+	}
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, channel, version, version_code, file_name, COALESCE(file_size, 0), COALESCE(sha256, ''), COALESCE(release_notes, ''), published_at, COALESCE(signer_sha256, ''), COALESCE(artifacts::text, ''), COALESCE(localized_release_notes::text, ''), COALESCE(default_locale, '')
+		FROM releases
+		WHERE app = $1
+		ORDER BY published_at ASC
+	`, db.app)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var releases []Release
+	for rows.Next() {
+		var r Release
+		if err := rows.Scan(&r.ID, &r.Channel, &r.Version, &r.VersionCode, &r.FileName, &r.FileSize, &r.SHA256, &r.ReleaseNotes, &r.PublishedAt, &r.SignerSHA256, &r.Artifacts, &r.LocalizedNotes, &r.DefaultLocale); err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+
+	return releases, rows.Err()
+}
+
+//ListChannelReleases returns the releases of a channel with a version code
+//in (after, upTo], highest version code first. Yanked releases are left out.
+func (db *DB) ListChannelReleases(ctx context.Context, channel string, after, upTo int) ([]Release, error) {
+	if db == nil || db.conn == nil {
+		return nil, nil
+	}
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, channel, version, version_code, file_name, COALESCE(file_size, 0), COALESCE(sha256, ''), COALESCE(release_notes, ''), published_at, COALESCE(signer_sha256, ''), COALESCE(artifacts::text, ''), COALESCE(localized_release_notes::text, ''), COALESCE(default_locale, '')
+		FROM releases
+		WHERE app = $1 AND channel = $2 AND version_code > $3 AND version_code <= $4 AND NOT yanked
+		ORDER BY version_code DESC, published_at DESC
+	`, db.app, channel, after, upTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var releases []Release
+	for rows.Next() {
+		var r Release
+		if err := rows.Scan(&r.ID, &r.Channel, &r.Version, &r.VersionCode, &r.FileName, &r.FileSize, &r.SHA256, &r.ReleaseNotes, &r.PublishedAt, &r.SignerSHA256, &r.Artifacts, &r.LocalizedNotes, &r.DefaultLocale); err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+
+	return releases, rows.Err()
+}
+
+func (db *DB) YankRelease(ctx context.Context, channel, version, reason string, yankedAt time.Time) error {
+	if db == nil || db.conn == nil {
+		return nil
+	}
+
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE releases SET yanked = TRUE, yank_reason = $4, yanked_at = $5
+		WHERE app = $1 AND channel = $2 AND version = $3
+	`, db.app, channel, version, reason, yankedAt)
+
+	return err
+}
+
 func (db *DB) LogDownload(ctx context.Context, channel, version, ipAddress, userAgent string) error {
 	if db == nil || db.conn == nil {
 		return nil
 	}
 
 	_, err := db.conn.ExecContext(ctx, `
-		INSERT INTO downloads (channel, version, ip_address, user_agent, release_id)
-		SELECT $1, $2, $3::inet, $4, id FROM releases WHERE channel = $1 AND version = $2
-	`, channel, version, ipAddress, userAgent)
+		INSERT INTO downloads (app, channel, version, ip_address, user_agent, release_id)
+		SELECT $1, $2, $3, $4::inet, $5, id FROM releases WHERE app = $1 AND channel = $2 AND version = $3
+	`, db.app, channel, version, ipAddress, userAgent)
 
 	return err
 }
@@ -191,9 +364,211 @@ func (db *DB) LogUpload(ctx context.Context, channel, version, status, message,
 	}
 
 	_, err := db.conn.ExecContext(ctx, `
-		INSERT INTO upload_logs (channel, version, status, message, source_url)
-		VALUES ($1, $2, $3, $4, $5)
-	`, channel, version, status, message, sourceURL)
+		INSERT INTO upload_logs (app, channel, version, status, message, source_url)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, db.app, channel, version, status, message, sourceURL)
+
+	return err
+}
+
+type ReleaseEvent struct {
+	Channel         string
+	Version         string
+	Event           string
+	PreviousVersion string
+	SourceChannel   string
+	Actor           string
+	Reason          string
+}
+
+func (db *DB) LogReleaseEvent(ctx context.Context, e *ReleaseEvent) error {
+	if db == nil || db.conn == nil {
+		return nil
+	}
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO release_events (app, channel, version, event, previous_version, source_channel, actor, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, db.app, e.Channel, e.Version, e.Event, e.PreviousVersion, e.SourceChannel, e.Actor, e.Reason)
+
+	return err
+}
+
+type UploadJob struct {
+	ID            string
+	Status        string
+	Channel       string
+	Version       string
+	SourceURL     string
+	BytesReceived int64
+	TotalBytes    int64
+	Result        string //JSON encoded release, empty until the job succeeded
+	Error         string
+	Owner         string //instance running the job
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+//SaveUploadJob stores a job and refreshes its heartbeat
+func (db *DB) SaveUploadJob(ctx context.Context, j *UploadJob) error {
+	if db == nil || db.conn == nil {
+		return nil
+	}
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO upload_jobs (id, app, status, channel, version, source_url, bytes_received, total_bytes, result, error, owner, heartbeat_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::jsonb, NULLIF($10, ''), $11, NOW(), $12, $13)
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			bytes_received = EXCLUDED.bytes_received,
+			total_bytes = EXCLUDED.total_bytes,
+			result = EXCLUDED.result,
+			error = EXCLUDED.error,
+			heartbeat_at = EXCLUDED.heartbeat_at,
+			updated_at = EXCLUDED.updated_at
+	`, j.ID, db.app, j.Status, j.Channel, j.Version, j.SourceURL, j.BytesReceived, j.TotalBytes, j.Result, j.Error, j.Owner, j.CreatedAt, j.UpdatedAt)
+
+	return err
+}
+
+//GetUploadJob returns nil if the job doesn't exist
+func (db *DB) GetUploadJob(ctx context.Context, id string) (*UploadJob, error) {
+	if db == nil || db.conn == nil {
+		return nil, nil
+	}
+
+	j := &UploadJob{}
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT id, status, channel, version, COALESCE(source_url, ''), bytes_received, total_bytes,
+			COALESCE(result::text, ''), COALESCE(error, ''), created_at, updated_at
+		FROM upload_jobs
+		WHERE app = $1 AND id = $2
+	`, db.app, id).Scan(&j.ID, &j.Status, &j.Channel, &j.Version, &j.SourceURL, &j.BytesReceived, &j.TotalBytes,
+		&j.Result, &j.Error, &j.CreatedAt, &j.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+//TouchUploadJobs refreshes the heartbeat of the unfinished jobs run by owner
+func (db *DB) TouchUploadJobs(ctx context.Context, owner string) error {
+	if db == nil || db.conn == nil {
+		return nil
+	}
+
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE upload_jobs SET heartbeat_at = NOW()
+		WHERE app = $1 AND owner = $2 AND status NOT IN ('succeeded', 'failed')
+	`, db.app, owner)
+	return err
+}
+
+//FailStaleUploadJobs marks jobs that were queued or running as failed if
+//their heartbeat is older than staleAfter, since the instance running them
+//is gone. Jobs saved before heartbeats existed count as stale.
+func (db *DB) FailStaleUploadJobs(ctx context.Context, staleAfter time.Duration, message string) (int64, error) {
+	if db == nil || db.conn == nil {
+		return 0, nil
+	}
+
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE upload_jobs SET status = 'failed', error = $2, updated_at = NOW()
+		WHERE app = $1 AND status NOT IN ('succeeded', 'failed')
+			AND (heartbeat_at IS NULL OR heartbeat_at < NOW() - $3 * INTERVAL '1 second')
+	`, db.app, message, staleAfter.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+type IdempotencyRecord struct {
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	Headers     string //JSON encoded
+	Body        []byte
+}
+
+//ErrIdempotencyKeyContended is returned when an idempotency key keeps being
+//deleted between claiming and reading it, the request can be retried
+var ErrIdempotencyKeyContended = errors.New("idempotency key was deleted while claiming it")
+
+//ClaimIdempotencyKey reserves key for a new request and returns nil. If the
+//key is already in use it returns the existing record instead. Expired keys
+//and claims abandoned before staleBefore are taken over.
+func (db *DB) ClaimIdempotencyKey(ctx context.Context, key string, expiresAt, staleBefore time.Time) (*IdempotencyRecord, error) {
+	if db == nil || db.conn == nil {
+		return nil, nil
+	}
+
+	if _, err := db.conn.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+
+	//the conflicting row can expire or be released before it is read, the
+	//insert is tried again then
+	for attempt := 0; attempt < 3; attempt++ {
+		var app string
+		err := db.conn.QueryRowContext(ctx, `
+			INSERT INTO idempotency_keys (app, key, expires_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (app, key) DO UPDATE SET
+				fingerprint = NULL,
+				completed = FALSE,
+				status_code = NULL,
+				headers = NULL,
+				body = NULL,
+				created_at = NOW(),
+				expires_at = EXCLUDED.expires_at
+			WHERE NOT idempotency_keys.completed AND idempotency_keys.created_at < $4
+			RETURNING app
+		`, db.app, key, expiresAt, staleBefore).Scan(&app)
+		if err == nil {
+			return nil, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		rec := &IdempotencyRecord{}
+		err = db.conn.QueryRowContext(ctx, `
+			SELECT COALESCE(fingerprint, ''), completed, COALESCE(status_code, 0), COALESCE(headers::text, ''), body
+			FROM idempotency_keys
+			WHERE app = $1 AND key = $2
+		`, db.app, key).Scan(&rec.Fingerprint, &rec.Completed, &rec.StatusCode, &rec.Headers, &rec.Body)
+		if err != sql.ErrNoRows {
+			return rec, err
+		}
+	}
+	return nil, ErrIdempotencyKeyContended
+}
+
+func (db *DB) CompleteIdempotencyKey(ctx context.Context, key string, rec *IdempotencyRecord) error {
+	if db == nil || db.conn == nil {
+		return nil
+	}
+
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET fingerprint = $3, completed = TRUE, status_code = $4, headers = NULLIF($5, '')::jsonb, body = $6
+		WHERE app = $1 AND key = $2
+	`, db.app, key, rec.Fingerprint, rec.StatusCode, rec.Headers, rec.Body)
+
+	return err
+}
+
+//ReleaseIdempotencyKey frees a claimed key, so the request can be retried
+func (db *DB) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if db == nil || db.conn == nil {
+		return nil
+	}
+
+	_, err := db.conn.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE app = $1 AND key = $2 AND NOT completed
+	`, db.app, key)
 
 	return err
 }
@@ -224,7 +599,13 @@ func (db *DB) GetDownloadStats(ctx context.Context) ([]DownloadStats, error) {
 		return nil, nil
 	}
 
-	rows, err := db.conn.QueryContext(ctx, `SELECT channel, version, download_count, first_download, last_download FROM download_stats`)
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT channel, version, COUNT(*), MIN(downloaded_at), MAX(downloaded_at)
+		FROM downloads
+		WHERE app = $1
+		GROUP BY channel, version
+		ORDER BY MAX(downloaded_at) DESC
+	`, db.app)
 	if err != nil {
 		return nil, err
 	}
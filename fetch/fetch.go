@@ -0,0 +1,162 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//ErrBlocked is wrapped by every error caused by the fetch policy rather than
+//the remote server
+var ErrBlocked = errors.New("blocked by fetch policy")
+
+type Config struct {
+	//hosts URLs may point to, "*.example.com" matches subdomains. Empty
+	//allows any host that resolves to a public address.
+	AllowedHosts []string
+
+	HTTPSOnly    bool
+	MaxRedirects int
+
+	//disables the private address check, for development setups that fetch
+	//from the local network
+	AllowPrivateIPs bool
+}
+
+//blocked ranges that netip doesn't classify
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), //carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), //benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), //NAT64 can reach any IPv4 address
+}
+
+//Client fetches URLs supplied by callers. The address of every connection is
+//checked after DNS resolution, so neither a hostname resolving to an internal
+//address nor a redirect can reach the internal network. Authorization headers
+//are dropped on redirects to another host.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+func NewClient(cfg Config, timeout time.Duration) *Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if cfg.AllowPrivateIPs {
+				return nil
+			}
+			return checkAddress(address)
+		},
+	}
+
+	transport := &http.Transport{
+		//a proxy would connect on our behalf and bypass the address check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &Client{cfg: cfg, http: &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return fmt.Errorf("%w: more than %d redirects", ErrBlocked, cfg.MaxRedirects)
+			}
+			if err := cfg.CheckURL(req.URL); err != nil {
+				return err
+			}
+			if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+				req.Header.Del("Authorization")
+			}
+			return nil
+		},
+	}}
+}
+
+//CheckURL validates the scheme and host of a URL against the policy
+func (cfg Config) CheckURL(u *url.URL) error {
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && !cfg.HTTPSOnly:
+	default:
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrBlocked, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: URLs with credentials are not allowed", ErrBlocked)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: URL has no host", ErrBlocked)
+	}
+	if len(cfg.AllowedHosts) == 0 {
+		return nil
+	}
+	for _, allowed := range cfg.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return nil
+			}
+		} else if host == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: host %s is not in the allowlist", ErrBlocked, host)
+}
+
+//Get fetches a URL after checking it against the policy
+func (c *Client) Get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid URL", ErrBlocked)
+	}
+	if err := c.cfg.CheckURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return c.http.Do(req)
+}
+
+func checkAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unexpected address %s", ErrBlocked, address)
+	}
+	ip := addrPort.Addr().Unmap()
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s is not a public address", ErrBlocked, ip)
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s is not a public address", ErrBlocked, ip)
+		}
+	}
+	return nil
+}
\ No newline at end of file
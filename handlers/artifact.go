@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var errTooLarge = errors.New("file exceeds the maximum upload size")
+
+//artifact is a received upload spooled to a temporary file. The file is
+//seekable, so storage backends can retry and know the size up front.
+type artifact struct {
+	file   *os.File
+	size   int64
+	sha256 string
+
+	//the file belongs to the caller and outlives the artifact
+	keep bool
+}
+
+//spool copies r to a temporary file, hashing it on the way, and fails with
+//errTooLarge once more than limit bytes have been read
+func spool(r io.Reader, limit int64) (*artifact, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp file: %w", err)
+	}
+	art := &artifact{file: file}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(r, limit+1))
+	if err == nil && size > limit {
+		err = errTooLarge
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		art.Close()
+		return nil, err
+	}
+
+	art.size = size
+	art.sha256 = hex.EncodeToString(hash.Sum(nil))
+	return art, nil
+}
+
+//Close removes the temporary file
+func (a *artifact) Close() error {
+	a.file.Close()
+	if a.keep {
+		return nil
+	}
+	return os.Remove(a.file.Name())
+}
+
+//openArtifact hashes a file that is already on disk, such as a completed
+//resumable upload. The file is left in place when the artifact is closed.
+func openArtifact(path string) (*artifact, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	art := &artifact{file: file, keep: true}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		art.Close()
+		return nil, err
+	}
+
+	art.size = size
+	art.sha256 = hex.EncodeToString(hash.Sum(nil))
+	return art, nil
+}
\ No newline at end of file
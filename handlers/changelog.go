@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/database"
+	"sono-version-service/models"
+)
+
+type ChangelogHandler struct {
+	versionStore *models.VersionStore
+	db           *database.DB
+}
+
+func NewChangelogHandler(vs *models.VersionStore, db *database.DB) *ChangelogHandler {
+	return &ChangelogHandler{versionStore: vs, db: db}
+}
+
+type ChangelogResponse struct {
+	Channel         models.Channel `json:"channel"`
+	FromVersionCode int            `json:"from_version_code"`
+	ToVersionCode   int            `json:"to_version_code"`
+	Releases        []ReleaseNote  `json:"releases"`
+}
+
+//Handle returns the notes of every release newer than the client's build up
+//to the one it would get now, newest first. ?format=markdown merges them into
+//one Markdown document.
+func (h *ChangelogHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	from, err := queryInt(r, "from_version_code", -1)
+	if err != nil || from < 0 {
+		http.Error(w, "from_version_code query parameter is required", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "markdown" {
+		http.Error(w, "Invalid format. Must be one of: json, markdown", http.StatusBadRequest)
+		return
+	}
+
+	current := h.versionStore.Resolve(channel, getInstallID(r))
+	if current == nil {
+		http.Error(w, "No version available for this channel", http.StatusNotFound)
+		return
+	}
+
+	resp := ChangelogResponse{
+		Channel:         channel,
+		FromVersionCode: from,
+		ToVersionCode:   current.VersionCode,
+		Releases:        []ReleaseNote{},
+	}
+	locales := preferredLocales(r)
+	for _, info := range h.releases(r.Context(), channel, from, current.VersionCode) {
+		notes, locale := info.NotesFor(locales)
+		resp.Releases = append(resp.Releases, ReleaseNote{
+			Version:      info.Version,
+			VersionCode:  info.VersionCode,
+			ReleaseNotes: notes,
+			Locale:       locale,
+			Mandatory:    info.Mandatory,
+			PublishedAt:  info.PublishedAt,
+		})
+	}
+
+	w.Header().Set("Vary", "Accept-Language")
+	if format == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(changelogMarkdown(resp.Releases)))
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+//releases returns the releases with a version code in (from, to], newest
+//first. The releases table keeps the full history, the store's (which
+//retention prunes) is used without a database or if the query fails.
+func (h *ChangelogHandler) releases(ctx context.Context, channel models.Channel, from, to int) []*models.VersionInfo {
+	if h.db != nil {
+		rows, err := h.db.ListChannelReleases(ctx, string(channel), from, to)
+		if err == nil {
+			releases := make([]*models.VersionInfo, 0, len(rows))
+			for _, rel := range rows {
+				info := &models.VersionInfo{
+					Channel:       channel,
+					Version:       rel.Version,
+					VersionCode:   rel.VersionCode,
+					ReleaseNotes:  rel.ReleaseNotes,
+					PublishedAt:   rel.PublishedAt,
+					DefaultLocale: rel.DefaultLocale,
+				}
+				if rel.LocalizedNotes != "" {
+					if err := json.Unmarshal([]byte(rel.LocalizedNotes), &info.LocalizedNotes); err != nil {
+						log.Printf("Failed to decode release notes of %s v%s: %v", channel, rel.Version, err)
+					}
+				}
+				//the mandatory flag is only kept in the store
+				if stored := h.versionStore.GetVersion(channel, rel.Version); stored != nil {
+					info.Mandatory = stored.Mandatory
+				}
+				releases = append(releases, info)
+			}
+			return releases
+		}
+		log.Printf("Failed to read changelog of %s from the database: %v", channel, err)
+	}
+
+	var releases []*models.VersionInfo
+	seen := make(map[string]bool)
+	for _, info := range h.versionStore.History(channel) {
+		if info.VersionCode <= from || info.VersionCode > to || info.IsYanked() || seen[info.Version] {
+			continue
+		}
+		seen[info.Version] = true
+		releases = append(releases, info)
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].VersionCode > releases[j].VersionCode
+	})
+	return releases
+}
+
+//changelogMarkdown merges release notes into one document with a section per
+//release
+func changelogMarkdown(releases []ReleaseNote) string {
+	var b strings.Builder
+	for i, note := range releases {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "## %s (%d) - %s\n", note.Version, note.VersionCode, note.PublishedAt.Format("2006-01-02"))
+		if note.Mandatory {
+			b.WriteString("\n**Mandatory update**\n")
+		}
+		if notes := strings.TrimSpace(note.ReleaseNotes); notes != "" {
+			fmt.Fprintf(&b, "\n%s\n", notes)
+		}
+	}
+	return b.String()
+}
\ No newline at end of file
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"sono-version-service/models"
+)
+
+func TestChangelog(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	publishTestRelease(t, store, models.ChannelStable, "1.1.0", 2)
+	localized := publishTestRelease(t, store, models.ChannelStable, "1.2.0", 3)
+	localized.DefaultLocale = "en"
+	localized.LocalizedNotes = map[string]string{"en": "Notes for 1.2.0", "de": "Hinweise zu 1.2.0"}
+	if err := store.Set(localized); err != nil {
+		t.Fatal(err)
+	}
+	publishTestRelease(t, store, models.ChannelStable, "1.3.0", 4)
+	store.Yank(models.ChannelStable, "1.1.0", models.YankInfo{Reason: "crash"})
+	store.SetMandatory(models.ChannelStable, "1.2.0", true)
+	//nobody gets 1.3.0 yet, so it isn't part of any changelog
+	store.SetRollout(models.ChannelStable, "1.3.0", 0)
+	h := NewChangelogHandler(store, nil)
+
+	tests := []struct {
+		name           string
+		target         string
+		acceptLanguage string
+		status         int
+		versions       []string
+		to             int
+	}{
+		{"from the first release", "/changelog/stable?from_version_code=0", "", http.StatusOK, []string{"1.2.0", "1.0.0"}, 3},
+		{"yanked releases are left out", "/changelog/stable?from_version_code=1", "", http.StatusOK, []string{"1.2.0"}, 3},
+		{"up to date", "/changelog/stable?from_version_code=3", "", http.StatusOK, []string{}, 3},
+		{"newer than the current release", "/changelog/stable?from_version_code=4", "", http.StatusOK, []string{}, 3},
+		{"json format", "/changelog/stable?from_version_code=1&format=json", "", http.StatusOK, []string{"1.2.0"}, 3},
+		{"localized", "/changelog/stable?from_version_code=1", "de-DE", http.StatusOK, []string{"1.2.0"}, 3},
+		{"missing from_version_code", "/changelog/stable", "", http.StatusBadRequest, nil, 0},
+		{"invalid from_version_code", "/changelog/stable?from_version_code=x", "", http.StatusBadRequest, nil, 0},
+		{"negative from_version_code", "/changelog/stable?from_version_code=-1", "", http.StatusBadRequest, nil, 0},
+		{"invalid format", "/changelog/stable?from_version_code=0&format=html", "", http.StatusBadRequest, nil, 0},
+		{"no release", "/changelog/beta?from_version_code=0", "", http.StatusNotFound, nil, 0},
+		{"unknown channel", "/changelog/canary?from_version_code=0", "", http.StatusBadRequest, nil, 0},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.acceptLanguage != "" {
+			header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		rec := serve(http.MethodGet, "/changelog/{channel}", h.Handle, tt.target, nil, header)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp ChangelogResponse
+		decodeJSON(t, rec, &resp)
+		versions := []string{}
+		for _, note := range resp.Releases {
+			versions = append(versions, note.Version)
+		}
+		if !reflect.DeepEqual(versions, tt.versions) || resp.ToVersionCode != tt.to {
+			t.Errorf("%s: releases %v up to %d, want %v up to %d", tt.name, versions, resp.ToVersionCode, tt.versions, tt.to)
+		}
+		if rec.Header().Get("Vary") != "Accept-Language" {
+			t.Errorf("%s: Vary = %q", tt.name, rec.Header().Get("Vary"))
+		}
+		for _, note := range resp.Releases {
+			if note.Version != "1.2.0" {
+				continue
+			}
+			want, locale := "Notes for 1.2.0", "en"
+			if tt.acceptLanguage == "de-DE" {
+				want, locale = "Hinweise zu 1.2.0", "de"
+			}
+			if note.ReleaseNotes != want || note.Locale != locale || !note.Mandatory {
+				t.Errorf("%s: 1.2.0 = %+v, want %q in %s, mandatory", tt.name, note, want, locale)
+			}
+		}
+	}
+
+	//clients in the rollout see the newest release too
+	store.SetRollout(models.ChannelStable, "1.3.0", 100)
+	rec := serve(http.MethodGet, "/changelog/{channel}", h.Handle, "/changelog/stable?from_version_code=2", nil, nil)
+	var resp ChangelogResponse
+	decodeJSON(t, rec, &resp)
+	if len(resp.Releases) != 2 || resp.Releases[0].Version != "1.3.0" || resp.ToVersionCode != 4 {
+		t.Errorf("after the rollout: %+v", resp)
+	}
+}
+
+func TestChangelogMarkdownFormat(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	publishTestRelease(t, store, models.ChannelStable, "1.1.0", 2)
+	h := NewChangelogHandler(store, nil)
+
+	rec := serve(http.MethodGet, "/changelog/{channel}", h.Handle, "/changelog/stable?from_version_code=0&format=markdown", nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/markdown; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	want := "## 1.1.0 (2) - 2026-01-01\n\nNotes for 1.1.0\n\n## 1.0.0 (1) - 2026-01-01\n\nNotes for 1.0.0\n"
+	if rec.Body.String() != want {
+		t.Errorf("body =\n%s\nwant\n%s", rec.Body, want)
+	}
+}
+
+func TestChangelogMarkdown(t *testing.T) {
+	published := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		releases []ReleaseNote
+		want     string
+	}{
+		{"no releases", nil, ""},
+		{"one release", []ReleaseNote{
+			{Version: "1.2.0", VersionCode: 3, ReleaseNotes: "- Faster sync", PublishedAt: published},
+		}, "## 1.2.0 (3) - 2026-03-14\n\n- Faster sync\n"},
+		{"mandatory", []ReleaseNote{
+			{Version: "1.2.0", VersionCode: 3, ReleaseNotes: "Security fix", Mandatory: true, PublishedAt: published},
+		}, "## 1.2.0 (3) - 2026-03-14\n\n**Mandatory update**\n\nSecurity fix\n"},
+		{"no notes", []ReleaseNote{
+			{Version: "1.2.0", VersionCode: 3, ReleaseNotes: " \n", PublishedAt: published},
+		}, "## 1.2.0 (3) - 2026-03-14\n"},
+		{"several releases", []ReleaseNote{
+			{Version: "1.2.0", VersionCode: 3, ReleaseNotes: "\nNew\n\n", PublishedAt: published},
+			{Version: "1.1.0", VersionCode: 2, ReleaseNotes: "Old", PublishedAt: published.AddDate(0, -1, 0)},
+		}, "## 1.2.0 (3) - 2026-03-14\n\nNew\n\n## 1.1.0 (2) - 2026-02-14\n\nOld\n"},
+	}
+	for _, tt := range tests {
+		if got := changelogMarkdown(tt.releases); got != tt.want {
+			t.Errorf("%s: changelogMarkdown =\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
\ No newline at end of file
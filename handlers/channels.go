@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/database"
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+type ChannelsHandler struct {
+	storage      storage.Storage
+	versionStore *models.VersionStore
+	db           *database.DB
+}
+
+func NewChannelsHandler(s storage.Storage, vs *models.VersionStore, db *database.DB) *ChannelsHandler {
+	return &ChannelsHandler{
+		storage:      s,
+		versionStore: vs,
+		db:           db,
+	}
+}
+
+type ChannelResponse struct {
+	models.ChannelInfo
+	LatestVersion     string `json:"latest_version,omitempty"`
+	LatestVersionCode int    `json:"latest_version_code,omitempty"`
+}
+
+//List returns the public channels so clients can discover them
+func (h *ChannelsHandler) List(w http.ResponseWriter, r *http.Request) {
+	channels := []ChannelResponse{}
+	for _, channel := range h.versionStore.ListChannels(false) {
+		resp := ChannelResponse{ChannelInfo: channel}
+		if current := h.versionStore.Get(channel.Name); current != nil {
+			resp.LatestVersion = current.Version
+			resp.LatestVersionCode = current.VersionCode
+		}
+		channels = append(channels, resp)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"channels": channels,
+	})
+}
+
+type ChannelRequest struct {
+	Name        models.Channel     `json:"name"`
+	DisplayName *string            `json:"display_name"`
+	Visibility  *models.Visibility `json:"visibility"`
+	Retention   *int               `json:"retention"`
+
+	//replaces the list, an empty list accepts any signer
+	AllowedSigners *[]string `json:"allowed_signers"`
+}
+
+func (req *ChannelRequest) Validate() bool {
+	if req.AllowedSigners != nil {
+		for _, fingerprint := range *req.AllowedSigners {
+			if _, ok := models.NormalizeFingerprint(fingerprint); !ok {
+				return false
+			}
+		}
+	}
+	return (req.Visibility == nil || req.Visibility.IsValid()) &&
+		(req.Retention == nil || *req.Retention >= 0)
+}
+
+func (req *ChannelRequest) apply(settings *models.ChannelSettings) {
+	if req.DisplayName != nil {
+		settings.DisplayName = *req.DisplayName
+	}
+	if req.Visibility != nil {
+		settings.Visibility = *req.Visibility
+	}
+	if req.Retention != nil {
+		settings.Retention = *req.Retention
+	}
+	if req.AllowedSigners != nil {
+		settings.AllowedSigners = append([]string(nil), *req.AllowedSigners...)
+	}
+}
+
+//Create registers a new channel at runtime
+func (h *ChannelsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req ChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Name.IsWellFormed() {
+		http.Error(w, "Invalid channel name: use up to 20 lowercase letters, digits, '-' or '_'", http.StatusBadRequest)
+		return
+	}
+	if !req.Validate() {
+		http.Error(w, "Invalid request: visibility must be public or hidden, retention must not be negative, allowed_signers must be SHA-256 fingerprints", http.StatusBadRequest)
+		return
+	}
+
+	var settings models.ChannelSettings
+	req.apply(&settings)
+
+	settings, err := h.versionStore.CreateChannel(req.Name, settings)
+	if errors.Is(err, models.ErrChannelExists) {
+		http.Error(w, fmt.Sprintf("Channel %s already exists", req.Name), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save channel settings: %v", err)
+		http.Error(w, "Failed to save channel settings", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created channel %s", req.Name)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"channel": models.ChannelInfo{Name: req.Name, ChannelSettings: settings},
+	})
+}
+
+//Update changes the display name, visibility or retention of a channel
+func (h *ChannelsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	var req ChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Validate() {
+		http.Error(w, "Invalid request: visibility must be public or hidden, retention must not be negative, allowed_signers must be SHA-256 fingerprints", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.versionStore.UpdateChannel(channel, req.apply)
+	if err != nil {
+		log.Printf("Failed to save channel settings: %v", err)
+		http.Error(w, "Failed to save channel settings", http.StatusInternalServerError)
+		return
+	}
+	pruneReleases(r.Context(), h.storage, h.versionStore, channel)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"channel": models.ChannelInfo{Name: channel, ChannelSettings: settings},
+	})
+}
+
+//pruneReleases enforces the retention limit of a channel and removes the
+//artifacts of dropped releases unless another release still references them
+func pruneReleases(ctx context.Context, s storage.Storage, vs *models.VersionStore, channel models.Channel) {
+	pruned, err := vs.Prune(channel)
+	if err != nil {
+		log.Printf("Failed to prune %s releases: %v", channel, err)
+		return
+	}
+
+	for _, info := range pruned {
+		for _, artifact := range info.Files() {
+			if vs.ArtifactInUse(artifact.FileName) {
+				continue
+			}
+			if err := s.Delete(ctx, artifact.FileName); err != nil {
+				log.Printf("Failed to delete pruned file %s: %v", artifact.FileName, err)
+			}
+		}
+		log.Printf("Pruned %s v%s", channel, info.Version)
+	}
+}
+
+type RollbackRequest struct {
+	Version     string `json:"version"` //empty rolls back to the previous release
+	Reason      string `json:"reason"`
+	PerformedBy string `json:"performed_by"`
+}
+
+//Rollback points a channel back at a previously published release, reusing
+//the artifact that is already in storage
+func (h *ChannelsHandler) Rollback(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	//the body is optional, an empty one rolls back to the previous release
+	var req RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	current := h.versionStore.Get(channel)
+	if current == nil {
+		http.Error(w, "No version available for this channel", http.StatusNotFound)
+		return
+	}
+
+	target, err := h.versionStore.RollbackTarget(channel, req.Version)
+	if errors.Is(err, models.ErrReleaseNotFound) {
+		http.Error(w, fmt.Sprintf("Release %s not found in channel history", req.Version), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrNoPreviousRelease) {
+		http.Error(w, "No previous release to roll back to", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, models.ErrReleaseYanked) {
+		http.Error(w, fmt.Sprintf("Release %s has been yanked", req.Version), http.StatusConflict)
+		return
+	}
+	if target.Version == current.Version {
+		http.Error(w, fmt.Sprintf("Channel is already on %s", target.Version), http.StatusConflict)
+		return
+	}
+
+	for _, artifact := range target.Files() {
+		exists, err := h.storage.Exists(r.Context(), artifact.FileName)
+		if err != nil || !exists {
+			log.Printf("Rollback target artifact missing: %s (%v)", artifact.FileName, err)
+			http.Error(w, "Artifact for the target release is no longer in storage", http.StatusConflict)
+			return
+		}
+	}
+
+	if _, err := h.versionStore.SetCurrent(channel, target.Version); err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	actor := actorFor(r, req.PerformedBy)
+	if h.db != nil {
+		if err := h.db.LogReleaseEvent(r.Context(), &database.ReleaseEvent{
+			Channel:         string(channel),
+			Version:         target.Version,
+			Event:           "rollback",
+			PreviousVersion: current.Version,
+			Actor:           actor,
+			Reason:          req.Reason,
+		}); err != nil {
+			log.Printf("Failed to log rollback: %v", err)
+		}
+	}
+	log.Printf("Rolled back %s from v%s to v%s (by %s)", channel, current.Version, target.Version, actor)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Rolled back %s from v%s to v%s", channel, current.Version, target.Version),
+		"version": target,
+	})
+}
+
+type RolloutRequest struct {
+	Version     string `json:"version"` //empty targets the current release
+	Percentage  *int   `json:"percentage"`
+	Reason      string `json:"reason"`
+	PerformedBy string `json:"performed_by"`
+}
+
+//Rollout changes the staged rollout percentage of a release without a
+//re-upload. Devices outside the rollout keep receiving the previous release.
+func (h *ChannelsHandler) Rollout(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	var req RolloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Percentage == nil || *req.Percentage < 0 || *req.Percentage > 100 {
+		http.Error(w, "percentage must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+
+	version := req.Version
+	if version == "" {
+		current := h.versionStore.Get(channel)
+		if current == nil {
+			http.Error(w, "No version available for this channel", http.StatusNotFound)
+			return
+		}
+		version = current.Version
+	}
+
+	versionInfo, err := h.versionStore.SetRollout(channel, version, *req.Percentage)
+	if errors.Is(err, models.ErrReleaseNotFound) {
+		http.Error(w, fmt.Sprintf("Release %s not found in channel history", version), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	actor := actorFor(r, req.PerformedBy)
+	if h.db != nil {
+		reason := fmt.Sprintf("%d%%", *req.Percentage)
+		if req.Reason != "" {
+			reason += ": " + req.Reason
+		}
+		if err := h.db.LogReleaseEvent(r.Context(), &database.ReleaseEvent{
+			Channel: string(channel),
+			Version: version,
+			Event:   "rollout",
+			Actor:   actor,
+			Reason:  reason,
+		}); err != nil {
+			log.Printf("Failed to log rollout change: %v", err)
+		}
+	}
+	log.Printf("Set rollout of %s v%s to %d%% (by %s)", channel, version, *req.Percentage, actor)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Rollout of %s v%s set to %d%%", channel, version, *req.Percentage),
+		"version": versionInfo,
+	})
+}
+
+type PolicyRequest struct {
+	MinSupportedVersionCode *int   `json:"min_supported_version_code"`
+	Reason                  string `json:"reason"`
+	PerformedBy             string `json:"performed_by"`
+}
+
+//Policy updates the minimum supported version_code of a channel. Clients
+//below it must update before they can continue.
+func (h *ChannelsHandler) Policy(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	var req PolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.MinSupportedVersionCode == nil || *req.MinSupportedVersionCode < 0 {
+		http.Error(w, "min_supported_version_code must be zero or greater", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.versionStore.UpdateChannel(channel, func(settings *models.ChannelSettings) {
+		settings.MinSupportedVersionCode = *req.MinSupportedVersionCode
+	})
+	if err != nil {
+		log.Printf("Failed to save channel settings: %v", err)
+		http.Error(w, "Failed to save channel settings", http.StatusInternalServerError)
+		return
+	}
+
+	actor := actorFor(r, req.PerformedBy)
+	if h.db != nil {
+		reason := fmt.Sprintf("min_supported_version_code=%d", settings.MinSupportedVersionCode)
+		if req.Reason != "" {
+			reason += ": " + req.Reason
+		}
+		if err := h.db.LogReleaseEvent(r.Context(), &database.ReleaseEvent{
+			Channel: string(channel),
+			Version: "",
+			Event:   "policy",
+			Actor:   actor,
+			Reason:  reason,
+		}); err != nil {
+			log.Printf("Failed to log policy change: %v", err)
+		}
+	}
+	log.Printf("Set min supported version code of %s to %d (by %s)", channel, settings.MinSupportedVersionCode, actor)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"channel":  channel,
+		"settings": settings,
+	})
+}
\ No newline at end of file
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+//storeTestArtifacts puts placeholder files for the artifacts of a release
+//into storage
+func storeTestArtifacts(t *testing.T, s storage.Storage, info *models.VersionInfo) {
+	t.Helper()
+	for _, artifact := range info.Files() {
+		if err := s.Upload(context.Background(), artifact.FileName, strings.NewReader("apk"), 3, artifact.ContentType); err != nil {
+			t.Fatalf("Upload(%s): %v", artifact.FileName, err)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		missing bool //the target's file was deleted from storage
+		status  int
+		current string
+	}{
+		{"previous release", "", false, http.StatusOK, "1.1.0"},
+		{"empty object", "{}", false, http.StatusOK, "1.1.0"},
+		{"explicit version", `{"version": "1.0.0", "reason": "crash"}`, false, http.StatusOK, "1.0.0"},
+		{"current version", `{"version": "1.2.0"}`, false, http.StatusConflict, "1.2.0"},
+		{"unknown version", `{"version": "0.1.0"}`, false, http.StatusNotFound, "1.2.0"},
+		{"artifact missing", "", true, http.StatusConflict, "1.2.0"},
+		{"invalid body", "{", false, http.StatusBadRequest, "1.2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			s := newTestStorage(t)
+			for i, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+				info := publishTestRelease(t, store, models.ChannelStable, version, i+1)
+				if !tt.missing || version == "1.2.0" {
+					storeTestArtifacts(t, s, info)
+				}
+			}
+			h := NewChannelsHandler(s, store, nil)
+
+			rec := serve(http.MethodPost, "/channels/{channel}/rollback", h.Rollback, "/channels/stable/rollback", strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if current := store.Get(models.ChannelStable); current.Version != tt.current {
+				t.Errorf("current = %s, want %s", current.Version, tt.current)
+			}
+			if n := len(store.History(models.ChannelStable)); n != 3 {
+				t.Errorf("history has %d releases, want 3", n)
+			}
+		})
+	}
+}
+
+func TestRollbackWithoutRelease(t *testing.T) {
+	h := NewChannelsHandler(newTestStorage(t), newTestStore(t), nil)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/channels/stable/rollback", http.StatusNotFound},
+		{"/channels/canary/rollback", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodPost, "/channels/{channel}/rollback", h.Rollback, tt.target, nil, nil)
+		if rec.Code != tt.status {
+			t.Errorf("POST %s = %d, want %d", tt.target, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestRollout(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		version string
+		rollout int
+	}{
+		{"current release", `{"percentage": 20}`, http.StatusOK, "1.1.0", 20},
+		{"earlier release", `{"version": "1.0.0", "percentage": 5}`, http.StatusOK, "1.0.0", 5},
+		{"halt", `{"percentage": 0}`, http.StatusOK, "1.1.0", 0},
+		{"complete", `{"percentage": 100}`, http.StatusOK, "1.1.0", 100},
+		{"missing percentage", `{}`, http.StatusBadRequest, "", 0},
+		{"negative", `{"percentage": -1}`, http.StatusBadRequest, "", 0},
+		{"above 100", `{"percentage": 101}`, http.StatusBadRequest, "", 0},
+		{"unknown release", `{"version": "9.9.9", "percentage": 5}`, http.StatusNotFound, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+			publishTestRelease(t, store, models.ChannelStable, "1.1.0", 2)
+			h := NewChannelsHandler(newTestStorage(t), store, nil)
+
+			rec := serve(http.MethodPost, "/channels/{channel}/rollout", h.Rollout, "/channels/stable/rollout", strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := store.GetVersion(models.ChannelStable, tt.version).Rollout(); got != tt.rollout {
+				t.Errorf("rollout of %s = %d, want %d", tt.version, got, tt.rollout)
+			}
+			//the channel keeps pointing at its current release
+			if current := store.Get(models.ChannelStable); current.Version != "1.1.0" {
+				t.Errorf("current = %s, want 1.1.0", current.Version)
+			}
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   int
+	}{
+		{"set", `{"min_supported_version_code": 42}`, http.StatusOK, 42},
+		{"clear", `{"min_supported_version_code": 0}`, http.StatusOK, 0},
+		{"missing", `{}`, http.StatusBadRequest, 7},
+		{"negative", `{"min_supported_version_code": -1}`, http.StatusBadRequest, 7},
+		{"invalid body", `{`, http.StatusBadRequest, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			store.UpdateChannel(models.ChannelStable, func(s *models.ChannelSettings) { s.MinSupportedVersionCode = 7 })
+			h := NewChannelsHandler(newTestStorage(t), store, nil)
+
+			rec := serve(http.MethodPut, "/channels/{channel}/policy", h.Policy, "/channels/stable/policy", strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := store.ChannelSettings(models.ChannelStable).MinSupportedVersionCode; got != tt.want {
+				t.Errorf("MinSupportedVersionCode = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateChannel(t *testing.T) {
+	fingerprint := strings.Repeat("AB:", 31) + "AB"
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"defaults", `{"name": "qa"}`, http.StatusCreated},
+		{"settings", `{"name": "internal", "display_name": "Internal", "visibility": "hidden", "retention": 3}`, http.StatusCreated},
+		{"keytool fingerprint", `{"name": "signed", "allowed_signers": ["` + fingerprint + `"]}`, http.StatusCreated},
+		{"existing", `{"name": "stable"}`, http.StatusConflict},
+		{"invalid name", `{"name": "QA"}`, http.StatusBadRequest},
+		{"missing name", `{}`, http.StatusBadRequest},
+		{"invalid visibility", `{"name": "qa", "visibility": "secret"}`, http.StatusBadRequest},
+		{"negative retention", `{"name": "qa", "retention": -1}`, http.StatusBadRequest},
+		{"invalid fingerprint", `{"name": "qa", "allowed_signers": ["abc"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			h := NewChannelsHandler(newTestStorage(t), store, nil)
+
+			rec := serve(http.MethodPost, "/channels", h.Create, "/channels", strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestListChannels(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelBeta, "2.0.0", 20)
+	store.UpdateChannel(models.ChannelNightly, func(s *models.ChannelSettings) { s.Visibility = models.VisibilityHidden })
+	h := NewChannelsHandler(newTestStorage(t), store, nil)
+
+	rec := serve(http.MethodGet, "/channels", h.List, "/channels", nil, nil)
+	var resp struct {
+		Channels []ChannelResponse `json:"channels"`
+	}
+	decodeJSON(t, rec, &resp)
+
+	want := map[models.Channel]string{"beta": "2.0.0", "stable": ""}
+	if len(resp.Channels) != len(want) {
+		t.Fatalf("got %d channels, want %d: %+v", len(resp.Channels), len(want), resp.Channels)
+	}
+	for _, channel := range resp.Channels {
+		latest, ok := want[channel.Name]
+		if !ok {
+			t.Errorf("hidden or unknown channel %s listed", channel.Name)
+		}
+		if channel.LatestVersion != latest {
+			t.Errorf("%s latest = %q, want %q", channel.Name, channel.LatestVersion, latest)
+		}
+	}
+}
+
+func TestUpdateChannelPrunes(t *testing.T) {
+	store := newTestStore(t)
+	s := newTestStorage(t)
+	var releases []*models.VersionInfo
+	for i, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		info := publishTestRelease(t, store, models.ChannelStable, version, i+1)
+		storeTestArtifacts(t, s, info)
+		releases = append(releases, info)
+	}
+	h := NewChannelsHandler(s, store, nil)
+
+	rec := serve(http.MethodPost, "/channels/{channel}", h.Update, "/channels/stable", strings.NewReader(`{"retention": 1}`), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if n := len(store.History(models.ChannelStable)); n != 1 {
+		t.Errorf("history has %d releases, want 1", n)
+	}
+	for i, info := range releases {
+		exists, _ := s.Exists(context.Background(), info.FileName)
+		if want := i == len(releases)-1; exists != want {
+			t.Errorf("%s in storage = %v, want %v", info.FileName, exists, want)
+		}
+	}
+
+	rec = serve(http.MethodPost, "/channels/{channel}", h.Update, "/channels/canary", strings.NewReader(`{}`), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("updating an unknown channel = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
\ No newline at end of file
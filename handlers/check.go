@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/models"
+)
+
+type CheckHandler struct {
+	versionStore *models.VersionStore
+}
+
+func NewCheckHandler(vs *models.VersionStore) *CheckHandler {
+	return &CheckHandler{versionStore: vs}
+}
+
+type ReleaseNote struct {
+	Version      string    `json:"version"`
+	VersionCode  int       `json:"version_code"`
+	ReleaseNotes string    `json:"release_notes"`
+	Locale       string    `json:"locale,omitempty"`
+	Mandatory    bool      `json:"mandatory"`
+	PublishedAt  time.Time `json:"published_at"`
+}
+
+type CheckResponse struct {
+	Channel                 models.Channel      `json:"channel"`
+	CurrentVersionCode      int                 `json:"current_version_code"`
+	UpdateAvailable         bool                `json:"update_available"`
+	Mandatory               bool                `json:"mandatory"`
+	MinSupportedVersionCode int                 `json:"min_supported_version_code"`
+	Release                 *models.VersionInfo `json:"release"`
+	ReleaseNotes            []ReleaseNote       `json:"release_notes"`
+	YankNotice              *YankNotice         `json:"yank_notice,omitempty"`
+}
+
+//Handle compares the client's build against the channel and tells it whether
+//to update, so the decision logic lives on the server
+func (h *CheckHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	versionCode, err := queryInt(r, "version_code", -1)
+	if err != nil || versionCode < 0 {
+		http.Error(w, "version_code query parameter is required", http.StatusBadRequest)
+		return
+	}
+	sdk, err := queryInt(r, "sdk", 0)
+	if err != nil || sdk < 0 {
+		http.Error(w, "Invalid sdk parameter", http.StatusBadRequest)
+		return
+	}
+	abi := r.URL.Query().Get("abi")
+
+	settings := h.versionStore.ChannelSettings(channel)
+	resp := CheckResponse{
+		Channel:                 channel,
+		CurrentVersionCode:      versionCode,
+		MinSupportedVersionCode: settings.MinSupportedVersionCode,
+		ReleaseNotes:            []ReleaseNote{},
+	}
+
+	installID := getInstallID(r)
+
+	//a yanked build must move to its replacement, even if that is a downgrade
+	if notice := yankNoticeFor(h.versionStore, channel, versionCode, installID); notice != nil {
+		resp.YankNotice = notice
+		resp.Mandatory = true
+		if notice.Replacement != nil && notice.Replacement.VersionCode != versionCode {
+			resp.UpdateAvailable = true
+			resp.Release = notice.Replacement
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	target := h.selectTarget(channel, installID, sdk, abi)
+	if target != nil && target.VersionCode > versionCode {
+		resp.UpdateAvailable = true
+		resp.Release = target
+		resp.Mandatory = versionCode < settings.MinSupportedVersionCode
+
+		locales := preferredLocales(r)
+		seen := make(map[string]bool)
+		for _, info := range h.versionStore.History(channel) {
+			if info.VersionCode <= versionCode || info.VersionCode > target.VersionCode || info.IsYanked() || seen[info.Version] {
+				continue
+			}
+			seen[info.Version] = true
+			resp.Mandatory = resp.Mandatory || info.Mandatory
+			notes, locale := info.NotesFor(locales)
+			resp.ReleaseNotes = append(resp.ReleaseNotes, ReleaseNote{
+				Version:      info.Version,
+				VersionCode:  info.VersionCode,
+				ReleaseNotes: notes,
+				Locale:       locale,
+				Mandatory:    info.Mandatory,
+				PublishedAt:  info.PublishedAt,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+//selectTarget picks the newest release the device may install: the release
+//its rollout bucket resolves to, or an earlier one if the device can't run it
+func (h *CheckHandler) selectTarget(channel models.Channel, installID string, sdk int, abi string) *models.VersionInfo {
+	resolved := h.versionStore.Resolve(channel, installID)
+	if resolved == nil {
+		return nil
+	}
+	if resolved.SupportsDevice(sdk, abi) {
+		return resolved
+	}
+
+	reached := false
+	for _, info := range h.versionStore.History(channel) {
+		if info.Version == resolved.Version {
+			reached = true
+			continue
+		}
+		if reached && !info.IsYanked() && info.InRollout(installID) && info.SupportsDevice(sdk, abi) {
+			return info
+		}
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"sono-version-service/models"
+)
+
+func TestCheck(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	publishTestRelease(t, store, models.ChannelStable, "1.1.0", 2)
+	latest := *publishTestRelease(t, store, models.ChannelStable, "1.2.0", 3)
+	latest.MinSdk = 30
+	store.Set(&latest)
+	h := NewCheckHandler(store)
+
+	tests := []struct {
+		name      string
+		query     string
+		policy    int //min_supported_version_code of the channel
+		mandatory string
+		status    int
+		update    bool
+		target    int
+		notes     []int //version codes of the release notes
+		forced    bool
+	}{
+		{name: "up to date", query: "version_code=3", status: http.StatusOK},
+		{name: "newer than the channel", query: "version_code=9", status: http.StatusOK},
+		{name: "one behind", query: "version_code=2", status: http.StatusOK, update: true, target: 3, notes: []int{3}},
+		{name: "two behind", query: "version_code=1", status: http.StatusOK, update: true, target: 3, notes: []int{3, 2}},
+		{name: "fresh install", query: "version_code=0", status: http.StatusOK, update: true, target: 3, notes: []int{3, 2, 1}},
+		{name: "skipped mandatory release", query: "version_code=1", mandatory: "1.1.0", status: http.StatusOK, update: true, target: 3, notes: []int{3, 2}, forced: true},
+		{name: "mandatory release installed", query: "version_code=2", mandatory: "1.1.0", status: http.StatusOK, update: true, target: 3, notes: []int{3}},
+		{name: "below min supported", query: "version_code=1", policy: 2, status: http.StatusOK, update: true, target: 3, notes: []int{3, 2}, forced: true},
+		{name: "at min supported", query: "version_code=2", policy: 2, status: http.StatusOK, update: true, target: 3, notes: []int{3}},
+		{name: "sdk too old for the latest", query: "version_code=1&sdk=29", status: http.StatusOK, update: true, target: 2, notes: []int{2}},
+		{name: "sdk new enough", query: "version_code=1&sdk=30", status: http.StatusOK, update: true, target: 3, notes: []int{3, 2}},
+		{name: "missing version_code", query: "", status: http.StatusBadRequest},
+		{name: "negative version_code", query: "version_code=-1", status: http.StatusBadRequest},
+		{name: "invalid sdk", query: "version_code=1&sdk=x", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.UpdateChannel(models.ChannelStable, func(s *models.ChannelSettings) { s.MinSupportedVersionCode = tt.policy })
+			for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+				store.SetMandatory(models.ChannelStable, version, version == tt.mandatory)
+			}
+
+			rec := serve(http.MethodGet, "/check/{channel}", h.Handle, "/check/stable?"+tt.query, nil, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp CheckResponse
+			decodeJSON(t, rec, &resp)
+			if resp.UpdateAvailable != tt.update {
+				t.Errorf("UpdateAvailable = %v, want %v", resp.UpdateAvailable, tt.update)
+			}
+			if resp.Mandatory != tt.forced {
+				t.Errorf("Mandatory = %v, want %v", resp.Mandatory, tt.forced)
+			}
+			if tt.update && (resp.Release == nil || resp.Release.VersionCode != tt.target) {
+				t.Errorf("Release = %+v, want version_code %d", resp.Release, tt.target)
+			}
+			if !tt.update && resp.Release != nil {
+				t.Errorf("Release = %s, want none", resp.Release.Version)
+			}
+			if len(resp.ReleaseNotes) != len(tt.notes) {
+				t.Fatalf("got %d release notes, want %v", len(resp.ReleaseNotes), tt.notes)
+			}
+			for i, note := range resp.ReleaseNotes {
+				if note.VersionCode != tt.notes[i] {
+					t.Errorf("release_notes[%d] is version_code %d, want %d", i, note.VersionCode, tt.notes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckUnknownChannel(t *testing.T) {
+	h := NewCheckHandler(newTestStore(t))
+	rec := serve(http.MethodGet, "/check/{channel}", h.Handle, "/check/canary?version_code=1", nil, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCheckYanked(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	publishTestRelease(t, store, models.ChannelStable, "1.1.0", 2)
+	store.Yank(models.ChannelStable, "1.1.0", models.YankInfo{Reason: "crash"})
+	h := NewCheckHandler(store)
+
+	//the yanked build is told to downgrade, even though nothing newer exists
+	rec := serve(http.MethodGet, "/check/{channel}", h.Handle, "/check/stable?version_code=2", nil, nil)
+	var resp CheckResponse
+	decodeJSON(t, rec, &resp)
+	if resp.YankNotice == nil || !resp.UpdateAvailable || !resp.Mandatory {
+		t.Fatalf("check = %+v, want a mandatory update with a yank notice", resp)
+	}
+	if resp.Release == nil || resp.Release.Version != "1.0.0" {
+		t.Errorf("release = %+v, want 1.0.0", resp.Release)
+	}
+
+	rec = serve(http.MethodGet, "/check/{channel}", h.Handle, "/check/stable?version_code=1", nil, nil)
+	resp = CheckResponse{}
+	decodeJSON(t, rec, &resp)
+	if resp.YankNotice != nil || resp.UpdateAvailable {
+		t.Errorf("check of the fallback release = %+v, want no update", resp)
+	}
+}
\ No newline at end of file
@@ -12,6 +12,7 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"sono-version-service/database"
+	"sono-version-service/middleware"
 	"sono-version-service/models"
 	"sono-version-service/storage"
 )
@@ -20,13 +21,17 @@ type DownloadHandler struct {
 	storage      storage.Storage
 	versionStore *models.VersionStore
 	db           *database.DB
+	app          string
+	admin        *middleware.Verifier
 }
 
-func NewDownloadHandler(s storage.Storage, vs *models.VersionStore, db *database.DB) *DownloadHandler {
+func NewDownloadHandler(s storage.Storage, vs *models.VersionStore, db *database.DB, app string, admin *middleware.Verifier) *DownloadHandler {
 	return &DownloadHandler{
 		storage:      s,
 		versionStore: vs,
 		db:           db,
+		app:          app,
+		admin:        admin,
 	}
 }
 
@@ -34,21 +39,67 @@ func (h *DownloadHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	channelStr := chi.URLParam(r, "channel")
 	channel := models.Channel(channelStr)
 
-	if !channel.IsValid() {
-		http.Error(w, "Invalid channel. Must be: stable, beta, or nightly", http.StatusBadRequest)
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
 		return
 	}
 
-	versionInfo := h.versionStore.Get(channel)
+	versionInfo := h.versionStore.Resolve(channel, getInstallID(r))
 	if versionInfo == nil {
 		http.Error(w, "No version available for this channel", http.StatusNotFound)
 		return
 	}
 
-	reader, size, err := h.storage.Download(r.Context(), versionInfo.FileName)
+	h.serve(w, r, channel, versionInfo)
+}
+
+//HandleVersion serves a specific release. Yanked releases are refused unless
+//an admin passes override=true with valid credentials.
+func (h *DownloadHandler) HandleVersion(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	versionInfo := h.versionStore.GetVersion(channel, chi.URLParam(r, "version"))
+	if versionInfo == nil {
+		http.Error(w, "Release not found", http.StatusNotFound)
+		return
+	}
+
+	if versionInfo.IsYanked() {
+		override := r.URL.Query().Get("override") == "true"
+		if !override || !h.admin.Authorized(r) {
+			http.Error(w, fmt.Sprintf("v%s has been yanked: %s", versionInfo.Version, versionInfo.Yanked.Reason), http.StatusGone)
+			return
+		}
+		log.Printf("Serving yanked %s v%s to %s via admin override", channel, versionInfo.Version, getClientIP(r))
+	}
+
+	h.serve(w, r, channel, versionInfo)
+}
+
+//serve streams the file of a release that fits the device described by the
+//platform, abi, density and sdk query parameters, falling back to the
+//universal APK
+func (h *DownloadHandler) serve(w http.ResponseWriter, r *http.Request, channel models.Channel, versionInfo *models.VersionInfo) {
+	sdk, err := queryInt(r, "sdk", 0)
+	if err != nil {
+		http.Error(w, "sdk must be an integer", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	artifact := versionInfo.SelectArtifact(query.Get("platform"), query.Get("abi"), query.Get("density"), sdk)
+	if artifact == nil {
+		http.Error(w, fmt.Sprintf("v%s has no file for this device, pass platform, abi, density and sdk to pick one", versionInfo.Version), http.StatusNotFound)
+		return
+	}
+
+	reader, size, err := h.storage.Download(r.Context(), artifact.FileName)
 	if err != nil {
-		log.Printf("Failed to download APK: %v", err)
-		http.Error(w, "Failed to retrieve APK", http.StatusInternalServerError)
+		log.Printf("Failed to read file from storage: %v", err)
+		http.Error(w, "Failed to retrieve file", http.StatusInternalServerError)
 		return
 	}
 	defer reader.Close()
@@ -69,15 +120,29 @@ func (h *DownloadHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		}()
 	}
 
-	w.Header().Set("Content-Type", "application/vnd.android.package-archive")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"sono-%s-v%s.apk\"", channel, versionInfo.Version))
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", artifactName(h.app, channel, versionInfo.Version, artifact)))
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
 	w.Header().Set("X-Version", versionInfo.Version)
-	w.Header().Set("X-SHA256", versionInfo.SHA256)
+	w.Header().Set("X-SHA256", artifact.SHA256)
+	if artifact.ABI != "" {
+		w.Header().Set("X-ABI", artifact.ABI)
+	}
+	if artifact.Density != "" {
+		w.Header().Set("X-Density", artifact.Density)
+	}
 
 	if _, err := io.Copy(w, reader); err != nil {
-		log.Printf("Failed to stream APK: %v", err)
+		log.Printf("Failed to stream file: %v", err)
+	}
+}
+
+//getInstallID returns the stable device identifier used for staged rollouts
+func getInstallID(r *http.Request) string {
+	if id := r.Header.Get("X-Install-ID"); id != "" {
+		return id
 	}
+	return r.URL.Query().Get("install_id")
 }
 
 func getClientIP(r *http.Request) string {
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sono-version-service/middleware"
+	"sono-version-service/models"
+)
+
+func TestDownloadYanked(t *testing.T) {
+	store := newTestStore(t)
+	s := newTestStorage(t)
+	for i, version := range []string{"1.0.0", "1.1.0"} {
+		storeTestArtifacts(t, s, publishTestRelease(t, store, models.ChannelStable, version, i+1))
+	}
+	store.Yank(models.ChannelStable, "1.1.0", models.YankInfo{Reason: "crash on start"})
+	h := NewDownloadHandler(s, store, nil, "sono", middleware.NewVerifier("secret", middleware.SignatureConfig{}))
+
+	admin := http.Header{"X-Webhook-Secret": {"secret"}}
+	tests := []struct {
+		name    string
+		target  string
+		header  http.Header
+		status  int
+		version string
+	}{
+		{"yanked release", "/download/stable/1.1.0", nil, http.StatusGone, ""},
+		{"override without credentials", "/download/stable/1.1.0?override=true", nil, http.StatusGone, ""},
+		{"override with wrong secret", "/download/stable/1.1.0?override=true", http.Header{"X-Webhook-Secret": {"wrong"}}, http.StatusGone, ""},
+		{"credentials without override", "/download/stable/1.1.0", admin, http.StatusGone, ""},
+		{"admin override", "/download/stable/1.1.0?override=true", admin, http.StatusOK, "1.1.0"},
+		{"earlier release", "/download/stable/1.0.0", nil, http.StatusOK, "1.0.0"},
+		{"unknown release", "/download/stable/9.9.9", nil, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodGet, "/download/{channel}/{version}", h.HandleVersion, tt.target, nil, tt.header)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if got := rec.Header().Get("X-Version"); got != tt.version {
+			t.Errorf("%s: X-Version = %q, want %q", tt.name, got, tt.version)
+		}
+	}
+
+	//the channel download falls back to the release before the yanked one
+	rec := serve(http.MethodGet, "/download/{channel}", h.Handle, "/download/stable", nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Version") != "1.0.0" {
+		t.Errorf("GET /download/stable = %d, v%s, want 200, v1.0.0", rec.Code, rec.Header().Get("X-Version"))
+	}
+}
+
+func TestDownloadArtifact(t *testing.T) {
+	store := newTestStore(t)
+	s := newTestStorage(t)
+	info := publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	for _, split := range []models.Artifact{
+		{FileName: "stable/sono-1.0.0-arm64-v8a.apk", SHA256: strings.Repeat("a", 64), ABI: "arm64-v8a", MinSdk: 24, ArtifactType: models.ArtifactTypeAPK},
+		{FileName: "stable/sono-1.0.0-arm64-v8a-xxhdpi.apk", SHA256: strings.Repeat("b", 64), ABI: "arm64-v8a", Density: "xxhdpi", MinSdk: 24, ArtifactType: models.ArtifactTypeAPK},
+		{FileName: "stable/sono-1.0.0-x86_64.apk", SHA256: strings.Repeat("c", 64), ABI: "x86_64", MinSdk: 30, ArtifactType: models.ArtifactTypeAPK},
+	} {
+		var err error
+		if info, err = store.AddArtifact(models.ChannelStable, "1.0.0", 1, split); err != nil {
+			t.Fatalf("AddArtifact: %v", err)
+		}
+	}
+	for _, artifact := range info.Files() {
+		if err := s.Upload(context.Background(), artifact.FileName, strings.NewReader(artifact.FileName), int64(len(artifact.FileName)), artifact.ContentType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewDownloadHandler(s, store, nil, "sono", middleware.NewVerifier("", middleware.SignatureConfig{}))
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		file    string
+		abi     string
+		density string
+	}{
+		{"universal", "", http.StatusOK, "stable/sono-1.0.0.apk", "", ""},
+		{"abi split", "?abi=arm64-v8a", http.StatusOK, "stable/sono-1.0.0-arm64-v8a.apk", "arm64-v8a", ""},
+		{"abi and density split", "?abi=arm64-v8a&density=xxhdpi", http.StatusOK, "stable/sono-1.0.0-arm64-v8a-xxhdpi.apk", "arm64-v8a", "xxhdpi"},
+		{"unknown density", "?abi=arm64-v8a&density=ldpi", http.StatusOK, "stable/sono-1.0.0-arm64-v8a.apk", "arm64-v8a", ""},
+		{"split needs a newer sdk", "?abi=x86_64&sdk=28", http.StatusOK, "stable/sono-1.0.0.apk", "", ""},
+		{"other abi", "?abi=armeabi-v7a", http.StatusOK, "stable/sono-1.0.0.apk", "", ""},
+		{"other platform", "?platform=windows", http.StatusNotFound, "", "", ""},
+		{"invalid sdk", "?sdk=new", http.StatusBadRequest, "", "", ""},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodGet, "/download/{channel}", h.Handle, "/download/stable"+tt.query, nil, nil)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if rec.Body.String() != tt.file || rec.Header().Get("X-ABI") != tt.abi || rec.Header().Get("X-Density") != tt.density {
+			t.Errorf("%s: served %s (abi %q, density %q), want %s", tt.name, rec.Body, rec.Header().Get("X-ABI"), rec.Header().Get("X-Density"), tt.file)
+		}
+	}
+}
\ No newline at end of file
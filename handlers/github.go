@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sono-version-service/apk"
+	"sono-version-service/fetch"
+	"sono-version-service/models"
+)
+
+//releases requested per poll, the newest ones are enough to catch up
+const githubPageSize = 20
+
+//redirects followed by API requests, which never leave the API host
+const githubMaxRedirects = 3
+
+//GitHubSource is a repository whose releases are published automatically
+type GitHubSource struct {
+	Repo         string //owner/name
+	Token        string
+	AssetPattern string //glob matched against asset names
+	Rules        []GitHubRule
+}
+
+//GitHubRule maps releases to a channel. The first matching rule wins.
+type GitHubRule struct {
+	Tag        string `json:"tag"`        //glob matched against the tag, empty matches any
+	Prerelease *bool  `json:"prerelease"` //nil matches releases and prereleases
+	Channel    string `json:"channel"`
+}
+
+func (r GitHubRule) matches(release *githubRelease) bool {
+	if r.Prerelease != nil && *r.Prerelease != release.Prerelease {
+		return false
+	}
+	if r.Tag == "" {
+		return true
+	}
+	ok, _ := path.Match(r.Tag, release.TagName)
+	return ok
+}
+
+//DefaultGitHubRules publishes prereleases to beta and releases to stable
+func DefaultGitHubRules() []GitHubRule {
+	yes, no := true, false
+	return []GitHubRule{
+		{Prerelease: &yes, Channel: "beta"},
+		{Prerelease: &no, Channel: "stable"},
+	}
+}
+
+type githubRelease struct {
+	ID          int64         `json:"id"`
+	TagName     string        `json:"tag_name"`
+	Body        string        `json:"body"`
+	Draft       bool          `json:"draft"`
+	Prerelease  bool          `json:"prerelease"`
+	PublishedAt time.Time     `json:"published_at"`
+	Assets      []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	ID                 int64  `json:"id"`
+	Name               string `json:"name"`
+	URL                string `json:"url"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Size               int64  `json:"size"`
+	Digest             string `json:"digest"` //"sha256:<hex>", missing on older assets
+}
+
+//githubState is what the poller remembers between polls and restarts
+type githubState struct {
+	ETags map[string]string `json:"etags"`
+	//assets already published or skipped, per repository
+	Seen map[string][]int64 `json:"seen"`
+}
+
+//GitHubPoller watches GitHub repositories and publishes new release assets
+//through the regular upload pipeline
+type GitHubPoller struct {
+	uploads   *UploadHandler
+	apiURL    string
+	api       *fetch.Client
+	sources   []GitHubSource
+	statePath string
+
+	mu    sync.Mutex
+	state githubState
+}
+
+func NewGitHubPoller(uploads *UploadHandler, apiURL string, sources []GitHubSource, statePath string) (*GitHubPoller, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid GitHub API URL %q", apiURL)
+	}
+
+	p := &GitHubPoller{
+		uploads: uploads,
+		apiURL:  strings.TrimSuffix(apiURL, "/"),
+		//the API host is configured by the operator, not by callers, so it may
+		//be internal (GitHub Enterprise, a local stand-in). Nothing else is
+		//reachable through this client.
+		api: fetch.NewClient(fetch.Config{
+			AllowedHosts:    []string{u.Hostname()},
+			MaxRedirects:    githubMaxRedirects,
+			AllowPrivateIPs: true,
+		}, time.Minute),
+		sources:   sources,
+		statePath: statePath,
+		state:     githubState{ETags: map[string]string{}, Seen: map[string][]int64{}},
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &p.state); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", statePath, err)
+		}
+	}
+	return p, nil
+}
+
+//Run polls every source every interval until ctx is cancelled
+func (p *GitHubPoller) Run(ctx context.Context, interval time.Duration) {
+	if len(p.sources) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, source := range p.sources {
+			if err := p.poll(ctx, source); err != nil {
+				log.Printf("GitHub: Failed to poll %s: %v", source.Repo, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *GitHubPoller) poll(ctx context.Context, source GitHubSource) error {
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	if source.Token != "" {
+		header.Set("Authorization", "Bearer "+source.Token)
+	}
+	p.mu.Lock()
+	if etag := p.state.ETags[source.Repo]; etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	p.mu.Unlock()
+
+	url := fmt.Sprintf("%s/repos/%s/releases?per_page=%d", p.apiURL, source.Repo, githubPageSize)
+	resp, err := p.api.Get(ctx, url, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("releases API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var releases []*githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return fmt.Errorf("decoding releases: %w", err)
+	}
+
+	seen := make(map[int64]bool)
+	p.mu.Lock()
+	for _, id := range p.state.Seen[source.Repo] {
+		seen[id] = true
+	}
+	p.mu.Unlock()
+
+	//only the newest unseen release of each channel is published, older ones
+	//would be superseded right away
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].PublishedAt.After(releases[j].PublishedAt)
+	})
+	done := make(map[models.Channel]bool)
+	retry := false
+	var current []int64
+	for _, release := range releases {
+		if release.Draft {
+			continue
+		}
+		asset := matchAsset(release, source.AssetPattern)
+		if asset == nil {
+			continue
+		}
+		current = append(current, asset.ID)
+
+		channel, ok := p.channelFor(source, release)
+		if seen[asset.ID] {
+			if ok {
+				done[channel] = true
+			}
+			continue
+		}
+		if !ok || done[channel] {
+			seen[asset.ID] = true
+			continue
+		}
+		done[channel] = true
+
+		if err := p.ingest(ctx, source, release, asset, channel); err != nil {
+			log.Printf("GitHub: Failed to publish %s %s to %s: %v", source.Repo, release.TagName, channel, err)
+			var uploadErr *UploadError
+			if !errors.As(err, &uploadErr) || uploadErr.Status >= 500 {
+				//transient, try again on the next poll
+				retry = true
+				continue
+			}
+		}
+		seen[asset.ID] = true
+	}
+
+	//forget assets that dropped out of the listing
+	var keep []int64
+	for _, id := range current {
+		if seen[id] {
+			keep = append(keep, id)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.Seen[source.Repo] = keep
+	if retry {
+		delete(p.state.ETags, source.Repo)
+	} else {
+		p.state.ETags[source.Repo] = resp.Header.Get("ETag")
+	}
+	return p.saveState()
+}
+
+func (p *GitHubPoller) channelFor(source GitHubSource, release *githubRelease) (models.Channel, bool) {
+	for _, rule := range source.Rules {
+		if rule.matches(release) {
+			channel := models.Channel(rule.Channel)
+			if !p.uploads.versionStore.HasChannel(channel) {
+				log.Printf("GitHub: Channel %s for %s %s does not exist", channel, source.Repo, release.TagName)
+				return "", false
+			}
+			return channel, true
+		}
+	}
+	return "", false
+}
+
+func matchAsset(release *githubRelease, pattern string) *githubAsset {
+	for i := range release.Assets {
+		if ok, _ := path.Match(pattern, release.Assets[i].Name); ok {
+			return &release.Assets[i]
+		}
+	}
+	return nil
+}
+
+//ingest downloads an asset and publishes it like an apk_url upload. Version
+//and version code come from the APK's manifest, and releases that aren't
+//newer than the channel's current one are skipped.
+func (p *GitHubPoller) ingest(ctx context.Context, source GitHubSource, release *githubRelease, asset *githubAsset, channel models.Channel) error {
+	//private repositories only serve assets through the API
+	url := asset.BrowserDownloadURL
+	if source.Token != "" {
+		url = asset.URL
+	}
+
+	log.Printf("GitHub: Downloading %s from %s %s", asset.Name, source.Repo, release.TagName)
+	art, err := p.uploads.downloadAPK(ctx, url, source.Token, nil)
+	if err != nil {
+		uploadErr := downloadError(err)
+		p.uploads.logUpload(ctx, string(channel), strings.TrimPrefix(release.TagName, "v"), "failed", uploadErr.Message, asset.BrowserDownloadURL)
+		return uploadErr
+	}
+	defer art.Close()
+
+	manifest, err := apk.ReadManifest(art.file, art.size)
+	if err != nil {
+		uploadErr := &UploadError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("Invalid APK: %v", err)}
+		p.uploads.logUpload(ctx, string(channel), strings.TrimPrefix(release.TagName, "v"), "failed", uploadErr.Message, asset.BrowserDownloadURL)
+		return uploadErr
+	}
+	version := manifest.VersionName
+	if version == "" || manifest.VersionNameIsRef {
+		version = strings.TrimPrefix(release.TagName, "v")
+	}
+
+	if latest := p.uploads.versionStore.Get(channel); latest != nil && latest.VersionCode >= manifest.VersionCode {
+		log.Printf("GitHub: Skipping %s %s, %s is already at version_code %d", source.Repo, release.TagName, channel, latest.VersionCode)
+		return nil
+	}
+
+	req := &EnhancedUploadRequest{
+		Channel:      channel,
+		Version:      version,
+		VersionCode:  manifest.VersionCode,
+		ReleaseNotes: release.Body,
+		Size:         asset.Size,
+	}
+	if digest, ok := strings.CutPrefix(asset.Digest, "sha256:"); ok {
+		req.SHA256 = digest
+	}
+	if !req.Validate() {
+		return &UploadError{Status: http.StatusUnprocessableEntity, Message: "release metadata is invalid"}
+	}
+
+	info, err := p.uploads.publish(ctx, req, art, asset.BrowserDownloadURL)
+	if err != nil {
+		return err
+	}
+	log.Printf("GitHub: Published %s %s as %s v%s", source.Repo, release.TagName, info.Channel, info.Version)
+	return nil
+}
+
+func (p *GitHubPoller) saveState() error {
+	data, err := json.MarshalIndent(p.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.statePath, data, 0644)
+}
\ No newline at end of file
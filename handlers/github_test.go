@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sono-version-service/apk/apktest"
+	"sono-version-service/models"
+)
+
+func TestGitHubRuleMatches(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name    string
+		rule    GitHubRule
+		release githubRelease
+		want    bool
+	}{
+		{"any release", GitHubRule{}, githubRelease{TagName: "v1.0.0"}, true},
+		{"prerelease flag", GitHubRule{Prerelease: &yes}, githubRelease{TagName: "v1.0.0-rc1", Prerelease: true}, true},
+		{"not a prerelease", GitHubRule{Prerelease: &yes}, githubRelease{TagName: "v1.0.0"}, false},
+		{"release flag", GitHubRule{Prerelease: &no}, githubRelease{TagName: "v1.0.0"}, true},
+		{"tag glob", GitHubRule{Tag: "v*-nightly*"}, githubRelease{TagName: "v1.0.0-nightly.3"}, true},
+		{"tag glob mismatch", GitHubRule{Tag: "v*-nightly*"}, githubRelease{TagName: "v1.0.0"}, false},
+		{"tag and flag", GitHubRule{Tag: "v*", Prerelease: &no}, githubRelease{TagName: "v1.0.0", Prerelease: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(&tt.release); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchAsset(t *testing.T) {
+	release := &githubRelease{Assets: []githubAsset{
+		{ID: 1, Name: "app-debug.apk"},
+		{ID: 2, Name: "app-release.apk"},
+		{ID: 3, Name: "mapping.txt"},
+	}}
+
+	tests := []struct {
+		pattern string
+		want    int64 //asset id, 0 for none
+	}{
+		{"*.apk", 1},
+		{"*-release.apk", 2},
+		{"mapping.txt", 3},
+		{"*.aab", 0},
+	}
+	for _, tt := range tests {
+		got := matchAsset(release, tt.pattern)
+		if (got == nil && tt.want != 0) || (got != nil && got.ID != tt.want) {
+			t.Errorf("matchAsset(%q) = %+v, want asset %d", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestChannelFor(t *testing.T) {
+	p := &GitHubPoller{uploads: newTestUploadHandler(t, newTestStore(t), newTestStorage(t), 1<<20)}
+	source := GitHubSource{Repo: "sono/app", Rules: append([]GitHubRule{
+		{Tag: "*-nightly*", Channel: "nightly"},
+		{Tag: "*-canary*", Channel: "canary"},
+	}, DefaultGitHubRules()...)}
+
+	tests := []struct {
+		release githubRelease
+		want    models.Channel
+		ok      bool
+	}{
+		{githubRelease{TagName: "v1.0.0"}, models.ChannelStable, true},
+		{githubRelease{TagName: "v1.1.0-rc1", Prerelease: true}, models.ChannelBeta, true},
+		{githubRelease{TagName: "v1.1.0-nightly.2", Prerelease: true}, models.ChannelNightly, true},
+		{githubRelease{TagName: "v1.1.0-canary.1"}, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := p.channelFor(source, &tt.release)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("channelFor(%s) = %q, %v, want %q, %v", tt.release.TagName, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+//githubStub serves the releases API of one repository and its assets
+type githubStub struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	releases  []githubRelease
+	assets    map[string][]byte //by name
+	status    int               //of asset downloads, 0 for 200
+	downloads []string          //asset paths in the order they were requested
+	auth      []string          //Authorization of every request
+}
+
+func newGitHubStub(t *testing.T) *githubStub {
+	t.Helper()
+	stub := &githubStub{assets: map[string][]byte{}}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *githubStub) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+
+	if r.URL.Path == "/repos/sono/app/releases" {
+		data, _ := json.Marshal(s.releases)
+		etag := `"` + sha256Hex(string(data))[:16] + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+		return
+	}
+
+	s.downloads = append(s.downloads, r.URL.Path)
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	data, ok := s.assets[filepath.Base(r.URL.Path)]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+//release adds a release with one APK asset
+func (s *githubStub) release(id int64, tag string, prerelease bool, published time.Time, file []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.TrimPrefix(tag, "v") + ".apk"
+	s.assets[name] = file
+	s.releases = append(s.releases, githubRelease{
+		ID:          id,
+		TagName:     tag,
+		Body:        "Notes for " + tag,
+		Prerelease:  prerelease,
+		PublishedAt: published,
+		Assets: []githubAsset{{
+			ID:                 id * 10,
+			Name:               name,
+			URL:                s.URL + "/api/assets/" + name,
+			BrowserDownloadURL: s.URL + "/download/" + name,
+			Size:               int64(len(file)),
+			Digest:             "sha256:" + sha256Hex(string(file)),
+		}},
+	})
+}
+
+func (s *githubStub) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *githubStub) takeDownloads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	downloads := s.downloads
+	s.downloads = nil
+	return downloads
+}
+
+func signedTestAPK(key *apktest.Key, versionName string, versionCode int) []byte {
+	return apktest.Sign(apktest.New(apktest.Manifest{Package: "com.sono.app", VersionCode: versionCode, VersionName: versionName}), key, 2)
+}
+
+func TestGitHubPoll(t *testing.T) {
+	key := apktest.ECKey("release")
+	stub := newGitHubStub(t)
+	day := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	stub.release(1, "v0.9.0", false, day, signedTestAPK(key, "0.9.0", 1))
+	stub.release(2, "v1.0.0", false, day.Add(24*time.Hour), signedTestAPK(key, "1.0.0", 2))
+	stub.release(3, "v1.1.0-rc1", true, day.Add(48*time.Hour), signedTestAPK(key, "1.1.0-rc1", 3))
+
+	store := newTestStore(t)
+	statePath := filepath.Join(t.TempDir(), "github.json")
+	source := GitHubSource{Repo: "sono/app", AssetPattern: "*.apk", Rules: DefaultGitHubRules()}
+	p, err := NewGitHubPoller(newTestUploadHandler(t, store, newTestStorage(t), 1<<20), stub.URL, []GitHubSource{source}, statePath)
+	if err != nil {
+		t.Fatalf("NewGitHubPoller: %v", err)
+	}
+
+	steps := []struct {
+		name      string
+		setup     func()
+		downloads []string
+		stable    string
+		beta      string
+	}{
+		{
+			name:      "first poll publishes the newest release of each channel",
+			downloads: []string{"/download/1.1.0-rc1.apk", "/download/1.0.0.apk"},
+			stable:    "1.0.0",
+			beta:      "1.1.0-rc1",
+		},
+		{
+			name:   "unchanged listing",
+			stable: "1.0.0",
+			beta:   "1.1.0-rc1",
+		},
+		{
+			name: "download fails",
+			setup: func() {
+				stub.release(4, "v1.1.0", false, day.Add(72*time.Hour), signedTestAPK(key, "1.1.0", 4))
+				stub.setStatus(http.StatusBadGateway)
+			},
+			downloads: []string{"/download/1.1.0.apk"},
+			stable:    "1.0.0",
+			beta:      "1.1.0-rc1",
+		},
+		{
+			name:      "retried on the next poll",
+			setup:     func() { stub.setStatus(0) },
+			downloads: []string{"/download/1.1.0.apk"},
+			stable:    "1.1.0",
+			beta:      "1.1.0-rc1",
+		},
+		{
+			name: "rejected files are not retried",
+			setup: func() {
+				stub.release(5, "v1.2.0-rc1", true, day.Add(96*time.Hour), apktest.New(apktest.Manifest{Package: "com.sono.app", VersionCode: 5, VersionName: "1.2.0-rc1"}))
+			},
+			downloads: []string{"/download/1.2.0-rc1.apk"},
+			stable:    "1.1.0",
+			beta:      "1.1.0-rc1",
+		},
+		{
+			name: "draft",
+			setup: func() {
+				stub.release(6, "v1.2.0", false, day.Add(120*time.Hour), signedTestAPK(key, "1.2.0", 6))
+				stub.mu.Lock()
+				stub.releases[len(stub.releases)-1].Draft = true
+				stub.mu.Unlock()
+			},
+			stable: "1.1.0",
+			beta:   "1.1.0-rc1",
+		},
+	}
+	for _, step := range steps {
+		if step.setup != nil {
+			step.setup()
+		}
+		if err := p.poll(context.Background(), source); err != nil {
+			t.Fatalf("%s: poll: %v", step.name, err)
+		}
+		if got := stub.takeDownloads(); strings.Join(got, " ") != strings.Join(step.downloads, " ") {
+			t.Errorf("%s: downloaded %v, want %v", step.name, got, step.downloads)
+		}
+		for channel, want := range map[models.Channel]string{models.ChannelStable: step.stable, models.ChannelBeta: step.beta} {
+			if current := store.Get(channel); current == nil || current.Version != want {
+				t.Errorf("%s: %s is on %+v, want %s", step.name, channel, current, want)
+			}
+		}
+	}
+
+	current := store.Get(models.ChannelStable)
+	if current.ReleaseNotes != "Notes for v1.1.0" || current.VersionCode != 4 {
+		t.Errorf("stable = %+v", current)
+	}
+
+	//the state survives a restart
+	restarted, err := NewGitHubPoller(p.uploads, stub.URL, []GitHubSource{source}, statePath)
+	if err != nil {
+		t.Fatalf("NewGitHubPoller: %v", err)
+	}
+	if restarted.state.ETags["sono/app"] == "" || len(restarted.state.Seen["sono/app"]) != len(p.state.Seen["sono/app"]) {
+		t.Errorf("restored state %+v, want %+v", restarted.state, p.state)
+	}
+}
+
+func TestGitHubPollPrivateRepo(t *testing.T) {
+	key := apktest.ECKey("release")
+	stub := newGitHubStub(t)
+	stub.release(1, "v1.0.0", false, time.Now(), signedTestAPK(key, "1.0.0", 1))
+
+	store := newTestStore(t)
+	source := GitHubSource{Repo: "sono/app", Token: "ghp_token", AssetPattern: "*.apk", Rules: DefaultGitHubRules()}
+	p, err := NewGitHubPoller(newTestUploadHandler(t, store, newTestStorage(t), 1<<20), stub.URL+"/", []GitHubSource{source}, filepath.Join(t.TempDir(), "github.json"))
+	if err != nil {
+		t.Fatalf("NewGitHubPoller: %v", err)
+	}
+	if err := p.poll(context.Background(), source); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	//assets of private repositories are fetched through the API
+	if got := stub.takeDownloads(); len(got) != 1 || got[0] != "/api/assets/1.0.0.apk" {
+		t.Errorf("downloaded %v", got)
+	}
+	if stub.auth[0] != "Bearer ghp_token" || stub.auth[1] != "token ghp_token" {
+		t.Errorf("Authorization = %q", stub.auth)
+	}
+	if current := store.Get(models.ChannelStable); current == nil || current.Version != "1.0.0" {
+		t.Errorf("stable is on %+v", current)
+	}
+}
+
+func TestGitHubPollSkipsOlderVersions(t *testing.T) {
+	key := apktest.ECKey("release")
+	stub := newGitHubStub(t)
+	stub.release(1, "v1.0.0", false, time.Now(), signedTestAPK(key, "1.0.0", 1))
+
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "2.0.0", 20)
+	source := GitHubSource{Repo: "sono/app", AssetPattern: "*.apk", Rules: DefaultGitHubRules()}
+	p, err := NewGitHubPoller(newTestUploadHandler(t, store, newTestStorage(t), 1<<20), stub.URL, []GitHubSource{source}, filepath.Join(t.TempDir(), "github.json"))
+	if err != nil {
+		t.Fatalf("NewGitHubPoller: %v", err)
+	}
+	if err := p.poll(context.Background(), source); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if current := store.Get(models.ChannelStable); current.Version != "2.0.0" {
+		t.Errorf("stable moved back to %s", current.Version)
+	}
+}
+
+func TestNewGitHubPoller(t *testing.T) {
+	dir := t.TempDir()
+	for _, apiURL := range []string{"", "api.github.com", "://"} {
+		if _, err := NewGitHubPoller(nil, apiURL, nil, filepath.Join(dir, "github.json")); err == nil {
+			t.Errorf("NewGitHubPoller(%q) succeeded", apiURL)
+		}
+	}
+}
\ No newline at end of file
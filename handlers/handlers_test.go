@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+func newTestStore(t *testing.T) *models.VersionStore {
+	t.Helper()
+	store, err := models.NewVersionStore(filepath.Join(t.TempDir(), "versions.json"))
+	if err != nil {
+		t.Fatalf("NewVersionStore: %v", err)
+	}
+	for _, channel := range []models.Channel{models.ChannelStable, models.ChannelBeta, models.ChannelNightly} {
+		if err := store.EnsureChannel(channel, models.ChannelSettings{}); err != nil {
+			t.Fatalf("EnsureChannel(%s): %v", channel, err)
+		}
+	}
+	return store
+}
+
+func newTestStorage(t *testing.T) storage.Storage {
+	t.Helper()
+	s, err := storage.NewLocalStorage(filepath.Join(t.TempDir(), "apks"))
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	return s
+}
+
+//publishTestRelease stores a release whose publish time follows its version
+//code, so history order doesn't depend on the clock
+func publishTestRelease(t *testing.T, store *models.VersionStore, channel models.Channel, version string, versionCode int) *models.VersionInfo {
+	t.Helper()
+	info := &models.VersionInfo{
+		Channel:      channel,
+		Version:      version,
+		VersionCode:  versionCode,
+		FileName:     string(channel) + "/sono-" + version + ".apk",
+		SHA256:       strings.Repeat("ab", 32),
+		ReleaseNotes: "Notes for " + version,
+		PublishedAt:  time.Date(2026, 1, 1, 0, 0, versionCode, 0, time.UTC),
+		ArtifactType: models.ArtifactTypeAPK,
+	}
+	if err := store.Set(info); err != nil {
+		t.Fatalf("Set(%s v%s): %v", channel, version, err)
+	}
+	return info
+}
+
+//serve routes one request through a chi router with a single route, so URL
+//parameters resolve like in production
+func serve(method, pattern string, handler http.HandlerFunc, target string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	r.MethodFunc(method, pattern, handler)
+
+	req := httptest.NewRequest(method, target, body)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
\ No newline at end of file
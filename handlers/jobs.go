@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/database"
+	"sono-version-service/models"
+)
+
+const (
+	JobQueued      = "queued"
+	JobDownloading = "downloading"
+	JobPublishing  = "publishing"
+	JobSucceeded   = "succeeded"
+	JobFailed      = "failed"
+)
+
+//finished jobs are dropped from memory after this long, with a database they
+//can still be looked up there
+const jobRetention = 24 * time.Hour
+
+//upper bound for fetching and publishing one APK in the background
+const jobTimeout = 30 * time.Minute
+
+//how often the heartbeat of running jobs is refreshed in the database, and
+//how old it may get before another instance takes the job's owner for dead
+const (
+	jobHeartbeat  = 30 * time.Second
+	jobStaleAfter = 4 * jobHeartbeat
+)
+
+//instanceID identifies this process as the owner of the jobs it runs. The
+//random part tells a restarted container apart from its previous run.
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", host, b)
+}
+
+//JobQueue runs background work on a fixed number of workers
+type JobQueue struct {
+	work chan func()
+}
+
+func NewJobQueue(workers, size int) *JobQueue {
+	q := &JobQueue{work: make(chan func(), size)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for fn := range q.work {
+				fn()
+			}
+		}()
+	}
+	return q
+}
+
+//Submit queues fn and reports false if the queue is full
+func (q *JobQueue) Submit(fn func()) bool {
+	select {
+	case q.work <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+type UploadJob struct {
+	ID            string              `json:"id"`
+	Status        string              `json:"status"`
+	Channel       models.Channel      `json:"channel"`
+	Version       string              `json:"version"`
+	SourceURL     string              `json:"source_url"`
+	BytesReceived int64               `json:"bytes_received"`
+	TotalBytes    int64               `json:"total_bytes,omitempty"` //0 while unknown
+	Release       *models.VersionInfo `json:"release,omitempty"`
+	Error         string              `json:"error,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+}
+
+func (j *UploadJob) finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+//JobStore tracks the upload jobs of an app in memory and mirrors them to the
+//database, so their outcome survives restarts
+type JobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*UploadJob
+	db   *database.DB
+}
+
+func NewJobStore(db *database.DB) *JobStore {
+	return &JobStore{jobs: make(map[string]*UploadJob), db: db}
+}
+
+func (s *JobStore) create(channel models.Channel, version, sourceURL string) (UploadJob, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return UploadJob{}, err
+	}
+	now := time.Now().UTC()
+	job := &UploadJob{
+		ID:        id,
+		Status:    JobQueued,
+		Channel:   channel,
+		Version:   version,
+		SourceURL: sourceURL,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mu.Lock()
+	for id, existing := range s.jobs {
+		if existing.finished() && now.Sub(existing.UpdatedAt) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+	snapshot := *job
+	s.mu.Unlock()
+
+	s.persist(&snapshot)
+	return snapshot, nil
+}
+
+//update applies fn to a job. Progress updates stay in memory, status changes
+//are written to the database.
+func (s *JobStore) update(id string, persist bool, fn func(*UploadJob)) {
+	s.mu.Lock()
+	job := s.jobs[id]
+	if job == nil {
+		s.mu.Unlock()
+		return
+	}
+	fn(job)
+	job.UpdatedAt = time.Now().UTC()
+	snapshot := *job
+	s.mu.Unlock()
+
+	if persist {
+		s.persist(&snapshot)
+	}
+}
+
+func (s *JobStore) persist(job *UploadJob) {
+	if s.db == nil {
+		return
+	}
+	row := &database.UploadJob{
+		ID:            job.ID,
+		Status:        job.Status,
+		Channel:       string(job.Channel),
+		Version:       job.Version,
+		SourceURL:     job.SourceURL,
+		BytesReceived: job.BytesReceived,
+		TotalBytes:    job.TotalBytes,
+		Error:         job.Error,
+		Owner:         instanceID,
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+	}
+	if job.Release != nil {
+		if data, err := json.Marshal(job.Release); err == nil {
+			row.Result = string(data)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.db.SaveUploadJob(ctx, row); err != nil {
+		log.Printf("Failed to save upload job %s: %v", job.ID, err)
+	}
+}
+
+//Get returns a job from memory or, after a restart, from the database
+func (s *JobStore) Get(ctx context.Context, id string) (*UploadJob, error) {
+	s.mu.Lock()
+	if job := s.jobs[id]; job != nil {
+		snapshot := *job
+		s.mu.Unlock()
+		return &snapshot, nil
+	}
+	s.mu.Unlock()
+
+	row, err := s.db.GetUploadJob(ctx, id)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	job := &UploadJob{
+		ID:            row.ID,
+		Status:        row.Status,
+		Channel:       models.Channel(row.Channel),
+		Version:       row.Version,
+		SourceURL:     row.SourceURL,
+		BytesReceived: row.BytesReceived,
+		TotalBytes:    row.TotalBytes,
+		Error:         row.Error,
+		CreatedAt:     row.CreatedAt,
+		UpdatedAt:     row.UpdatedAt,
+	}
+	if row.Result != "" {
+		job.Release = &models.VersionInfo{}
+		if err := json.Unmarshal([]byte(row.Result), job.Release); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+//heartbeat keeps the jobs of this instance alive in the database and fails
+//the ones whose instance stopped, until ctx is cancelled
+func (s *JobStore) heartbeat(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+
+	ticker := time.NewTicker(jobHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tickCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := s.db.TouchUploadJobs(tickCtx, instanceID); err != nil {
+			log.Printf("Failed to refresh upload job heartbeats: %v", err)
+		}
+		if err := FailInterruptedJobs(tickCtx, s.db); err != nil {
+			log.Printf("Failed to clean up upload jobs: %v", err)
+		}
+		cancel()
+	}
+}
+
+//FailInterruptedJobs marks unfinished jobs whose instance stopped sending
+//heartbeats as failed, jobs of other running replicas are left alone. Their
+//GitHub tokens were never persisted, so they can't be resumed.
+func FailInterruptedJobs(ctx context.Context, db *database.DB) error {
+	n, err := db.FailStaleUploadJobs(ctx, jobStaleAfter, "interrupted, the instance running it stopped, upload again")
+	if n > 0 {
+		log.Printf("Marked %d interrupted upload jobs as failed", n)
+	}
+	return err
+}
+
+//RunJobHeartbeat maintains the upload jobs of this instance in the database
+//until ctx is cancelled
+func (h *UploadHandler) RunJobHeartbeat(ctx context.Context) {
+	h.jobs.heartbeat(ctx)
+}
+
+//Job reports the progress and outcome of an asynchronous upload
+func (h *UploadHandler) Job(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		log.Printf("Failed to load upload job: %v", err)
+		http.Error(w, "Failed to load upload job", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Upload job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+//enqueue accepts an apk_url upload for background processing
+func (h *UploadHandler) enqueue(w http.ResponseWriter, r *http.Request, req *EnhancedUploadRequest) {
+	job, err := h.jobs.create(req.Channel, req.Version, req.ApkURL)
+	if err != nil {
+		log.Printf("Failed to create upload job: %v", err)
+		http.Error(w, "Failed to create upload job", http.StatusInternalServerError)
+		return
+	}
+
+	if !h.queue.Submit(func() { h.runJob(job.ID, req) }) {
+		h.jobs.update(job.ID, true, func(j *UploadJob) {
+			j.Status = JobFailed
+			j.Error = "upload queue is full"
+		})
+		http.Error(w, "Upload queue is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("Queued upload job %s for %s v%s", job.ID, req.Channel, req.Version)
+
+	statusURL := h.apiURL + "/uploads/" + job.ID
+	w.Header().Set("Location", statusURL)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"success":    true,
+		"job":        job,
+		"status_url": statusURL,
+	})
+}
+
+func (h *UploadHandler) runJob(id string, req *EnhancedUploadRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	fail := func(message string) {
+		h.jobs.update(id, true, func(j *UploadJob) {
+			j.Status = JobFailed
+			j.Error = message
+		})
+	}
+
+	h.jobs.update(id, true, func(j *UploadJob) { j.Status = JobDownloading })
+	log.Printf("Downloading file from: %s (job %s)", req.ApkURL, id)
+	art, err := h.downloadAPK(ctx, req.ApkURL, req.GitHubToken, func(received, total int64) {
+		h.jobs.update(id, false, func(j *UploadJob) {
+			j.BytesReceived = received
+			j.TotalBytes = total
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to download file: %v", err)
+		uploadErr := downloadError(err)
+		h.logUpload(ctx, string(req.Channel), req.Version, "failed", uploadErr.Message, req.ApkURL)
+		fail(uploadErr.Message)
+		return
+	}
+	defer art.Close()
+
+	h.jobs.update(id, true, func(j *UploadJob) {
+		j.Status = JobPublishing
+		j.BytesReceived = art.size
+	})
+	versionInfo, err := h.publish(ctx, req, art, req.ApkURL)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	h.jobs.update(id, true, func(j *UploadJob) {
+		j.Status = JobSucceeded
+		j.Release = versionInfo
+	})
+}
\ No newline at end of file
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"sono-version-service/models"
+)
+
+func TestJobQueueSubmit(t *testing.T) {
+	//without workers nothing drains the queue
+	q := NewJobQueue(0, 1)
+	if !q.Submit(func() {}) {
+		t.Fatal("Submit to an empty queue = false")
+	}
+	if q.Submit(func() {}) {
+		t.Error("Submit to a full queue = true")
+	}
+
+	done := make(chan struct{})
+	if !NewJobQueue(2, 1).Submit(func() { close(done) }) {
+		t.Fatal("Submit = false")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job didn't run")
+	}
+}
+
+func TestNewInstanceID(t *testing.T) {
+	host, _ := os.Hostname()
+	a, b := newInstanceID(), newInstanceID()
+	if !strings.HasPrefix(a, host+"-") {
+		t.Errorf("instance id %s doesn't start with the hostname %s", a, host)
+	}
+	if a == b {
+		t.Errorf("two instance ids are both %s", a)
+	}
+}
+
+func TestJobStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewJobStore(nil)
+
+	job, err := s.create(models.ChannelStable, "1.0.0", "https://example.com/app.apk")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if job.Status != JobQueued || job.ID == "" {
+		t.Fatalf("created %+v", job)
+	}
+
+	s.update(job.ID, false, func(j *UploadJob) {
+		j.Status = JobDownloading
+		j.BytesReceived, j.TotalBytes = 10, 20
+	})
+	s.update("unknown", true, func(j *UploadJob) { t.Error("updated an unknown job") })
+
+	got, err := s.Get(ctx, job.ID)
+	if err != nil || got == nil {
+		t.Fatalf("Get = %v, %v", got, err)
+	}
+	if got.Status != JobDownloading || got.BytesReceived != 10 || got.TotalBytes != 20 || got.SourceURL != job.SourceURL {
+		t.Errorf("Get = %+v", got)
+	}
+	//Get returns a copy
+	got.Status = JobFailed
+	if again, _ := s.Get(ctx, job.ID); again.Status != JobDownloading {
+		t.Errorf("changing the result of Get changed the job to %s", again.Status)
+	}
+
+	if missing, err := s.Get(ctx, "unknown"); missing != nil || err != nil {
+		t.Errorf("Get(unknown) = %+v, %v, want nil", missing, err)
+	}
+
+	//finished jobs are dropped after the retention period when the next job
+	//is created, running ones are kept
+	running, _ := s.create(models.ChannelStable, "1.0.1", "https://example.com/app.apk")
+	s.update(job.ID, true, func(j *UploadJob) { j.Status = JobSucceeded })
+	s.mu.Lock()
+	s.jobs[job.ID].UpdatedAt = time.Now().Add(-jobRetention - time.Minute)
+	s.jobs[running.ID].UpdatedAt = time.Now().Add(-jobRetention - time.Minute)
+	s.mu.Unlock()
+	s.create(models.ChannelStable, "1.0.2", "https://example.com/app.apk")
+
+	if old, _ := s.Get(ctx, job.ID); old != nil {
+		t.Errorf("finished job %s is still kept", job.ID)
+	}
+	if kept, _ := s.Get(ctx, running.ID); kept == nil {
+		t.Errorf("running job %s was dropped", running.ID)
+	}
+}
+
+//waitForJob polls the job until it has finished
+func waitForJob(t *testing.T, h *UploadHandler, id string) UploadJob {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		rec := serve(http.MethodGet, "/uploads/{id}", h.Job, "/uploads/"+id, nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET job = %d: %s", rec.Code, rec.Body)
+		}
+		var job UploadJob
+		decodeJSON(t, rec, &job)
+		if job.finished() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is still %s", id, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAsyncUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app.deb" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("debian package"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		body    string
+		prefer  string
+		status  int
+		outcome string //status of the finished job
+	}{
+		{"async field", `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "apk_url": "` + server.URL + `/app.deb", "async": true}`, "", http.StatusAccepted, JobSucceeded},
+		{"Prefer header", `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "apk_url": "` + server.URL + `/app.deb"}`, "respond-async", http.StatusAccepted, JobSucceeded},
+		{"download fails", `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "apk_url": "` + server.URL + `/missing.deb", "async": true}`, "", http.StatusAccepted, JobFailed},
+		{"base64", `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "apk_base64": "ZGF0YQ==", "async": true}`, "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			h := newTestUploadHandler(t, store, newTestStorage(t), 1<<20)
+
+			header := http.Header{"Content-Type": {"application/json"}}
+			if tt.prefer != "" {
+				header.Set("Prefer", tt.prefer)
+			}
+			rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", strings.NewReader(tt.body), header)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusAccepted {
+				return
+			}
+
+			var resp struct {
+				Job       UploadJob `json:"job"`
+				StatusURL string    `json:"status_url"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if want := "http://localhost/api/v1/uploads/" + resp.Job.ID; resp.StatusURL != want || rec.Header().Get("Location") != want {
+				t.Errorf("status_url = %s, Location = %s, want %s", resp.StatusURL, rec.Header().Get("Location"), want)
+			}
+
+			job := waitForJob(t, h, resp.Job.ID)
+			if job.Status != tt.outcome {
+				t.Fatalf("job finished as %s (%s), want %s", job.Status, job.Error, tt.outcome)
+			}
+			current := store.Get(models.ChannelStable)
+			if tt.outcome == JobFailed {
+				if job.Error == "" || job.Release != nil || current != nil {
+					t.Errorf("failed job = %+v, current = %+v", job, current)
+				}
+				return
+			}
+			if job.Release == nil || job.Release.SHA256 != sha256Hex("debian package") || job.BytesReceived != int64(len("debian package")) {
+				t.Errorf("job = %+v", job)
+			}
+			if current == nil || current.Version != "1.0.0" {
+				t.Errorf("current = %+v, want 1.0.0", current)
+			}
+		})
+	}
+}
+
+func TestAsyncUploadQueueFull(t *testing.T) {
+	store := newTestStore(t)
+	h := newTestUploadHandler(t, store, newTestStorage(t), 1<<20)
+	h.queue = NewJobQueue(0, 0)
+
+	body := `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "apk_url": "https://example.com/app.deb", "async": true}`
+	rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", strings.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+	}
+
+	//the rejected job is recorded as failed
+	h.jobs.mu.Lock()
+	defer h.jobs.mu.Unlock()
+	if len(h.jobs.jobs) != 1 {
+		t.Fatalf("%d jobs, want 1", len(h.jobs.jobs))
+	}
+	for _, job := range h.jobs.jobs {
+		if job.Status != JobFailed || job.Error != "upload queue is full" {
+			t.Errorf("job = %+v", job)
+		}
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	h := newTestUploadHandler(t, newTestStore(t), newTestStorage(t), 1<<20)
+	if rec := serve(http.MethodGet, "/uploads/{id}", h.Job, "/uploads/unknown", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,547 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/database"
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+type ReleasesHandler struct {
+	storage      storage.Storage
+	versionStore *models.VersionStore
+	db           *database.DB
+	app          string
+	apiURL       string
+}
+
+func NewReleasesHandler(s storage.Storage, vs *models.VersionStore, db *database.DB, app, apiURL string) *ReleasesHandler {
+	return &ReleasesHandler{
+		storage:      s,
+		versionStore: vs,
+		db:           db,
+		app:          app,
+		apiURL:       apiURL,
+	}
+}
+
+//List returns the release history of a channel, newest first
+func (h *ReleasesHandler) List(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	page, err := queryInt(r, "page", 1)
+	if err != nil || page < 1 {
+		http.Error(w, "Invalid page parameter", http.StatusBadRequest)
+		return
+	}
+	perPage, err := queryInt(r, "per_page", defaultPerPage)
+	if err != nil || perPage < 1 {
+		http.Error(w, "Invalid per_page parameter", http.StatusBadRequest)
+		return
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	history := h.versionStore.History(channel)
+	start, end := pageBounds(len(history), page, perPage)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"channel":  channel,
+		"page":     page,
+		"per_page": perPage,
+		"total":    len(history),
+		"releases": history[start:end],
+	})
+}
+
+//pageBounds returns the slice bounds of a page of total items. Pages past the
+//end are empty; page is compared before multiplying so huge values can't
+//overflow.
+func pageBounds(total, page, perPage int) (int, int) {
+	if page-1 > total/perPage {
+		return total, total
+	}
+	start := min((page-1)*perPage, total)
+	return start, min(start+perPage, total)
+}
+
+//Get returns the metadata of one specific release of a channel
+func (h *ReleasesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	version := chi.URLParam(r, "version")
+
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	versionInfo := h.versionStore.GetVersion(channel, version)
+	if versionInfo == nil {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"error":   "not_found",
+			"message": "Release not found",
+			"channel": channel,
+			"version": version,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, versionInfo)
+}
+
+type PromoteRequest struct {
+	TargetChannel models.Channel `json:"target_channel"`
+	CopyArtifact  bool           `json:"copy_artifact"` //false references the source object
+	ReleaseNotes  *string        `json:"release_notes"` //overrides the source notes when set
+	Reason        string         `json:"reason"`
+	PerformedBy   string         `json:"performed_by"`
+}
+
+//Promote publishes an existing release to another channel so the exact bytes
+//that were tested ship without a re-upload
+func (h *ReleasesHandler) Promote(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	version := chi.URLParam(r, "version")
+
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	var req PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !h.versionStore.HasChannel(req.TargetChannel) {
+		invalidChannel(w, h.versionStore, "target_channel")
+		return
+	}
+	if req.TargetChannel == channel {
+		http.Error(w, "target_channel must differ from the source channel", http.StatusBadRequest)
+		return
+	}
+
+	source := h.versionStore.GetVersion(channel, version)
+	if source == nil {
+		http.Error(w, "Release not found", http.StatusNotFound)
+		return
+	}
+	if source.IsYanked() {
+		http.Error(w, "Yanked releases can't be promoted", http.StatusConflict)
+		return
+	}
+	settings := h.versionStore.ChannelSettings(req.TargetChannel)
+	for _, artifact := range source.Files() {
+		if !settings.AllowsSigner(artifact.SignerSHA256) {
+			http.Error(w, fmt.Sprintf("v%s is not signed by an allowed signer of %s", version, req.TargetChannel), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if existing := h.versionStore.GetVersion(req.TargetChannel, version); existing != nil && existing.SHA256 != source.SHA256 {
+		http.Error(w, fmt.Sprintf("%s already has a different build of v%s", req.TargetChannel, version), http.StatusConflict)
+		return
+	}
+
+	var artifacts []models.Artifact
+	for _, artifact := range source.Files() {
+		if req.CopyArtifact {
+			fileName := artifactKey(h.app, req.TargetChannel, version, &artifact)
+			if err := storage.Copy(r.Context(), h.storage, artifact.FileName, fileName, artifact.ContentType); err != nil {
+				log.Printf("Failed to copy file %s to %s: %v", artifact.FileName, fileName, err)
+				http.Error(w, "Failed to copy file", http.StatusInternalServerError)
+				return
+			}
+			artifact.FileName = fileName
+		} else {
+			exists, err := h.storage.Exists(r.Context(), artifact.FileName)
+			if err != nil || !exists {
+				log.Printf("Promotion source artifact missing: %s (%v)", artifact.FileName, err)
+				http.Error(w, "Artifact for the source release is no longer in storage", http.StatusConflict)
+				return
+			}
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	releaseNotes, localizedNotes := source.ReleaseNotes, source.LocalizedNotes
+	if req.ReleaseNotes != nil {
+		//the translations would no longer match the new notes
+		releaseNotes, localizedNotes = *req.ReleaseNotes, nil
+	}
+
+	previous := h.versionStore.Get(req.TargetChannel)
+
+	versionInfo := &models.VersionInfo{
+		Channel:        req.TargetChannel,
+		Version:        source.Version,
+		VersionCode:    source.VersionCode,
+		DownloadURL:    downloadURL(h.apiURL, req.TargetChannel),
+		ReleaseNotes:   releaseNotes,
+		LocalizedNotes: localizedNotes,
+		DefaultLocale:  source.DefaultLocale,
+		PublishedAt:    time.Now().UTC(),
+		PromotedFrom:   channel,
+		ABIs:           source.ABIs,
+		PackageName:    source.PackageName,
+		TargetSdk:      source.TargetSdk,
+	}
+	for _, artifact := range artifacts {
+		versionInfo.SetArtifact(artifact)
+	}
+
+	if err := h.versionStore.Set(versionInfo); err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	pruneReleases(r.Context(), h.storage, h.versionStore, req.TargetChannel)
+
+	actor := actorFor(r, req.PerformedBy)
+	if h.db != nil {
+		recordRelease(r.Context(), h.db, versionInfo)
+
+		event := &database.ReleaseEvent{
+			Channel:       string(req.TargetChannel),
+			Version:       version,
+			Event:         "promote",
+			SourceChannel: string(channel),
+			Actor:         actor,
+			Reason:        req.Reason,
+		}
+		if previous != nil {
+			event.PreviousVersion = previous.Version
+		}
+		if err := h.db.LogReleaseEvent(r.Context(), event); err != nil {
+			log.Printf("Failed to log promotion: %v", err)
+		}
+	}
+	log.Printf("Promoted v%s from %s to %s (by %s)", version, channel, req.TargetChannel, actor)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Promoted v%s from %s to %s", version, channel, req.TargetChannel),
+		"version": versionInfo,
+	})
+}
+
+type MandatoryRequest struct {
+	Mandatory   bool   `json:"mandatory"`
+	Reason      string `json:"reason"`
+	PerformedBy string `json:"performed_by"`
+}
+
+//SetMandatory flags a release as a mandatory update, e.g. for security fixes
+func (h *ReleasesHandler) SetMandatory(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	version := chi.URLParam(r, "version")
+
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	var req MandatoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	versionInfo, err := h.versionStore.SetMandatory(channel, version, req.Mandatory)
+	if errors.Is(err, models.ErrReleaseNotFound) {
+		http.Error(w, "Release not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	actor := actorFor(r, req.PerformedBy)
+	if h.db != nil {
+		event := "mandatory"
+		if !req.Mandatory {
+			event = "optional"
+		}
+		if err := h.db.LogReleaseEvent(r.Context(), &database.ReleaseEvent{
+			Channel: string(channel),
+			Version: version,
+			Event:   event,
+			Actor:   actor,
+			Reason:  req.Reason,
+		}); err != nil {
+			log.Printf("Failed to log mandatory change: %v", err)
+		}
+	}
+	log.Printf("Set mandatory=%v on %s v%s (by %s)", req.Mandatory, channel, version, actor)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"version": versionInfo,
+	})
+}
+
+type YankRequest struct {
+	Reason             string `json:"reason"`
+	ReplacementVersion string `json:"replacement_version"`
+	PerformedBy        string `json:"performed_by"`
+}
+
+//Yank revokes a published release. Clients running it are told to move to
+//the replacement and downloads of it are refused.
+func (h *ReleasesHandler) Yank(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	version := chi.URLParam(r, "version")
+
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	var req YankRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	existing := h.versionStore.GetVersion(channel, version)
+	if existing == nil {
+		http.Error(w, "Release not found", http.StatusNotFound)
+		return
+	}
+	if existing.IsYanked() {
+		http.Error(w, fmt.Sprintf("v%s is already yanked", version), http.StatusConflict)
+		return
+	}
+	if req.ReplacementVersion != "" {
+		replacement := h.versionStore.GetVersion(channel, req.ReplacementVersion)
+		if replacement == nil || replacement.IsYanked() || replacement.Version == version {
+			http.Error(w, "replacement_version must be another published release of the channel", http.StatusBadRequest)
+			return
+		}
+	}
+
+	previous := h.versionStore.Get(channel)
+	yank := models.YankInfo{
+		Reason:             req.Reason,
+		ReplacementVersion: req.ReplacementVersion,
+		YankedAt:           time.Now().UTC(),
+	}
+
+	versionInfo, err := h.versionStore.Yank(channel, version, yank)
+	if err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	actor := actorFor(r, req.PerformedBy)
+	if h.db != nil {
+		if err := h.db.YankRelease(r.Context(), string(channel), version, req.Reason, yank.YankedAt); err != nil {
+			log.Printf("Failed to mark release as yanked: %v", err)
+		}
+		event := &database.ReleaseEvent{
+			Channel: string(channel),
+			Version: version,
+			Event:   "yank",
+			Actor:   actor,
+			Reason:  req.Reason,
+		}
+		if previous != nil {
+			event.PreviousVersion = previous.Version
+		}
+		if err := h.db.LogReleaseEvent(r.Context(), event); err != nil {
+			log.Printf("Failed to log yank: %v", err)
+		}
+	}
+	log.Printf("Yanked %s v%s (by %s): %s", channel, version, actor, req.Reason)
+
+	resp := map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Yanked %s v%s", channel, version),
+		"version": versionInfo,
+	}
+	if current := h.versionStore.Get(channel); current != nil {
+		resp["current_version"] = current.Version
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+//YankNotice tells a client that the build it runs has been yanked and which
+//release it should move to
+type YankNotice struct {
+	Version     string              `json:"version"`
+	VersionCode int                 `json:"version_code"`
+	Reason      string              `json:"reason"`
+	YankedAt    time.Time           `json:"yanked_at"`
+	Replacement *models.VersionInfo `json:"replacement"`
+}
+
+//yankNoticeFor returns a notice if the release with versionCode is yanked
+func yankNoticeFor(vs *models.VersionStore, channel models.Channel, versionCode int, installID string) *YankNotice {
+	installed := vs.FindByVersionCode(channel, versionCode)
+	if installed == nil || !installed.IsYanked() {
+		return nil
+	}
+
+	notice := &YankNotice{
+		Version:     installed.Version,
+		VersionCode: installed.VersionCode,
+		Reason:      installed.Yanked.Reason,
+		YankedAt:    installed.Yanked.YankedAt,
+	}
+	if replacement := vs.GetVersion(channel, installed.Yanked.ReplacementVersion); replacement != nil && !replacement.IsYanked() {
+		notice.Replacement = replacement
+	} else {
+		notice.Replacement = vs.Resolve(channel, installID)
+	}
+	return notice
+}
+
+//recordRelease mirrors a published release into the releases table
+func recordRelease(ctx context.Context, db *database.DB, info *models.VersionInfo) {
+	if db == nil {
+		return
+	}
+
+	//a single APK is fully described by the other columns
+	var artifacts string
+	if len(info.Artifacts) > 1 || info.ArtifactType != models.ArtifactTypeAPK {
+		data, err := json.Marshal(info.Artifacts)
+		if err != nil {
+			log.Printf("Failed to encode artifacts of %s v%s: %v", info.Channel, info.Version, err)
+		}
+		artifacts = string(data)
+	}
+
+	var localizedNotes string
+	if len(info.LocalizedNotes) > 0 {
+		data, err := json.Marshal(info.LocalizedNotes)
+		if err != nil {
+			log.Printf("Failed to encode release notes of %s v%s: %v", info.Channel, info.Version, err)
+		}
+		localizedNotes = string(data)
+	}
+
+	if _, err := db.InsertRelease(ctx, &database.Release{
+		Channel:        string(info.Channel),
+		Version:        info.Version,
+		VersionCode:    info.VersionCode,
+		FileName:       info.FileName,
+		FileSize:       info.FileSize,
+		SHA256:         info.SHA256,
+		ReleaseNotes:   info.ReleaseNotes,
+		PublishedAt:    info.PublishedAt,
+		SignerSHA256:   info.SignerSHA256,
+		Artifacts:      artifacts,
+		LocalizedNotes: localizedNotes,
+		DefaultLocale:  info.DefaultLocale,
+	}); err != nil {
+		log.Printf("Failed to record release %s v%s: %v", info.Channel, info.Version, err)
+	}
+}
+
+//BackfillHistory copies releases recorded in the database into the version
+//store so history published before the store tracked it stays visible
+func BackfillHistory(ctx context.Context, vs *models.VersionStore, db *database.DB, apiURL string) error {
+	if db == nil {
+		return nil
+	}
+
+	releases, err := db.ListReleases(ctx)
+	if err != nil {
+		return err
+	}
+
+	byChannel := make(map[models.Channel][]*models.VersionInfo)
+	for _, rel := range releases {
+		channel := models.Channel(rel.Channel)
+		var artifacts []models.Artifact
+		if rel.Artifacts != "" {
+			if err := json.Unmarshal([]byte(rel.Artifacts), &artifacts); err != nil {
+				return fmt.Errorf("decoding artifacts of %s v%s: %w", rel.Channel, rel.Version, err)
+			}
+		}
+		var localizedNotes map[string]string
+		if rel.LocalizedNotes != "" {
+			if err := json.Unmarshal([]byte(rel.LocalizedNotes), &localizedNotes); err != nil {
+				return fmt.Errorf("decoding release notes of %s v%s: %w", rel.Channel, rel.Version, err)
+			}
+		}
+		byChannel[channel] = append(byChannel[channel], &models.VersionInfo{
+			Channel:        channel,
+			Version:        rel.Version,
+			VersionCode:    rel.VersionCode,
+			DownloadURL:    downloadURL(apiURL, channel),
+			FileSize:       rel.FileSize,
+			SHA256:         rel.SHA256,
+			ReleaseNotes:   rel.ReleaseNotes,
+			PublishedAt:    rel.PublishedAt,
+			FileName:       rel.FileName,
+			SignerSHA256:   rel.SignerSHA256,
+			Artifacts:      artifacts,
+			LocalizedNotes: localizedNotes,
+			DefaultLocale:  rel.DefaultLocale,
+		})
+	}
+
+	for channel, history := range byChannel {
+		if err := vs.MergeHistory(channel, history); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//invalidChannel rejects a request naming an unknown channel and lists the
+//public ones
+func invalidChannel(w http.ResponseWriter, vs *models.VersionStore, field string) {
+	var names []string
+	for _, channel := range vs.ListChannels(false) {
+		names = append(names, string(channel.Name))
+	}
+	http.Error(w, fmt.Sprintf("Invalid %s. Must be one of: %s", field, strings.Join(names, ", ")), http.StatusBadRequest)
+}
+
+func queryInt(r *http.Request, key string, fallback int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
\ No newline at end of file
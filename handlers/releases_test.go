@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sono-version-service/models"
+)
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		total, page, perPage int
+		start, end           int
+	}{
+		{0, 1, 20, 0, 0},
+		{5, 1, 20, 0, 5},
+		{45, 1, 20, 0, 20},
+		{45, 2, 20, 20, 40},
+		{45, 3, 20, 40, 45},
+		{45, 4, 20, 45, 45},
+		{40, 2, 20, 20, 40},
+		{40, 3, 20, 40, 40},
+		{10, math.MaxInt, 100, 10, 10},
+		{10, math.MaxInt / 20, 20, 10, 10},
+	}
+	for _, tt := range tests {
+		start, end := pageBounds(tt.total, tt.page, tt.perPage)
+		if start != tt.start || end != tt.end {
+			t.Errorf("pageBounds(%d, %d, %d) = %d, %d, want %d, %d", tt.total, tt.page, tt.perPage, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestListReleases(t *testing.T) {
+	store := newTestStore(t)
+	for i := 1; i <= 5; i++ {
+		publishTestRelease(t, store, models.ChannelStable, fmt.Sprintf("1.%d.0", i), i)
+	}
+	h := NewReleasesHandler(newTestStorage(t), store, nil, "sono", "http://localhost/api/v1")
+
+	tests := []struct {
+		name     string
+		target   string
+		status   int
+		versions []string
+	}{
+		{"first page", "/releases/stable?per_page=2", http.StatusOK, []string{"1.5.0", "1.4.0"}},
+		{"last page", "/releases/stable?per_page=2&page=3", http.StatusOK, []string{"1.1.0"}},
+		{"past the end", "/releases/stable?per_page=2&page=9", http.StatusOK, []string{}},
+		{"huge page", "/releases/stable?page=9223372036854775807", http.StatusOK, []string{}},
+		{"default page size", "/releases/stable", http.StatusOK, []string{"1.5.0", "1.4.0", "1.3.0", "1.2.0", "1.1.0"}},
+		{"empty channel", "/releases/beta", http.StatusOK, []string{}},
+		{"page zero", "/releases/stable?page=0", http.StatusBadRequest, nil},
+		{"invalid page", "/releases/stable?page=x", http.StatusBadRequest, nil},
+		{"invalid per_page", "/releases/stable?per_page=0", http.StatusBadRequest, nil},
+		{"unknown channel", "/releases/canary", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(http.MethodGet, "/releases/{channel}", h.List, tt.target, nil, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Total    int                   `json:"total"`
+				Releases []*models.VersionInfo `json:"releases"`
+			}
+			decodeJSON(t, rec, &resp)
+			if len(resp.Releases) != len(tt.versions) {
+				t.Fatalf("got %d releases, want %v", len(resp.Releases), tt.versions)
+			}
+			for i, info := range resp.Releases {
+				if info.Version != tt.versions[i] {
+					t.Errorf("releases[%d] = %s, want %s", i, info.Version, tt.versions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetRelease(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	publishTestRelease(t, store, models.ChannelStable, "1.1.0", 2)
+	h := NewReleasesHandler(newTestStorage(t), store, nil, "sono", "http://localhost/api/v1")
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/version/stable/1.0.0", http.StatusOK},
+		{"/version/stable/1.1.0", http.StatusOK},
+		{"/version/stable/2.0.0", http.StatusNotFound},
+		{"/version/beta/1.0.0", http.StatusNotFound},
+		{"/version/canary/1.0.0", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodGet, "/version/{channel}/{version}", h.Get, tt.target, nil, nil)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.target, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestPromote(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		setup   func(t *testing.T, store *models.VersionStore)
+		status  int
+		notes   string
+		fileKey string //expected storage key of the promoted release
+	}{
+		{
+			name:    "reference source artifact",
+			target:  "/releases/nightly/1.0.0/promote",
+			body:    `{"target_channel": "beta"}`,
+			status:  http.StatusOK,
+			notes:   "Notes for 1.0.0",
+			fileKey: "nightly/sono-1.0.0.apk",
+		},
+		{
+			name:    "copy artifact",
+			target:  "/releases/nightly/1.0.0/promote",
+			body:    `{"target_channel": "beta", "copy_artifact": true}`,
+			status:  http.StatusOK,
+			notes:   "Notes for 1.0.0",
+			fileKey: "beta/sono-beta-v1.0.0.apk",
+		},
+		{
+			name:    "override notes",
+			target:  "/releases/nightly/1.0.0/promote",
+			body:    `{"target_channel": "beta", "release_notes": "Beta notes"}`,
+			status:  http.StatusOK,
+			notes:   "Beta notes",
+			fileKey: "nightly/sono-1.0.0.apk",
+		},
+		{
+			name:   "same channel",
+			target: "/releases/nightly/1.0.0/promote",
+			body:   `{"target_channel": "nightly"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown target channel",
+			target: "/releases/nightly/1.0.0/promote",
+			body:   `{"target_channel": "canary"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown release",
+			target: "/releases/nightly/9.9.9/promote",
+			body:   `{"target_channel": "beta"}`,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "different build in target",
+			target: "/releases/nightly/1.0.0/promote",
+			body:   `{"target_channel": "beta"}`,
+			setup: func(t *testing.T, store *models.VersionStore) {
+				info := *publishTestRelease(t, store, models.ChannelBeta, "1.0.0", 1)
+				info.SHA256 = strings.Repeat("cd", 32)
+				store.Set(&info)
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name:   "signer not allowed in target",
+			target: "/releases/nightly/1.0.0/promote",
+			body:   `{"target_channel": "beta"}`,
+			setup: func(t *testing.T, store *models.VersionStore) {
+				store.UpdateChannel(models.ChannelBeta, func(c *models.ChannelSettings) {
+					c.AllowedSigners = []string{strings.Repeat("ab", 32)}
+				})
+			},
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "invalid body",
+			target: "/releases/nightly/1.0.0/promote",
+			body:   `{`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			s := newTestStorage(t)
+			storeTestArtifacts(t, s, publishTestRelease(t, store, models.ChannelNightly, "1.0.0", 1))
+			if tt.setup != nil {
+				tt.setup(t, store)
+			}
+			h := NewReleasesHandler(s, store, nil, "sono", "http://localhost/api/v1")
+
+			rec := serve(http.MethodPost, "/releases/{channel}/{version}/promote", h.Promote, tt.target, strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			promoted := store.Get(models.ChannelBeta)
+			if promoted == nil || promoted.Version != "1.0.0" {
+				t.Fatalf("beta is on %v, want 1.0.0", promoted)
+			}
+			if promoted.PromotedFrom != models.ChannelNightly {
+				t.Errorf("PromotedFrom = %q, want nightly", promoted.PromotedFrom)
+			}
+			if promoted.ReleaseNotes != tt.notes {
+				t.Errorf("ReleaseNotes = %q, want %q", promoted.ReleaseNotes, tt.notes)
+			}
+			if promoted.FileName != tt.fileKey {
+				t.Errorf("FileName = %q, want %q", promoted.FileName, tt.fileKey)
+			}
+			if promoted.DownloadURL != "http://localhost/api/v1/download/beta" {
+				t.Errorf("DownloadURL = %q", promoted.DownloadURL)
+			}
+			if exists, _ := s.Exists(context.Background(), promoted.FileName); !exists {
+				t.Errorf("%s is not in storage", promoted.FileName)
+			}
+			if source := store.Get(models.ChannelNightly); source.FileName != "nightly/sono-1.0.0.apk" {
+				t.Errorf("source release changed to %s", source.FileName)
+			}
+		})
+	}
+}
+
+func TestSetMandatory(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		body      string
+		status    int
+		mandatory bool
+	}{
+		{"flag", "/releases/stable/1.0.0/mandatory", `{"mandatory": true, "reason": "CVE"}`, http.StatusOK, true},
+		{"unflag", "/releases/stable/1.0.0/mandatory", `{"mandatory": false}`, http.StatusOK, false},
+		{"unknown release", "/releases/stable/9.9.9/mandatory", `{"mandatory": true}`, http.StatusNotFound, false},
+		{"unknown channel", "/releases/canary/1.0.0/mandatory", `{"mandatory": true}`, http.StatusBadRequest, false},
+		{"invalid body", "/releases/stable/1.0.0/mandatory", `{`, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+			h := NewReleasesHandler(newTestStorage(t), store, nil, "sono", "http://localhost/api/v1")
+
+			rec := serve(http.MethodPost, "/releases/{channel}/{version}/mandatory", h.SetMandatory, tt.target, strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := store.Get(models.ChannelStable).Mandatory; got != tt.mandatory {
+				t.Errorf("Mandatory = %v, want %v", got, tt.mandatory)
+			}
+		})
+	}
+}
+
+func TestYank(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		status  int
+		yanked  bool
+		current string
+	}{
+		{"current release", "/releases/stable/1.2.0/yank", `{"reason": "crash on start"}`, http.StatusOK, true, "1.0.0"},
+		{"older release", "/releases/stable/1.0.0/yank", `{"reason": "data loss"}`, http.StatusOK, false, "1.2.0"},
+		{"with replacement", "/releases/stable/1.2.0/yank", `{"reason": "crash", "replacement_version": "1.0.0"}`, http.StatusOK, true, "1.0.0"},
+		{"yanked replacement", "/releases/stable/1.2.0/yank", `{"reason": "crash", "replacement_version": "1.1.0"}`, http.StatusBadRequest, false, "1.2.0"},
+		{"replacement is itself", "/releases/stable/1.2.0/yank", `{"reason": "crash", "replacement_version": "1.2.0"}`, http.StatusBadRequest, false, "1.2.0"},
+		{"unknown replacement", "/releases/stable/1.2.0/yank", `{"reason": "crash", "replacement_version": "9.9.9"}`, http.StatusBadRequest, false, "1.2.0"},
+		{"missing reason", "/releases/stable/1.2.0/yank", `{}`, http.StatusBadRequest, false, "1.2.0"},
+		{"already yanked", "/releases/stable/1.1.0/yank", `{"reason": "again"}`, http.StatusConflict, false, "1.2.0"},
+		{"unknown release", "/releases/stable/9.9.9/yank", `{"reason": "crash"}`, http.StatusNotFound, false, "1.2.0"},
+		{"unknown channel", "/releases/canary/1.2.0/yank", `{"reason": "crash"}`, http.StatusBadRequest, false, "1.2.0"},
+		{"invalid body", "/releases/stable/1.2.0/yank", `{`, http.StatusBadRequest, false, "1.2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			for i, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+				publishTestRelease(t, store, models.ChannelStable, version, i+1)
+			}
+			//the fallback skips releases that are already yanked
+			store.Yank(models.ChannelStable, "1.1.0", models.YankInfo{Reason: "earlier"})
+			h := NewReleasesHandler(newTestStorage(t), store, nil, "sono", "http://localhost/api/v1")
+
+			rec := serve(http.MethodPost, "/releases/{channel}/{version}/yank", h.Yank, tt.target, strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if current := store.Get(models.ChannelStable); current.Version != tt.current {
+				t.Errorf("current = %s, want %s", current.Version, tt.current)
+			}
+			if yanked := store.GetVersion(models.ChannelStable, "1.2.0").IsYanked(); yanked != tt.yanked {
+				t.Errorf("1.2.0 yanked = %v, want %v", yanked, tt.yanked)
+			}
+		})
+	}
+}
+
+func TestYankNotice(t *testing.T) {
+	type yank struct {
+		version, replacement string
+	}
+	tests := []struct {
+		name        string
+		yanks       []yank
+		versionCode int
+		notice      bool
+		replacement string //empty when no release is left to move to
+	}{
+		{"not yanked", nil, 2, false, ""},
+		{"unknown build", []yank{{"1.1.0", ""}}, 9, false, ""},
+		{"explicit replacement", []yank{{"1.1.0", "1.0.0"}}, 2, true, "1.0.0"},
+		{"replacement yanked later", []yank{{"1.1.0", "1.0.0"}, {"1.0.0", ""}}, 2, true, "1.2.0"},
+		{"current release", []yank{{"1.1.0", ""}}, 2, true, "1.2.0"},
+		{"yanked current release", []yank{{"1.2.0", ""}}, 3, true, "1.1.0"},
+		{"nothing left", []yank{{"1.0.0", ""}, {"1.1.0", ""}, {"1.2.0", ""}}, 3, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			for i, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+				publishTestRelease(t, store, models.ChannelStable, version, i+1)
+			}
+			for _, y := range tt.yanks {
+				if _, err := store.Yank(models.ChannelStable, y.version, models.YankInfo{Reason: "crash", ReplacementVersion: y.replacement}); err != nil {
+					t.Fatalf("Yank(%s): %v", y.version, err)
+				}
+			}
+
+			notice := yankNoticeFor(store, models.ChannelStable, tt.versionCode, "device")
+			if (notice != nil) != tt.notice {
+				t.Fatalf("notice = %+v, want %v", notice, tt.notice)
+			}
+			if notice == nil {
+				return
+			}
+			if notice.VersionCode != tt.versionCode || notice.Reason != "crash" {
+				t.Errorf("notice = %+v", notice)
+			}
+			replacement := ""
+			if notice.Replacement != nil {
+				replacement = notice.Replacement.Version
+			}
+			if replacement != tt.replacement {
+				t.Errorf("replacement = %q, want %q", replacement, tt.replacement)
+			}
+		})
+	}
+}
\ No newline at end of file
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/database"
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+type ScheduleHandler struct {
+	storage      storage.Storage
+	versionStore *models.VersionStore
+	db           *database.DB
+}
+
+func NewScheduleHandler(s storage.Storage, vs *models.VersionStore, db *database.DB) *ScheduleHandler {
+	return &ScheduleHandler{
+		storage:      s,
+		versionStore: vs,
+		db:           db,
+	}
+}
+
+//List returns the pending releases, optionally filtered by ?channel=
+func (h *ScheduleHandler) List(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(r.URL.Query().Get("channel"))
+	if channel != "" && !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"scheduled": h.versionStore.ListScheduled(channel),
+	})
+}
+
+type RescheduleRequest struct {
+	PublishAt   *time.Time `json:"publish_at"`
+	PerformedBy string     `json:"performed_by"`
+}
+
+//Reschedule moves the publish time of a pending release
+func (h *ScheduleHandler) Reschedule(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	version := chi.URLParam(r, "version")
+
+	var req RescheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.PublishAt == nil {
+		http.Error(w, "publish_at is required", http.StatusBadRequest)
+		return
+	}
+
+	publishAt := req.PublishAt.UTC()
+	versionInfo, err := h.versionStore.Reschedule(channel, version, publishAt)
+	if errors.Is(err, models.ErrReleaseNotFound) {
+		http.Error(w, "Scheduled release not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	h.logEvent(r, channel, version, "reschedule", req.PerformedBy, publishAt.Format(time.RFC3339))
+	log.Printf("Rescheduled %s v%s for %s", channel, version, publishAt.Format(time.RFC3339))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"version": versionInfo,
+	})
+}
+
+type CancelRequest struct {
+	Reason      string `json:"reason"`
+	PerformedBy string `json:"performed_by"`
+}
+
+//Cancel drops a pending release and deletes its artifact
+func (h *ScheduleHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	channel := models.Channel(chi.URLParam(r, "channel"))
+	version := chi.URLParam(r, "version")
+
+	var req CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	versionInfo, err := h.versionStore.CancelScheduled(channel, version)
+	if errors.Is(err, models.ErrReleaseNotFound) {
+		http.Error(w, "Scheduled release not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	for _, artifact := range versionInfo.Files() {
+		if h.versionStore.ArtifactInUse(artifact.FileName) {
+			continue
+		}
+		if err := h.storage.Delete(r.Context(), artifact.FileName); err != nil {
+			log.Printf("Failed to delete cancelled file %s: %v", artifact.FileName, err)
+		}
+	}
+
+	h.logEvent(r, channel, version, "cancel", req.PerformedBy, req.Reason)
+	log.Printf("Cancelled scheduled release %s v%s", channel, version)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Cancelled scheduled release %s v%s", channel, version),
+	})
+}
+
+func (h *ScheduleHandler) logEvent(r *http.Request, channel models.Channel, version, event, performedBy, reason string) {
+	if h.db == nil {
+		return
+	}
+	if err := h.db.LogReleaseEvent(r.Context(), &database.ReleaseEvent{
+		Channel: string(channel),
+		Version: version,
+		Event:   event,
+		Actor:   actorFor(r, performedBy),
+		Reason:  reason,
+	}); err != nil {
+		log.Printf("Failed to log %s: %v", event, err)
+	}
+}
+
+//Scheduler publishes scheduled releases once their publish time has passed
+type Scheduler struct {
+	storage      storage.Storage
+	versionStore *models.VersionStore
+	db           *database.DB
+	app          string
+}
+
+func NewScheduler(s storage.Storage, vs *models.VersionStore, db *database.DB, app string) *Scheduler {
+	return &Scheduler{
+		storage:      s,
+		versionStore: vs,
+		db:           db,
+		app:          app,
+	}
+}
+
+//Run checks for due releases every interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.activateDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) activateDue(ctx context.Context) {
+	activated, err := s.versionStore.ActivateDue(time.Now())
+	if err != nil {
+		log.Printf("Scheduler: Failed to save activated releases of %s: %v", s.app, err)
+	}
+
+	for _, info := range activated {
+		log.Printf("Scheduler: Activated %s %s v%s", s.app, info.Channel, info.Version)
+
+		dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		recordRelease(dbCtx, s.db, info)
+		if s.db != nil {
+			if err := s.db.LogReleaseEvent(dbCtx, &database.ReleaseEvent{
+				Channel: string(info.Channel),
+				Version: info.Version,
+				Event:   "activate",
+				Actor:   "scheduler",
+			}); err != nil {
+				log.Printf("Scheduler: Failed to log activation: %v", err)
+			}
+		}
+		cancel()
+
+		pruneReleases(ctx, s.storage, s.versionStore, info.Channel)
+	}
+}
\ No newline at end of file
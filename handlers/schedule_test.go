@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sono-version-service/models"
+)
+
+//scheduleTestRelease stores a pending release of version with its artifact
+func scheduleTestRelease(t *testing.T, store *models.VersionStore, channel models.Channel, version string, publishAt time.Time) *models.VersionInfo {
+	t.Helper()
+	info := &models.VersionInfo{
+		Channel:      channel,
+		Version:      version,
+		FileName:     string(channel) + "/sono-" + version + ".apk",
+		ArtifactType: models.ArtifactTypeAPK,
+		PublishAt:    &publishAt,
+	}
+	if err := store.Schedule(info); err != nil {
+		t.Fatalf("Schedule(%s v%s): %v", channel, version, err)
+	}
+	return info
+}
+
+func TestListScheduled(t *testing.T) {
+	store := newTestStore(t)
+	future := time.Now().Add(time.Hour)
+	scheduleTestRelease(t, store, models.ChannelStable, "1.1.0", future)
+	scheduleTestRelease(t, store, models.ChannelBeta, "2.0.0", future.Add(-time.Minute))
+	h := NewScheduleHandler(newTestStorage(t), store, nil)
+
+	tests := []struct {
+		target   string
+		status   int
+		versions []string
+	}{
+		{"/scheduled", http.StatusOK, []string{"2.0.0", "1.1.0"}},
+		{"/scheduled?channel=stable", http.StatusOK, []string{"1.1.0"}},
+		{"/scheduled?channel=nightly", http.StatusOK, []string{}},
+		{"/scheduled?channel=canary", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodGet, "/scheduled", h.List, tt.target, nil, nil)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.target, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Scheduled []*models.VersionInfo `json:"scheduled"`
+		}
+		decodeJSON(t, rec, &resp)
+		if len(resp.Scheduled) != len(tt.versions) {
+			t.Errorf("GET %s returned %d releases, want %v", tt.target, len(resp.Scheduled), tt.versions)
+			continue
+		}
+		for i, info := range resp.Scheduled {
+			if info.Version != tt.versions[i] {
+				t.Errorf("GET %s: scheduled[%d] = %s, want %s", tt.target, i, info.Version, tt.versions[i])
+			}
+		}
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+		want   time.Time
+	}{
+		{"move", "/scheduled/stable/1.1.0/reschedule", `{"publish_at": "2030-01-02T10:00:00+02:00"}`, http.StatusOK, time.Date(2030, 1, 2, 8, 0, 0, 0, time.UTC)},
+		{"missing publish_at", "/scheduled/stable/1.1.0/reschedule", `{}`, http.StatusBadRequest, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"invalid timestamp", "/scheduled/stable/1.1.0/reschedule", `{"publish_at": "tomorrow"}`, http.StatusBadRequest, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"unknown release", "/scheduled/stable/9.9.9/reschedule", `{"publish_at": "2030-01-02T10:00:00Z"}`, http.StatusNotFound, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"other channel", "/scheduled/beta/1.1.0/reschedule", `{"publish_at": "2030-01-02T10:00:00Z"}`, http.StatusNotFound, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			scheduleTestRelease(t, store, models.ChannelStable, "1.1.0", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+			h := NewScheduleHandler(newTestStorage(t), store, nil)
+
+			rec := serve(http.MethodPost, "/scheduled/{channel}/{version}/reschedule", h.Reschedule, tt.target, strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			got := store.GetScheduled(models.ChannelStable, "1.1.0").PublishAt
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("PublishAt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		inUse    bool //a published release shares the artifact
+		status   int
+		deleted  bool
+		canceled bool
+	}{
+		{"cancel", "/scheduled/stable/1.1.0/cancel", `{"reason": "regression"}`, false, http.StatusOK, true, true},
+		{"without body", "/scheduled/stable/1.1.0/cancel", "", false, http.StatusOK, true, true},
+		{"artifact in use", "/scheduled/stable/1.1.0/cancel", "", true, http.StatusOK, false, true},
+		{"unknown release", "/scheduled/stable/9.9.9/cancel", "", false, http.StatusNotFound, false, false},
+		{"invalid body", "/scheduled/stable/1.1.0/cancel", "{", false, http.StatusBadRequest, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			s := newTestStorage(t)
+			info := scheduleTestRelease(t, store, models.ChannelStable, "1.1.0", time.Now().Add(time.Hour))
+			storeTestArtifacts(t, s, info)
+			if tt.inUse {
+				published := *info
+				published.Channel = models.ChannelBeta
+				published.PublishAt = nil
+				if err := store.Set(&published); err != nil {
+					t.Fatalf("Set: %v", err)
+				}
+			}
+			h := NewScheduleHandler(s, store, nil)
+
+			rec := serve(http.MethodPost, "/scheduled/{channel}/{version}/cancel", h.Cancel, tt.target, strings.NewReader(tt.body), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if canceled := store.GetScheduled(models.ChannelStable, "1.1.0") == nil; canceled != tt.canceled {
+				t.Errorf("canceled = %v, want %v", canceled, tt.canceled)
+			}
+			if exists, _ := s.Exists(context.Background(), info.FileName); exists == tt.deleted {
+				t.Errorf("artifact in storage = %v, want %v", exists, !tt.deleted)
+			}
+		})
+	}
+}
+
+func TestSchedulerActivatesDueReleases(t *testing.T) {
+	store := newTestStore(t)
+	store.UpdateChannel(models.ChannelStable, func(s *models.ChannelSettings) { s.Retention = 1 })
+	s := newTestStorage(t)
+	storeTestArtifacts(t, s, publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1))
+	storeTestArtifacts(t, s, scheduleTestRelease(t, store, models.ChannelStable, "1.1.0", time.Now().Add(-time.Minute)))
+	scheduleTestRelease(t, store, models.ChannelStable, "1.2.0", time.Now().Add(time.Hour))
+
+	NewScheduler(s, store, nil, "sono").activateDue(context.Background())
+
+	if current := store.Get(models.ChannelStable); current.Version != "1.1.0" || current.PublishAt != nil {
+		t.Errorf("current = %s (publish_at %v), want 1.1.0", current.Version, current.PublishAt)
+	}
+	if pending := store.ListScheduled(models.ChannelStable); len(pending) != 1 || pending[0].Version != "1.2.0" {
+		t.Errorf("pending = %v, want only 1.2.0", pending)
+	}
+	//activation applies the channel's retention
+	if exists, _ := s.Exists(context.Background(), "stable/sono-1.0.0.apk"); exists {
+		t.Error("the pruned release's artifact was kept")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const tusVersion = "1.0.0"
+
+//staged uploads that haven't been written to for this long are removed
+const tusUploadExpiry = 24 * time.Hour
+
+//TusHandler implements the tus 1.0 core protocol with the creation and
+//termination extensions, so CI runners can resume interrupted uploads.
+//Chunks are staged on local disk and the completed APK goes through the same
+//publish path as a regular upload.
+type TusHandler struct {
+	uploads *UploadHandler
+	dir     string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewTusHandler(uploads *UploadHandler, dir string) (*TusHandler, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating tus staging directory: %w", err)
+	}
+	return &TusHandler{
+		uploads: uploads,
+		dir:     dir,
+		locks:   make(map[string]*sync.Mutex),
+	}, nil
+}
+
+//tusUpload is the state of a staged upload kept next to its data. The offset
+//is the size of the data file.
+type tusUpload struct {
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (h *TusHandler) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.uploads.maxUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//Create starts an upload. The release metadata is passed in Upload-Metadata
+//using the field names of the JSON upload and checked right away, so a bad
+//request fails before any data is sent.
+func (h *TusHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length header is required", http.StatusBadRequest)
+		return
+	}
+	if length > h.uploads.maxUploadSize {
+		http.Error(w, fmt.Sprintf("File exceeds the maximum upload size of %d bytes", h.uploads.maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata header", http.StatusBadRequest)
+		return
+	}
+	req, err := parseUploadForm(tusFields(metadata))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !req.Validate() {
+		http.Error(w, "Invalid request: missing required fields", http.StatusBadRequest)
+		return
+	}
+	if !h.uploads.checkTarget(w, req) {
+		return
+	}
+
+	h.removeExpired()
+
+	id, err := newUploadID()
+	if err != nil {
+		log.Printf("Failed to generate upload id: %v", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	upload := &tusUpload{Length: length, Metadata: metadata, CreatedAt: time.Now().UTC()}
+	if err := h.save(id, upload); err != nil {
+		log.Printf("Failed to create upload %s: %v", id, err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	file, err := os.Create(h.dataPath(id))
+	if err != nil {
+		log.Printf("Failed to create upload %s: %v", id, err)
+		os.Remove(h.infoPath(id))
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	file.Close()
+
+	log.Printf("Created resumable upload %s for %s v%s (%d bytes)", id, req.Channel, req.Version, length)
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Location", h.uploads.apiURL+"/tus/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+//Head reports how much of an upload has been received
+func (h *TusHandler) Head(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	upload, offset, err := h.load(id)
+	if err != nil {
+		tusNotFound(w, err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+//Patch appends a chunk at Upload-Offset. The chunk that completes the upload
+//publishes the release, and any publish error is returned on that request.
+func (h *TusHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || clientOffset < 0 {
+		http.Error(w, "Upload-Offset header is required", http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	lock := h.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, offset, err := h.load(id)
+	if err != nil {
+		tusNotFound(w, err)
+		return
+	}
+	if clientOffset != offset {
+		http.Error(w, fmt.Sprintf("Upload-Offset %d does not match the current offset %d", clientOffset, offset), http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(h.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Failed to open upload %s: %v", id, err)
+		http.Error(w, "Failed to write chunk", http.StatusInternalServerError)
+		return
+	}
+	//keep whatever arrived before a dropped connection, the client resumes
+	//from the offset it gets from HEAD
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, upload.Length-offset))
+	file.Close()
+	offset += written
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if copyErr != nil {
+		log.Printf("Upload %s interrupted at %d of %d bytes: %v", id, offset, upload.Length, copyErr)
+		http.Error(w, "Failed to read chunk", http.StatusBadRequest)
+		return
+	}
+
+	if offset == upload.Length {
+		if !h.complete(w, r, id, upload) {
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//complete publishes a fully received upload. The staged files are removed
+//once it is published or rejected as invalid; after a server side failure
+//they are kept so an empty PATCH at the final offset can retry the publish.
+func (h *TusHandler) complete(w http.ResponseWriter, r *http.Request, id string, upload *tusUpload) bool {
+	fail := func(status int, message string) bool {
+		if status < http.StatusInternalServerError {
+			h.remove(id)
+		}
+		http.Error(w, message, status)
+		return false
+	}
+
+	req, err := parseUploadForm(tusFields(upload.Metadata))
+	if err != nil {
+		return fail(http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+	}
+	if !h.uploads.checkTarget(w, req) {
+		h.remove(id)
+		return false
+	}
+
+	art, err := openArtifact(h.dataPath(id))
+	if err != nil {
+		log.Printf("Failed to read upload %s: %v", id, err)
+		return fail(http.StatusInternalServerError, "Failed to read upload")
+	}
+	defer art.Close()
+
+	log.Printf("Resumable upload %s completed for %s v%s", id, req.Channel, req.Version)
+	if _, err := h.uploads.publish(r.Context(), req, art, "tus"); err != nil {
+		status := http.StatusInternalServerError
+		var uploadErr *UploadError
+		if errors.As(err, &uploadErr) {
+			status = uploadErr.Status
+		}
+		return fail(status, err.Error())
+	}
+	h.remove(id)
+	return true
+}
+
+//Delete terminates an upload and discards the staged data
+func (h *TusHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	lock := h.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, _, err := h.load(id); err != nil {
+		tusNotFound(w, err)
+		return
+	}
+	h.remove(id)
+
+	log.Printf("Terminated resumable upload %s", id)
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) lock(id string) *sync.Mutex {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lock, ok := h.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		h.locks[id] = lock
+	}
+	return lock
+}
+
+func (h *TusHandler) infoPath(id string) string {
+	return filepath.Join(h.dir, id+".info")
+}
+
+func (h *TusHandler) dataPath(id string) string {
+	return filepath.Join(h.dir, id+".bin")
+}
+
+func (h *TusHandler) save(id string, upload *tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.infoPath(id), data, 0644)
+}
+
+//load returns an upload and its current offset
+func (h *TusHandler) load(id string) (*tusUpload, int64, error) {
+	if !isUploadID(id) {
+		return nil, 0, os.ErrNotExist
+	}
+	data, err := os.ReadFile(h.infoPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, 0, err
+	}
+	info, err := os.Stat(h.dataPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	return &upload, info.Size(), nil
+}
+
+func (h *TusHandler) remove(id string) {
+	os.Remove(h.infoPath(id))
+	os.Remove(h.dataPath(id))
+
+	h.mu.Lock()
+	delete(h.locks, id)
+	h.mu.Unlock()
+}
+
+//removeExpired deletes abandoned uploads
+func (h *TusHandler) removeExpired() {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".bin")
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < tusUploadExpiry {
+			continue
+		}
+		log.Printf("Removing expired resumable upload %s", id)
+		h.remove(id)
+	}
+}
+
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func tusNotFound(w http.ResponseWriter, err error) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Failed to load upload: %v", err)
+	http.Error(w, "Failed to load upload", http.StatusInternalServerError)
+}
+
+//parseTusMetadata decodes an Upload-Metadata header: comma separated pairs
+//of a key and an optional base64 encoded value
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func tusFields(metadata map[string]string) map[string][]string {
+	fields := make(map[string][]string, len(metadata))
+	for key, value := range metadata {
+		fields[key] = []string{value}
+	}
+	return fields
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//isUploadID keeps ids from the URL from escaping the staging directory
+func isUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
\ No newline at end of file
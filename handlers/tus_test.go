@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+//flakyStorage fails uploads while failing is set
+type flakyStorage struct {
+	storage.Storage
+	failing bool
+}
+
+func (s *flakyStorage) Upload(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	if s.failing {
+		return errors.New("storage unavailable")
+	}
+	return s.Storage.Upload(ctx, key, reader, size, contentType)
+}
+
+func newTestTusRouter(t *testing.T, store *models.VersionStore, s storage.Storage) http.Handler {
+	t.Helper()
+	h, err := NewTusHandler(newTestUploadHandler(t, store, s, 128), filepath.Join(t.TempDir(), "tus"))
+	if err != nil {
+		t.Fatalf("NewTusHandler: %v", err)
+	}
+	r := chi.NewRouter()
+	r.Options("/tus", h.Options)
+	r.Post("/tus", h.Create)
+	r.Head("/tus/{id}", h.Head)
+	r.Patch("/tus/{id}", h.Patch)
+	r.Delete("/tus/{id}", h.Delete)
+	return r
+}
+
+//tusMetadata encodes an Upload-Metadata header
+func tusMetadata(pairs ...string) string {
+	var fields []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fields = append(fields, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(fields, ",")
+}
+
+func tusRequest(router http.Handler, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+//createTusUpload starts an upload of a deb and returns its path
+func createTusUpload(t *testing.T, router http.Handler, length string, metadata ...string) string {
+	t.Helper()
+	metadata = append([]string{"channel", "stable", "version", "1.0.0", "version_code", "1", "artifact_type", "deb"}, metadata...)
+	rec := tusRequest(router, http.MethodPost, "/tus", "", map[string]string{
+		"Upload-Length":   length,
+		"Upload-Metadata": tusMetadata(metadata...),
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+	}
+	return strings.TrimPrefix(rec.Header().Get("Location"), "http://localhost/api/v1")
+}
+
+func patchTus(router http.Handler, target, offset, chunk string) *httptest.ResponseRecorder {
+	return tusRequest(router, http.MethodPatch, target, chunk, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": offset,
+	})
+}
+
+func TestTusUpload(t *testing.T) {
+	store := newTestStore(t)
+	router := newTestTusRouter(t, store, newTestStorage(t))
+	target := createTusUpload(t, router, "10")
+
+	steps := []struct {
+		name   string
+		offset string
+		chunk  string
+		status int
+		head   string //Upload-Offset reported by HEAD afterwards
+	}{
+		{"first chunk", "0", "01234", http.StatusNoContent, "5"},
+		{"stale offset", "0", "01234", http.StatusConflict, "5"},
+		{"offset ahead", "7", "789", http.StatusConflict, "5"},
+		{"final chunk", "5", "56789", http.StatusNoContent, ""},
+	}
+	for _, step := range steps {
+		rec := patchTus(router, target, step.offset, step.chunk)
+		if rec.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		head := tusRequest(router, http.MethodHead, target, "", nil)
+		if step.head == "" {
+			if head.Code != http.StatusNotFound {
+				t.Errorf("%s: HEAD = %d, want the finished upload removed", step.name, head.Code)
+			}
+			continue
+		}
+		if got := head.Header().Get("Upload-Offset"); got != step.head {
+			t.Errorf("%s: Upload-Offset = %s, want %s", step.name, got, step.head)
+		}
+	}
+
+	current := store.Get(models.ChannelStable)
+	if current == nil || current.Version != "1.0.0" || current.FileSize != 10 || current.SHA256 != sha256Hex("0123456789") {
+		t.Errorf("published %+v", current)
+	}
+}
+
+func TestTusUploadRetriesAfterServerError(t *testing.T) {
+	store := newTestStore(t)
+	s := &flakyStorage{Storage: newTestStorage(t), failing: true}
+	router := newTestTusRouter(t, store, s)
+	target := createTusUpload(t, router, "4")
+
+	if rec := patchTus(router, target, "0", "data"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("PATCH with failing storage = %d: %s", rec.Code, rec.Body)
+	}
+	//the staged data is kept, so the client can retry the publish
+	head := tusRequest(router, http.MethodHead, target, "", nil)
+	if head.Code != http.StatusOK || head.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("HEAD = %d, offset %s, want 200, 4", head.Code, head.Header().Get("Upload-Offset"))
+	}
+
+	s.failing = false
+	if rec := patchTus(router, target, "4", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("empty PATCH at the final offset = %d: %s", rec.Code, rec.Body)
+	}
+	if current := store.Get(models.ChannelStable); current == nil || current.Version != "1.0.0" {
+		t.Errorf("current = %+v, want 1.0.0", current)
+	}
+}
+
+func TestTusUploadRejected(t *testing.T) {
+	store := newTestStore(t)
+	router := newTestTusRouter(t, store, newTestStorage(t))
+	target := createTusUpload(t, router, "4", "sha256", strings.Repeat("0", 64))
+
+	if rec := patchTus(router, target, "0", "data"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("PATCH = %d, want %d: %s", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+	}
+	//a rejected file can't be fixed by retrying, so it is discarded
+	if head := tusRequest(router, http.MethodHead, target, "", nil); head.Code != http.StatusNotFound {
+		t.Errorf("HEAD = %d, want %d", head.Code, http.StatusNotFound)
+	}
+	if current := store.Get(models.ChannelStable); current != nil {
+		t.Errorf("rejected upload published v%s", current.Version)
+	}
+}
+
+func TestTusRequests(t *testing.T) {
+	store := newTestStore(t)
+	router := newTestTusRouter(t, store, newTestStorage(t))
+	valid := tusMetadata("channel", "stable", "version", "1.0.0", "version_code", "1", "artifact_type", "deb")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header map[string]string
+		status int
+	}{
+		{"options", http.MethodOptions, "/tus", nil, http.StatusNoContent},
+		{"missing Tus-Resumable", http.MethodPost, "/tus", map[string]string{"Tus-Resumable": "", "Upload-Length": "4", "Upload-Metadata": valid}, http.StatusPreconditionFailed},
+		{"missing Upload-Length", http.MethodPost, "/tus", map[string]string{"Upload-Metadata": valid}, http.StatusBadRequest},
+		{"above the limit", http.MethodPost, "/tus", map[string]string{"Upload-Length": "129", "Upload-Metadata": valid}, http.StatusRequestEntityTooLarge},
+		{"invalid metadata encoding", http.MethodPost, "/tus", map[string]string{"Upload-Length": "4", "Upload-Metadata": "channel !!"}, http.StatusBadRequest},
+		{"missing version", http.MethodPost, "/tus", map[string]string{"Upload-Length": "4", "Upload-Metadata": tusMetadata("channel", "stable", "version_code", "1")}, http.StatusBadRequest},
+		{"unknown channel", http.MethodPost, "/tus", map[string]string{"Upload-Length": "4", "Upload-Metadata": tusMetadata("channel", "canary", "version", "1.0.0", "version_code", "1")}, http.StatusBadRequest},
+		{"unknown upload", http.MethodHead, "/tus/" + strings.Repeat("ab", 16), nil, http.StatusNotFound},
+		{"invalid id", http.MethodHead, "/tus/..%2F..%2Fversions", nil, http.StatusNotFound},
+		{"patch without offset", http.MethodPatch, "/tus/" + strings.Repeat("ab", 16), map[string]string{"Content-Type": "application/offset+octet-stream"}, http.StatusBadRequest},
+		{"patch with wrong content type", http.MethodPatch, "/tus/" + strings.Repeat("ab", 16), map[string]string{"Upload-Offset": "0"}, http.StatusUnsupportedMediaType},
+		{"delete unknown upload", http.MethodDelete, "/tus/" + strings.Repeat("ab", 16), nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := tusRequest(router, tt.method, tt.target, "", tt.header)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	target := createTusUpload(t, router, "4")
+	if rec := tusRequest(router, http.MethodDelete, target, "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d", rec.Code)
+	}
+	if rec := patchTus(router, target, "0", "data"); rec.Code != http.StatusNotFound {
+		t.Errorf("PATCH after DELETE = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"channel c3RhYmxl", map[string]string{"channel": "stable"}, false},
+		{"channel c3RhYmxl, async,version MS4w", map[string]string{"channel": "stable", "async": "", "version": "1.0"}, false},
+		{"channel stable!", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTusMetadata(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTusMetadata(%q) err = %v, want error %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseTusMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+			continue
+		}
+		for key, value := range tt.want {
+			if got[key] != value {
+				t.Errorf("parseTusMetadata(%q)[%s] = %q, want %q", tt.header, key, got[key], value)
+			}
+		}
+	}
+}
\ No newline at end of file
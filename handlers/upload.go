@@ -1,18 +1,23 @@
 package handlers
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
+	"sono-version-service/apk"
 	"sono-version-service/database"
+	"sono-version-service/fetch"
 	"sono-version-service/models"
 	"sono-version-service/storage"
 )
@@ -21,15 +26,37 @@ type UploadHandler struct {
 	storage      storage.Storage
 	versionStore *models.VersionStore
 	db           *database.DB
-	baseURL      string
+	app          string
+	apiURL       string
+
+	//android package uploads must declare, empty accepts any
+	packageName string
+
+	//largest APK accepted, in bytes
+	maxUploadSize int64
+
+	//fetches apk_url with SSRF protection
+	fetcher *fetch.Client
+
+	//asynchronous apk_url uploads
+	queue *JobQueue
+	jobs  *JobStore
 }
 
-func NewUploadHandler(s storage.Storage, vs *models.VersionStore, db *database.DB, baseURL string) *UploadHandler {
+//apiURL is the public API root of the app, e.g. https://host/api/v1 for the
+//default app or https://host/api/v1/apps/tv for others
+func NewUploadHandler(s storage.Storage, vs *models.VersionStore, db *database.DB, app, packageName, apiURL string, maxUploadSize int64, fetcher *fetch.Client, queue *JobQueue) *UploadHandler {
 	return &UploadHandler{
-		storage:      s,
-		versionStore: vs,
-		db:           db,
-		baseURL:      baseURL,
+		storage:       s,
+		versionStore:  vs,
+		db:            db,
+		app:           app,
+		apiURL:        apiURL,
+		packageName:   packageName,
+		maxUploadSize: maxUploadSize,
+		fetcher:       fetcher,
+		queue:         queue,
+		jobs:          NewJobStore(db),
 	}
 }
 
@@ -41,114 +68,411 @@ type EnhancedUploadRequest struct {
 	ApkURL       string         `json:"apk_url"`
 	ApkBase64    string         `json:"apk_base64"`
 	GitHubToken  string         `json:"github_token"`
+
+	//optional translations of the notes keyed by locale. default_locale is
+	//the language of release_notes, or picks the entry served to clients
+	//without a matching locale if release_notes is empty.
+	LocalizedNotes map[string]string `json:"localized_release_notes"`
+	DefaultLocale  string            `json:"default_locale"`
+
+	//optional staged rollout, defaults to all devices
+	RolloutPercentage *int `json:"rollout_percentage"`
+
+	//optional device requirements
+	MinSdk int      `json:"min_sdk"`
+	ABIs   []string `json:"abis"`
+
+	//optional future publish time, the release stays hidden until then
+	PublishAt *time.Time `json:"publish_at"`
+
+	//fetch and publish apk_url in the background and answer 202 with a job
+	Async bool `json:"async"`
+
+	//optional checksum and size of the APK as built, verified before storing
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+
+	//marks the APK as a split, which is added to the release of the same
+	//version instead of replacing it
+	ABI     string `json:"abi"`
+	Density string `json:"density"`
+
+	//optional type of the file, defaults to apk. platform is required for
+	//archives (zip, tar.gz) which don't imply one.
+	ArtifactType string `json:"artifact_type"`
+	Platform     string `json:"platform"`
+
+	//aliases of apk_url and apk_base64 for files that aren't APKs
+	ArtifactURL    string `json:"artifact_url"`
+	ArtifactBase64 string `json:"artifact_base64"`
+}
+
+var (
+	splitABIs      = map[string]bool{"armeabi-v7a": true, "arm64-v8a": true, "x86": true, "x86_64": true, "arm64": true}
+	splitDensities = map[string]bool{"ldpi": true, "mdpi": true, "tvdpi": true, "hdpi": true, "xhdpi": true, "xxhdpi": true, "xxxhdpi": true}
+)
+
+func (r *EnhancedUploadRequest) isSplit() bool {
+	return r.ABI != "" || r.Density != ""
+}
+
+func (r *EnhancedUploadRequest) artifactType() (models.ArtifactType, error) {
+	return models.ResolveArtifactType(r.ArtifactType, r.Platform)
+}
+
+//validNotes checks the locales of the notes and that there are notes for
+//clients none of the translations fit
+func (r *EnhancedUploadRequest) validNotes() bool {
+	if len(r.LocalizedNotes) == 0 {
+		return r.DefaultLocale == "" || validLocale(r.DefaultLocale)
+	}
+	if !validLocale(r.DefaultLocale) {
+		return false
+	}
+	for locale := range r.LocalizedNotes {
+		if !validLocale(locale) {
+			return false
+		}
+	}
+	return r.ReleaseNotes != "" || r.localizedNotes()[r.DefaultLocale] != ""
+}
+
+func validLocale(tag string) bool {
+	_, ok := models.CanonicalLocale(tag)
+	return ok
+}
+
+//localizedNotes returns the translations keyed by canonical locale, with
+//release_notes as the default locale's entry
+func (r *EnhancedUploadRequest) localizedNotes() map[string]string {
+	if len(r.LocalizedNotes) == 0 {
+		return nil
+	}
+	notes := make(map[string]string, len(r.LocalizedNotes)+1)
+	for tag, text := range r.LocalizedNotes {
+		if locale, ok := models.CanonicalLocale(tag); ok && text != "" {
+			notes[locale] = text
+		}
+	}
+	if locale, ok := models.CanonicalLocale(r.DefaultLocale); ok && r.ReleaseNotes != "" {
+		notes[locale] = r.ReleaseNotes
+	}
+	return notes
+}
+
+//normalize folds the artifact_* aliases into the apk_* fields
+func (r *EnhancedUploadRequest) normalize() {
+	if r.ApkURL == "" {
+		r.ApkURL = r.ArtifactURL
+	}
+	if r.ApkBase64 == "" {
+		r.ApkBase64 = r.ArtifactBase64
+	}
+	r.ArtifactURL, r.ArtifactBase64 = "", ""
 }
 
 func (r *EnhancedUploadRequest) Validate() bool {
-	hasURL := r.ApkURL != ""
-	hasBase64 := r.ApkBase64 != ""
-	
-	return r.Channel.IsValid() &&
+	validRollout := r.RolloutPercentage == nil ||
+		(*r.RolloutPercentage >= 0 && *r.RolloutPercentage <= 100)
+	_, err := hex.DecodeString(r.SHA256)
+	validChecksum := r.SHA256 == "" || (len(r.SHA256) == 64 && err == nil)
+
+	return r.Channel.IsWellFormed() &&
 		r.Version != "" &&
 		r.VersionCode > 0 &&
-		(hasURL || hasBase64)
+		r.validNotes() &&
+		validRollout &&
+		validChecksum &&
+		r.Size >= 0 &&
+		(r.ABI == "" || splitABIs[r.ABI]) &&
+		(r.Density == "" || splitDensities[r.Density])
+}
+
+//UploadError is a failed upload with the HTTP status reported to the caller
+type UploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	return e.Message
 }
 
+//Handle accepts an APK either as JSON (apk_base64 or apk_url) or as a
+//streamed multipart/form-data upload
 func (h *UploadHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		h.handleMultipart(w, r)
+		return
+	}
+
+	//base64 inflates the APK by a third, leave some room for the other fields
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize/3*4+1<<20)
+
 	var req EnhancedUploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf("File exceeds the maximum upload size of %d bytes", h.maxUploadSize), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	req.normalize()
 
-	if !req.Validate() {
-		http.Error(w, "Invalid request: missing required fields or APK source", http.StatusBadRequest)
+	if !req.Validate() || (req.ApkURL == "" && req.ApkBase64 == "") {
+		http.Error(w, "Invalid request: missing required fields or file source", http.StatusBadRequest)
 		return
 	}
 
-	var apkData []byte
+	if !h.checkTarget(w, &req) {
+		return
+	}
+
+	if req.Async || r.Header.Get("Prefer") == "respond-async" {
+		if req.ApkURL == "" || req.ApkBase64 != "" {
+			http.Error(w, "Asynchronous uploads require apk_url or artifact_url", http.StatusBadRequest)
+			return
+		}
+		h.enqueue(w, r, &req)
+		return
+	}
+
+	var art *artifact
 	var err error
+	source := req.ApkURL
 
 	//try to get apk data from base64
 	if req.ApkBase64 != "" {
-		log.Printf("Processing base64 encoded APK for %s v%s", req.Channel, req.Version)
-		apkData, err = base64.StdEncoding.DecodeString(req.ApkBase64)
+		source = "base64"
+		log.Printf("Processing base64 encoded file for %s v%s", req.Channel, req.Version)
+		art, err = spool(base64.NewDecoder(base64.StdEncoding, strings.NewReader(req.ApkBase64)), h.maxUploadSize)
+		req.ApkBase64 = ""
 		if err != nil {
-			log.Printf("Failed to decode base64 APK: %v", err)
-			h.logUpload(r, string(req.Channel), req.Version, "failed", "Failed to decode base64 APK", "base64")
-			http.Error(w, "Failed to decode base64 APK data", http.StatusBadRequest)
+			log.Printf("Failed to decode base64 file: %v", err)
+			if errors.Is(err, errTooLarge) {
+				h.fail(w, r, &req, source, &UploadError{http.StatusRequestEntityTooLarge, err.Error()})
+				return
+			}
+			h.fail(w, r, &req, source, &UploadError{http.StatusBadRequest, "Failed to decode base64 file data"})
 			return
 		}
-		log.Printf("Successfully decoded APK (%d bytes)", len(apkData))
-	} else if req.ApkURL != "" {
+		log.Printf("Successfully decoded file (%d bytes)", art.size)
+	} else {
 		//fall back to URL
-		log.Printf("Downloading APK from: %s", req.ApkURL)
-		apkData, err = h.downloadAPK(req.ApkURL, req.GitHubToken)
+		log.Printf("Downloading file from: %s", req.ApkURL)
+		art, err = h.downloadAPK(r.Context(), req.ApkURL, req.GitHubToken, nil)
 		if err != nil {
-			log.Printf("Failed to download APK: %v", err)
-			h.logUpload(r, string(req.Channel), req.Version, "failed", fmt.Sprintf("Failed to download APK: %v", err), req.ApkURL)
-			http.Error(w, fmt.Sprintf("Failed to download APK from URL: %v", err), http.StatusBadGateway)
+			log.Printf("Failed to download file: %v", err)
+			h.fail(w, r, &req, source, downloadError(err))
 			return
 		}
 	}
+	defer art.Close()
 
-	//compute hash
-	hash := sha256.Sum256(apkData)
-	sha256Hash := hex.EncodeToString(hash[:])
+	h.respond(w, r, &req, art, source)
+}
 
-	fileName := fmt.Sprintf("%s/sono-%s-v%s.apk", req.Channel, req.Channel, req.Version)
+//handleMultipart streams the apk (or artifact) file part to a temporary file
+//while hashing it, so the file is never held in memory. Metadata is sent as
+//form fields with the names of the JSON upload, abis may be repeated or comma
+//separated.
+func (h *UploadHandler) handleMultipart(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize+1<<20)
 
-	//upload to storage
-	log.Printf("Uploading APK: %s (%d bytes)", fileName, len(apkData))
-	if err := h.storage.Upload(r.Context(), fileName, bytes.NewReader(apkData), int64(len(apkData))); err != nil {
-		log.Printf("Failed to store APK: %v", err)
-		h.logUpload(r, string(req.Channel), req.Version, "failed", "Failed to store APK", req.ApkURL)
-		http.Error(w, "Failed to store APK", http.StatusInternalServerError)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Invalid multipart body", http.StatusBadRequest)
 		return
 	}
 
-	//create version info
-	versionInfo := &models.VersionInfo{
-		Channel:      req.Channel,
-		Version:      req.Version,
-		VersionCode:  req.VersionCode,
-		DownloadURL:  fmt.Sprintf("%s/api/v1/download/%s", h.baseURL, req.Channel),
-		FileSize:     int64(len(apkData)),
-		SHA256:       sha256Hash,
-		ReleaseNotes: req.ReleaseNotes,
-		PublishedAt:  time.Now().UTC(),
-		FileName:     fileName,
+	fields := make(map[string][]string)
+	var art *artifact
+	defer func() {
+		if art != nil {
+			art.Close()
+		}
+	}()
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.multipartError(w, err)
+			return
+		}
+
+		if part.FormName() == "apk" || part.FormName() == "artifact" {
+			if art != nil {
+				http.Error(w, "Only one file part is allowed", http.StatusBadRequest)
+				return
+			}
+			art, err = spool(part, h.maxUploadSize)
+			if err != nil {
+				log.Printf("Failed to receive file: %v", err)
+				h.multipartError(w, err)
+				return
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 1<<20))
+		if err != nil {
+			h.multipartError(w, err)
+			return
+		}
+		fields[part.FormName()] = append(fields[part.FormName()], string(value))
 	}
 
-	//save to version store
-	if err := h.versionStore.Set(versionInfo); err != nil {
-		log.Printf("Failed to save version info: %v", err)
-		h.logUpload(r, string(req.Channel), req.Version, "failed", "Failed to save metadata", req.ApkURL)
-		http.Error(w, "Failed to save version metadata", http.StatusInternalServerError)
+	req, err := parseUploadForm(fields)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !req.Validate() || art == nil {
+		http.Error(w, "Invalid request: missing required fields or apk file part", http.StatusBadRequest)
 		return
 	}
 
-	//save to db
-	if h.db != nil {
-		h.db.InsertRelease(r.Context(), &database.Release{
-			Channel:      string(req.Channel),
-			Version:      req.Version,
-			VersionCode:  req.VersionCode,
-			FileName:     fileName,
-			FileSize:     int64(len(apkData)),
-			SHA256:       sha256Hash,
-			ReleaseNotes: req.ReleaseNotes,
-			PublishedAt:  time.Now().UTC(),
-		})
+	if !h.checkTarget(w, req) {
+		return
 	}
 
-	source := req.ApkURL
-	if source == "" {
-		source = "base64"
+	log.Printf("Received multipart upload for %s v%s (%d bytes)", req.Channel, req.Version, art.size)
+	h.respond(w, r, req, art, "multipart")
+}
+
+func (h *UploadHandler) multipartError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.Is(err, errTooLarge) || errors.As(err, &maxErr) {
+		http.Error(w, fmt.Sprintf("File exceeds the maximum upload size of %d bytes", h.maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Failed to read multipart body", http.StatusBadRequest)
+}
+
+//parseUploadForm builds an upload request from multipart form fields
+func parseUploadForm(fields map[string][]string) (*EnhancedUploadRequest, error) {
+	get := func(name string) string {
+		if values := fields[name]; len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+		return ""
+	}
+	atoi := func(name string) (int, error) {
+		value := get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer", name)
+		}
+		return n, nil
+	}
+
+	req := &EnhancedUploadRequest{
+		Channel:      models.Channel(get("channel")),
+		Version:      get("version"),
+		ReleaseNotes: get("release_notes"),
+	}
+
+	var err error
+	if req.VersionCode, err = atoi("version_code"); err != nil {
+		return nil, err
+	}
+	if req.MinSdk, err = atoi("min_sdk"); err != nil {
+		return nil, err
+	}
+	if value := get("size"); value != "" {
+		if req.Size, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("size must be an integer")
+		}
+	}
+	req.SHA256 = get("sha256")
+	req.ABI = get("abi")
+	req.Density = get("density")
+	req.ArtifactType = get("artifact_type")
+	req.Platform = get("platform")
+	req.DefaultLocale = get("default_locale")
+	if value := get("localized_release_notes"); value != "" {
+		if err := json.Unmarshal([]byte(value), &req.LocalizedNotes); err != nil {
+			return nil, fmt.Errorf("localized_release_notes must be a JSON object of locale to notes")
+		}
+	}
+	if get("rollout_percentage") != "" {
+		percentage, err := atoi("rollout_percentage")
+		if err != nil {
+			return nil, err
+		}
+		req.RolloutPercentage = &percentage
+	}
+	if value := get("publish_at"); value != "" {
+		publishAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("publish_at must be an RFC 3339 timestamp")
+		}
+		req.PublishAt = &publishAt
+	}
+	for _, value := range fields["abis"] {
+		for _, abi := range strings.Split(value, ",") {
+			if abi = strings.TrimSpace(abi); abi != "" {
+				req.ABIs = append(req.ABIs, abi)
+			}
+		}
+	}
+	return req, nil
+}
+
+//checkTarget rejects uploads to unknown channels and schedules of versions
+//that are already live, before any APK data is received
+func (h *UploadHandler) checkTarget(w http.ResponseWriter, req *EnhancedUploadRequest) bool {
+	if !h.versionStore.HasChannel(req.Channel) {
+		invalidChannel(w, h.versionStore, "channel")
+		return false
+	}
+	if _, err := req.artifactType(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return false
+	}
+	if req.isScheduled() && h.versionStore.GetVersion(req.Channel, req.Version) != nil {
+		http.Error(w, fmt.Sprintf("v%s is already published on %s and can't be scheduled", req.Version, req.Channel), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+func (r *EnhancedUploadRequest) isScheduled() bool {
+	return r.PublishAt != nil && r.PublishAt.After(time.Now())
+}
+
+//respond publishes a received APK and writes the result
+func (h *UploadHandler) respond(w http.ResponseWriter, r *http.Request, req *EnhancedUploadRequest, art *artifact, source string) {
+	versionInfo, err := h.publish(r.Context(), req, art, source)
+	if err != nil {
+		var uploadErr *UploadError
+		if errors.As(err, &uploadErr) {
+			http.Error(w, uploadErr.Message, uploadErr.Status)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	h.logUpload(r, string(req.Channel), req.Version, "success", "Upload completed", source)
-	log.Printf("Successfully uploaded %s v%s", req.Channel, req.Version)
 
 	w.Header().Set("Content-Type", "application/json")
+	if versionInfo.PublishAt != nil {
+		publishAt := versionInfo.PublishAt.Format(time.RFC3339)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"scheduled": true,
+			"message":   fmt.Sprintf("Scheduled %s v%s for %s", req.Channel, req.Version, publishAt),
+			"version":   versionInfo,
+		})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"message": fmt.Sprintf("Successfully uploaded %s v%s", req.Channel, req.Version),
@@ -156,23 +480,241 @@ func (h *UploadHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *UploadHandler) downloadAPK(url, token string) ([]byte, error) {
-	client := &http.Client{
-		Timeout: 5 * time.Minute,
+//fail logs a failed upload and reports it to the caller
+func (h *UploadHandler) fail(w http.ResponseWriter, r *http.Request, req *EnhancedUploadRequest, source string, err *UploadError) {
+	h.logUpload(r.Context(), string(req.Channel), req.Version, "failed", err.Message, source)
+	http.Error(w, err.Message, err.Status)
+}
+
+//publish stores a received APK and publishes or schedules the release. It is
+//the common path of every upload mode.
+func (h *UploadHandler) publish(ctx context.Context, req *EnhancedUploadRequest, art *artifact, source string) (*models.VersionInfo, error) {
+	failed := func(status int, message string) error {
+		h.logUpload(ctx, string(req.Channel), req.Version, "failed", message, source)
+		return &UploadError{Status: status, Message: message}
 	}
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+
+	//catches truncated downloads and stale mirrors before anything is stored
+	if err := checkChecksum(req, art); err != nil {
+		log.Printf("Rejected %s v%s: %v", req.Channel, req.Version, err)
+		h.logUpload(ctx, string(req.Channel), req.Version, "checksum_mismatch", err.Error(), source)
+		return nil, &UploadError{Status: http.StatusUnprocessableEntity, Message: err.Error()}
+	}
+
+	artifactType, _ := req.artifactType()
+	artifact := models.Artifact{
+		FileSize:     art.size,
+		SHA256:       art.sha256,
+		ABI:          req.ABI,
+		Density:      req.Density,
+		ArtifactType: artifactType,
+	}
+
+	var manifest apk.Manifest
+	settings := h.versionStore.ChannelSettings(req.Channel)
+	if artifactType == models.ArtifactTypeAPK {
+		//the manifest is the truth, the request metadata has to match it
+		m, err := apk.ReadManifest(art.file, art.size)
+		if err != nil {
+			log.Printf("Rejected %s v%s: %v", req.Channel, req.Version, err)
+			return nil, h.reject(ctx, req, source, fmt.Sprintf("Invalid APK: %v", err))
+		}
+		if err := h.checkManifest(req, m); err != nil {
+			log.Printf("Rejected %s v%s: %v", req.Channel, req.Version, err)
+			return nil, h.reject(ctx, req, source, err.Error())
+		}
+		manifest = *m
+
+		signature, err := apk.VerifySignature(art.file, art.size)
+		if err != nil {
+			log.Printf("Rejected %s v%s: %v", req.Channel, req.Version, err)
+			return nil, h.reject(ctx, req, source, fmt.Sprintf("Invalid APK signature: %v", err))
+		}
+		for _, signer := range signature.Signers {
+			if !settings.AllowsSigner(signer.Fingerprint) {
+				log.Printf("Rejected %s v%s: signer %s is not allowed", req.Channel, req.Version, signer.Fingerprint)
+				return nil, h.reject(ctx, req, source, fmt.Sprintf("APK is signed by %s (%s), which is not an allowed signer of %s", signer.Fingerprint, signer.Certificate.Subject, req.Channel))
+			}
+		}
+		artifact.MinSdk = manifest.MinSdk
+		artifact.SignerSHA256 = signature.Signers[0].Fingerprint
+	} else if len(settings.AllowedSigners) > 0 {
+		//only APK signatures can be verified
+		return nil, h.reject(ctx, req, source, fmt.Sprintf("%s pins allowed signers and only accepts APKs", req.Channel))
+	}
+
+	//splits and files for other platforms join the release of the same
+	//version, which has to be built from the same version code
+	existing := h.versionStore.GetScheduled(req.Channel, req.Version)
+	if existing == nil {
+		existing = h.versionStore.GetVersion(req.Channel, req.Version)
+	}
+	joins := existing != nil && (!artifact.IsUniversal() || artifact.ArtifactType != existing.ArtifactType)
+	if existing != nil && existing.VersionCode != req.VersionCode {
+		if joins {
+			return nil, h.reject(ctx, req, source, fmt.Sprintf("v%s already exists with version_code %d, all of its files must match it", req.Version, existing.VersionCode))
+		}
+		existing = nil
+	}
+	artifact.FileName = artifactKey(h.app, req.Channel, req.Version, &artifact)
+
+	//upload to storage
+	log.Printf("Uploading %s: %s (%d bytes)", artifact.Extension, artifact.FileName, art.size)
+	if err := h.storage.Upload(ctx, artifact.FileName, art.file, art.size, artifact.ContentType); err != nil {
+		log.Printf("Failed to store artifact: %v", err)
+		return nil, failed(http.StatusInternalServerError, "Failed to store artifact")
+	}
+
+	if joins {
+		versionInfo, err := h.versionStore.AddArtifact(req.Channel, req.Version, req.VersionCode, artifact)
+		if err != nil {
+			log.Printf("Failed to save version info: %v", err)
+			return nil, failed(http.StatusInternalServerError, "Failed to save version metadata")
+		}
+		if versionInfo.PublishAt == nil {
+			recordRelease(ctx, h.db, versionInfo)
+		}
+
+		name := path.Base(artifact.FileName)
+		h.logUpload(ctx, string(req.Channel), req.Version, "success", fmt.Sprintf("Added %s", name), source)
+		log.Printf("Added %s to %s v%s", name, req.Channel, req.Version)
+		return versionInfo, nil
+	}
+
+	//create version info
+	defaultLocale, _ := models.CanonicalLocale(req.DefaultLocale)
+	localizedNotes := req.localizedNotes()
+	releaseNotes := req.ReleaseNotes
+	if releaseNotes == "" {
+		releaseNotes = localizedNotes[defaultLocale]
+	}
+	versionInfo := &models.VersionInfo{
+		Channel:        req.Channel,
+		Version:        req.Version,
+		VersionCode:    req.VersionCode,
+		DownloadURL:    downloadURL(h.apiURL, req.Channel),
+		ReleaseNotes:   releaseNotes,
+		LocalizedNotes: localizedNotes,
+		DefaultLocale:  defaultLocale,
+		PublishedAt:    time.Now().UTC(),
+		ABIs:           req.ABIs,
+		PackageName:    manifest.Package,
+		TargetSdk:      manifest.TargetSdk,
+	}
+	versionInfo.SetArtifact(artifact)
+	//re-uploading the main file keeps the other files of the release
+	if existing != nil {
+		for _, other := range existing.Files() {
+			if !artifact.Replaces(&other) {
+				versionInfo.SetArtifact(other)
+			}
+		}
+	}
+	if req.RolloutPercentage != nil && *req.RolloutPercentage < 100 {
+		versionInfo.RolloutPercentage = req.RolloutPercentage
+	}
+
+	if req.isScheduled() {
+		publishAt := req.PublishAt.UTC()
+		versionInfo.PublishAt = &publishAt
+		if err := h.versionStore.Schedule(versionInfo); err != nil {
+			log.Printf("Failed to save version info: %v", err)
+			return nil, failed(http.StatusInternalServerError, "Failed to save version metadata")
+		}
+
+		h.logUpload(ctx, string(req.Channel), req.Version, "scheduled", fmt.Sprintf("Scheduled for %s", publishAt.Format(time.RFC3339)), source)
+		log.Printf("Scheduled %s v%s for %s", req.Channel, req.Version, publishAt.Format(time.RFC3339))
+		return versionInfo, nil
+	}
+
+	//save to version store
+	if err := h.versionStore.Set(versionInfo); err != nil {
+		log.Printf("Failed to save version info: %v", err)
+		return nil, failed(http.StatusInternalServerError, "Failed to save version metadata")
+	}
+
+	//save to db
+	recordRelease(ctx, h.db, versionInfo)
+
+	pruneReleases(ctx, h.storage, h.versionStore, req.Channel)
+
+	h.logUpload(ctx, string(req.Channel), req.Version, "success", "Upload completed", source)
+	log.Printf("Successfully uploaded %s v%s", req.Channel, req.Version)
+	return versionInfo, nil
+}
+
+//checkManifest compares the metadata of an upload against the manifest of
+//its APK
+func (h *UploadHandler) checkManifest(req *EnhancedUploadRequest, manifest *apk.Manifest) error {
+	if h.packageName != "" && manifest.Package != h.packageName {
+		return fmt.Errorf("APK package %s does not match %s, the package of %s", manifest.Package, h.packageName, h.app)
 	}
+	if manifest.VersionCode != req.VersionCode {
+		return fmt.Errorf("APK versionCode %d does not match version_code %d", manifest.VersionCode, req.VersionCode)
+	}
+	if manifest.VersionNameIsRef {
+		log.Printf("versionName of %s v%s is a resource reference, skipping the check", req.Channel, req.Version)
+	} else if manifest.VersionName != req.Version {
+		return fmt.Errorf("APK versionName %q does not match version %q", manifest.VersionName, req.Version)
+	}
+	if req.MinSdk != 0 && req.MinSdk != manifest.MinSdk {
+		return fmt.Errorf("APK minSdkVersion %d does not match min_sdk %d", manifest.MinSdk, req.MinSdk)
+	}
+	return nil
+}
+
+//checkChecksum compares the received file against the size and sha256 the
+//caller expects, if it sent them
+func checkChecksum(req *EnhancedUploadRequest, art *artifact) error {
+	if req.Size > 0 && art.size != req.Size {
+		return fmt.Errorf("File is %d bytes, expected size %d", art.size, req.Size)
+	}
+	if req.SHA256 != "" && !strings.EqualFold(art.sha256, req.SHA256) {
+		return fmt.Errorf("File SHA256 is %s, expected %s", art.sha256, strings.ToLower(req.SHA256))
+	}
+	return nil
+}
+
+//reject records an upload whose APK failed validation
+func (h *UploadHandler) reject(ctx context.Context, req *EnhancedUploadRequest, source, message string) error {
+	h.logUpload(ctx, string(req.Channel), req.Version, "rejected", message, source)
+	return &UploadError{Status: http.StatusUnprocessableEntity, Message: message}
+}
+
+//artifactName is the file name of an artifact of a release, e.g.
+//sono-stable-v1.2.0-arm64-v8a.apk or sono-stable-v1.2.0-linux.AppImage
+func artifactName(app string, channel models.Channel, version string, artifact *models.Artifact) string {
+	name := fmt.Sprintf("%s-%s-v%s", app, channel, version)
+	if artifact.Platform != models.PlatformAndroid {
+		name += "-" + artifact.Platform
+	}
+	if split := artifact.Split(); split != "" {
+		name += "-" + split
+	}
+	return name + artifact.Extension
+}
 
-	//add token if provided
+//artifactKey is the storage key of an artifact of a release, relative to
+//the app's storage prefix
+func artifactKey(app string, channel models.Channel, version string, artifact *models.Artifact) string {
+	return fmt.Sprintf("%s/%s", channel, artifactName(app, channel, version, artifact))
+}
+
+func downloadURL(apiURL string, channel models.Channel) string {
+	return fmt.Sprintf("%s/download/%s", apiURL, channel)
+}
+
+//downloadAPK fetches apk_url to a temporary file. onProgress, if set, is
+//called with the bytes received so far and the total size, 0 if unknown.
+func (h *UploadHandler) downloadAPK(ctx context.Context, url, token string, onProgress func(received, total int64)) (*artifact, error) {
+	header := http.Header{}
+	//add token if provided, it isn't forwarded on redirects to other hosts
 	if token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+		header.Set("Authorization", fmt.Sprintf("token %s", token))
 	}
-	req.Header.Set("Accept", "application/octet-stream")
+	header.Set("Accept", "application/octet-stream")
 
-	resp, err := client.Do(req)
+	resp, err := h.fetcher.Get(ctx, url, header)
 	if err != nil {
 		return nil, err
 	}
@@ -181,12 +723,45 @@ func (h *UploadHandler) downloadAPK(url, token string) ([]byte, error) {
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("download returned status %d", resp.StatusCode)
 	}
+	if resp.ContentLength > h.maxUploadSize {
+		return nil, errTooLarge
+	}
+
+	var body io.Reader = resp.Body
+	if onProgress != nil {
+		body = &progressReader{r: resp.Body, total: max(resp.ContentLength, 0), onProgress: onProgress}
+	}
+	return spool(body, h.maxUploadSize)
+}
+
+//downloadError maps a failed apk_url download to the status reported to the
+//caller
+func downloadError(err error) *UploadError {
+	status := http.StatusBadGateway
+	if errors.Is(err, errTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	} else if errors.Is(err, fetch.ErrBlocked) {
+		status = http.StatusBadRequest
+	}
+	return &UploadError{status, fmt.Sprintf("Failed to download file from URL: %v", err)}
+}
+
+type progressReader struct {
+	r          io.Reader
+	received   int64
+	total      int64
+	onProgress func(received, total int64)
+}
 
-	return io.ReadAll(resp.Body)
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.received += int64(n)
+	p.onProgress(p.received, p.total)
+	return n, err
 }
 
-func (h *UploadHandler) logUpload(r *http.Request, channel, version, status, message, sourceURL string) {
+func (h *UploadHandler) logUpload(ctx context.Context, channel, version, status, message, sourceURL string) {
 	if h.db != nil {
-		h.db.LogUpload(r.Context(), channel, version, status, message, sourceURL)
+		h.db.LogUpload(ctx, channel, version, status, message, sourceURL)
 	}
 }
\ No newline at end of file
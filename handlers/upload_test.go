@@ -0,0 +1,635 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sono-version-service/apk"
+	"sono-version-service/apk/apktest"
+	"sono-version-service/fetch"
+	"sono-version-service/middleware"
+	"sono-version-service/models"
+	"sono-version-service/storage"
+)
+
+func newTestUploadHandler(t *testing.T, store *models.VersionStore, s storage.Storage, maxUploadSize int64) *UploadHandler {
+	t.Helper()
+	fetcher := fetch.NewClient(fetch.Config{AllowPrivateIPs: true}, time.Minute)
+	return NewUploadHandler(s, store, nil, "sono", "", "http://localhost/api/v1", maxUploadSize, fetcher, NewJobQueue(1, 4))
+}
+
+//formPart is a field of a multipart upload, a file part if fileName is set
+type formPart struct {
+	name, fileName, value string
+}
+
+func multipartBody(t *testing.T, parts ...formPart) (io.Reader, http.Header) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var w io.Writer
+		var err error
+		if part.fileName != "" {
+			w, err = mw.CreateFormFile(part.name, part.fileName)
+		} else {
+			w, err = mw.CreateFormField(part.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, part.value)
+	}
+	mw.Close()
+	return &body, http.Header{"Content-Type": {mw.FormDataContentType()}}
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMultipartUpload(t *testing.T) {
+	deb := strings.Repeat("d", 100)
+	fields := []formPart{
+		{name: "channel", value: "stable"},
+		{name: "version", value: "1.0.0"},
+		{name: "version_code", value: "1"},
+		{name: "release_notes", value: "Streamed"},
+		{name: "artifact_type", value: "deb"},
+	}
+
+	tests := []struct {
+		name   string
+		parts  []formPart
+		status int
+	}{
+		{"streamed file", append(slices.Clone(fields), formPart{"artifact", "app.deb", deb}), http.StatusOK},
+		{"file before the fields", append([]formPart{{"apk", "app.deb", deb}}, fields...), http.StatusOK},
+		{"file at the limit", append(slices.Clone(fields), formPart{"artifact", "app.deb", strings.Repeat("d", 128)}), http.StatusOK},
+		{"file above the limit", append(slices.Clone(fields), formPart{"artifact", "app.deb", strings.Repeat("d", 129)}), http.StatusRequestEntityTooLarge},
+		{"missing file part", fields, http.StatusBadRequest},
+		{"two file parts", append(slices.Clone(fields), formPart{"artifact", "a.deb", deb}, formPart{"apk", "b.deb", deb}), http.StatusBadRequest},
+		{"invalid version_code", append([]formPart{{name: "version_code", value: "one"}}, formPart{"artifact", "app.deb", deb}), http.StatusBadRequest},
+		{"missing version", []formPart{{name: "channel", value: "stable"}, {name: "version_code", value: "1"}, {name: "artifact_type", value: "deb"}, {"artifact", "app.deb", deb}}, http.StatusBadRequest},
+		{"unknown channel", append([]formPart{{name: "channel", value: "canary"}}, append(slices.Clone(fields[1:]), formPart{"artifact", "app.deb", deb})...), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			s := newTestStorage(t)
+			h := newTestUploadHandler(t, store, s, 128)
+
+			body, header := multipartBody(t, tt.parts...)
+			rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", body, header)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			current := store.Get(models.ChannelStable)
+			if tt.status != http.StatusOK {
+				if current != nil {
+					t.Errorf("a failed upload published v%s", current.Version)
+				}
+				return
+			}
+			if current == nil || current.Version != "1.0.0" || current.ReleaseNotes != "Streamed" {
+				t.Fatalf("current = %+v, want 1.0.0", current)
+			}
+			reader, size, err := s.Download(context.Background(), current.FileName)
+			if err != nil {
+				t.Fatalf("Download(%s): %v", current.FileName, err)
+			}
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+			if size != current.FileSize || current.SHA256 != sha256Hex(string(data)) {
+				t.Errorf("stored %d bytes with sha256 %s, release says %d bytes, %s", size, sha256Hex(string(data)), current.FileSize, current.SHA256)
+			}
+		})
+	}
+}
+
+func TestBase64UploadLimit(t *testing.T) {
+	tests := []struct {
+		size   int
+		status int
+	}{
+		{128, http.StatusOK},
+		{129, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		store := newTestStore(t)
+		h := newTestUploadHandler(t, store, newTestStorage(t), 128)
+
+		file := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("d"), tt.size))
+		body := `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "artifact_base64": "` + file + `"}`
+		rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", strings.NewReader(body), nil)
+		if rec.Code != tt.status {
+			t.Errorf("%d byte upload = %d, want %d: %s", tt.size, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestParseUploadForm(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string][]string
+		check   func(req *EnhancedUploadRequest) bool
+		wantErr bool
+	}{
+		{
+			name:   "basic fields",
+			fields: map[string][]string{"channel": {" beta "}, "version": {"2.0.0"}, "version_code": {"20"}},
+			check: func(req *EnhancedUploadRequest) bool {
+				return req.Channel == models.ChannelBeta && req.Version == "2.0.0" && req.VersionCode == 20 && req.RolloutPercentage == nil
+			},
+		},
+		{
+			name:   "repeated and comma separated abis",
+			fields: map[string][]string{"abis": {"arm64-v8a, x86_64", "armeabi-v7a", " , "}},
+			check: func(req *EnhancedUploadRequest) bool {
+				return slices.Equal(req.ABIs, []string{"arm64-v8a", "x86_64", "armeabi-v7a"})
+			},
+		},
+		{
+			name:   "rollout of zero",
+			fields: map[string][]string{"rollout_percentage": {"0"}},
+			check: func(req *EnhancedUploadRequest) bool {
+				return req.RolloutPercentage != nil && *req.RolloutPercentage == 0
+			},
+		},
+		{
+			name:   "publish time and size",
+			fields: map[string][]string{"publish_at": {"2030-01-01T00:00:00Z"}, "size": {"1024"}},
+			check: func(req *EnhancedUploadRequest) bool {
+				return req.PublishAt.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) && req.Size == 1024
+			},
+		},
+		{
+			name:   "localized notes",
+			fields: map[string][]string{"localized_release_notes": {`{"de": "Neu"}`}, "default_locale": {"en"}},
+			check: func(req *EnhancedUploadRequest) bool {
+				return req.LocalizedNotes["de"] == "Neu" && req.DefaultLocale == "en"
+			},
+		},
+		{name: "invalid version_code", fields: map[string][]string{"version_code": {"1.0"}}, wantErr: true},
+		{name: "invalid min_sdk", fields: map[string][]string{"min_sdk": {"x"}}, wantErr: true},
+		{name: "invalid size", fields: map[string][]string{"size": {"big"}}, wantErr: true},
+		{name: "invalid rollout", fields: map[string][]string{"rollout_percentage": {"half"}}, wantErr: true},
+		{name: "invalid publish_at", fields: map[string][]string{"publish_at": {"tomorrow"}}, wantErr: true},
+		{name: "invalid localized notes", fields: map[string][]string{"localized_release_notes": {"Neu"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseUploadForm(tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(req) {
+				t.Errorf("parsed %+v", req)
+			}
+		})
+	}
+}
+
+func TestSpool(t *testing.T) {
+	tests := []struct {
+		data    string
+		limit   int64
+		wantErr error
+	}{
+		{"", 10, nil},
+		{"0123456789", 10, nil},
+		{"0123456789a", 10, errTooLarge},
+	}
+	for _, tt := range tests {
+		art, err := spool(strings.NewReader(tt.data), tt.limit)
+		if err != tt.wantErr {
+			t.Errorf("spool(%d bytes, %d) = %v, want %v", len(tt.data), tt.limit, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		data, _ := io.ReadAll(art.file)
+		if string(data) != tt.data || art.size != int64(len(tt.data)) || art.sha256 != sha256Hex(tt.data) {
+			t.Errorf("spool(%d bytes) = %d bytes, sha256 %s", len(tt.data), art.size, art.sha256)
+		}
+		art.Close()
+	}
+}
+
+func TestCheckManifest(t *testing.T) {
+	manifest := &apk.Manifest{Package: "com.sono.app", VersionCode: 42, VersionName: "1.2.0", MinSdk: 24}
+	req := EnhancedUploadRequest{Version: "1.2.0", VersionCode: 42}
+
+	tests := []struct {
+		name        string
+		packageName string //configured for the app
+		modify      func(req *EnhancedUploadRequest, m *apk.Manifest)
+		wantErr     bool
+	}{
+		{"matches", "com.sono.app", nil, false},
+		{"any package", "", func(req *EnhancedUploadRequest, m *apk.Manifest) { m.Package = "com.other" }, false},
+		{"min_sdk matches", "", func(req *EnhancedUploadRequest, m *apk.Manifest) { req.MinSdk = 24 }, false},
+		{"versionName reference", "", func(req *EnhancedUploadRequest, m *apk.Manifest) { m.VersionName, m.VersionNameIsRef = "", true }, false},
+		{"other package", "com.sono.tv", nil, true},
+		{"versionCode differs", "", func(req *EnhancedUploadRequest, m *apk.Manifest) { req.VersionCode = 41 }, true},
+		{"versionName differs", "", func(req *EnhancedUploadRequest, m *apk.Manifest) { req.Version = "1.2.1" }, true},
+		{"min_sdk differs", "", func(req *EnhancedUploadRequest, m *apk.Manifest) { req.MinSdk = 21 }, true},
+	}
+	for _, tt := range tests {
+		req, m := req, *manifest
+		if tt.modify != nil {
+			tt.modify(&req, &m)
+		}
+		h := &UploadHandler{app: "sono", packageName: tt.packageName}
+		if err := h.checkManifest(&req, &m); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkManifest = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUploadRejectsManifestMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want string //part of the error message
+	}{
+		{"versionCode", apktest.New(apktest.Manifest{Package: "com.sono.app", VersionCode: 2, VersionName: "1.0.0"}), "versionCode 2"},
+		{"versionName", apktest.New(apktest.Manifest{Package: "com.sono.app", VersionCode: 1, VersionName: "1.0.1"}), `versionName "1.0.1"`},
+		{"package", apktest.New(apktest.Manifest{Package: "com.evil", VersionCode: 1, VersionName: "1.0.0"}), "package com.evil"},
+		{"not an APK", []byte("plain text"), "Invalid APK"},
+		{"no manifest", apktest.Zip(apktest.File{Name: "classes.dex", Data: []byte("dex")}), "AndroidManifest.xml not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			h := newTestUploadHandler(t, store, newTestStorage(t), 1<<20)
+			h.packageName = "com.sono.app"
+
+			body, header := multipartBody(t,
+				formPart{name: "channel", value: "stable"},
+				formPart{name: "version", value: "1.0.0"},
+				formPart{name: "version_code", value: "1"},
+				formPart{"apk", "app.apk", string(tt.file)},
+			)
+			rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", body, header)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("error %q doesn't mention %q", rec.Body, tt.want)
+			}
+			if current := store.Get(models.ChannelStable); current != nil {
+				t.Errorf("rejected upload published v%s", current.Version)
+			}
+		})
+	}
+}
+
+func TestUploadSignedAPK(t *testing.T) {
+	key, other := apktest.ECKey("release"), apktest.ECKey("debug")
+	manifest := apktest.Manifest{Package: "com.sono.app", VersionCode: 1, VersionName: "1.0.0", MinSdk: 24, TargetSdk: 34}
+
+	tests := []struct {
+		name    string
+		pinned  []string //allowed signers of the channel
+		file    string
+		artType string
+		status  int
+		want    string //part of the error message
+	}{
+		{"any signer", nil, string(apktest.Sign(apktest.New(manifest), key, 2)), "", http.StatusOK, ""},
+		{"pinned signer", []string{key.Fingerprint()}, string(apktest.SignV1(manifest, key)), "", http.StatusOK, ""},
+		{"other signer", []string{key.Fingerprint()}, string(apktest.Sign(apktest.New(manifest), other, 3)), "", http.StatusUnprocessableEntity, "not an allowed signer"},
+		{"unsigned", nil, string(apktest.New(manifest)), "", http.StatusUnprocessableEntity, "Invalid APK signature"},
+		{"not an APK in a pinned channel", []string{key.Fingerprint()}, "deb", "deb", http.StatusUnprocessableEntity, "only accepts APKs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			if _, err := store.UpdateChannel(models.ChannelStable, func(c *models.ChannelSettings) { c.AllowedSigners = tt.pinned }); err != nil {
+				t.Fatal(err)
+			}
+			h := newTestUploadHandler(t, store, newTestStorage(t), 1<<20)
+
+			body, header := multipartBody(t,
+				formPart{name: "channel", value: "stable"},
+				formPart{name: "version", value: "1.0.0"},
+				formPart{name: "version_code", value: "1"},
+				formPart{name: "artifact_type", value: tt.artType},
+				formPart{"apk", "app", tt.file},
+			)
+			rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", body, header)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			current := store.Get(models.ChannelStable)
+			if tt.status != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), tt.want) {
+					t.Errorf("error %q doesn't mention %q", rec.Body, tt.want)
+				}
+				if current != nil {
+					t.Errorf("rejected upload published v%s", current.Version)
+				}
+				return
+			}
+			if current == nil || current.SignerSHA256 != key.Fingerprint() || current.PackageName != "com.sono.app" || current.TargetSdk != 34 || current.MinSdk != 24 {
+				t.Errorf("published %+v", current)
+			}
+		})
+	}
+}
+
+func TestDownloadAPK(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 64))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		cfg           fetch.Config
+		url           string
+		maxUploadSize int64
+		status        int //of the reported error, 0 for none
+	}{
+		{"allowed", fetch.Config{AllowPrivateIPs: true}, server.URL, 128, 0},
+		{"too large", fetch.Config{AllowPrivateIPs: true}, server.URL, 32, http.StatusRequestEntityTooLarge},
+		{"loopback address", fetch.Config{}, server.URL, 128, http.StatusBadRequest},
+		{"not in the allowlist", fetch.Config{AllowedHosts: []string{"github.com"}, AllowPrivateIPs: true}, server.URL, 128, http.StatusBadRequest},
+		{"https only", fetch.Config{HTTPSOnly: true, AllowPrivateIPs: true}, server.URL, 128, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		h := NewUploadHandler(newTestStorage(t), newTestStore(t), nil, "sono", "", "http://localhost/api/v1", tt.maxUploadSize, fetch.NewClient(tt.cfg, time.Minute), NewJobQueue(1, 4))
+		art, err := h.downloadAPK(context.Background(), tt.url, "token", nil)
+		if err == nil {
+			art.Close()
+			if tt.status != 0 {
+				t.Errorf("%s: download succeeded, want status %d", tt.name, tt.status)
+			}
+			continue
+		}
+		if got := downloadError(err).Status; got != tt.status {
+			t.Errorf("%s: status = %d, want %d: %v", tt.name, got, tt.status, err)
+		}
+	}
+}
+
+func TestCheckChecksum(t *testing.T) {
+	art := &artifact{size: 4, sha256: sha256Hex("data")}
+
+	tests := []struct {
+		name    string
+		sha256  string
+		size    int64
+		wantErr bool
+	}{
+		{"nothing expected", "", 0, false},
+		{"matching size", "", 4, false},
+		{"matching sha256", sha256Hex("data"), 0, false},
+		{"uppercase sha256", strings.ToUpper(sha256Hex("data")), 4, false},
+		{"truncated", "", 5, true},
+		{"other file", sha256Hex("atad"), 4, true},
+	}
+	for _, tt := range tests {
+		req := &EnhancedUploadRequest{SHA256: tt.sha256, Size: tt.size}
+		if err := checkChecksum(req, art); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkChecksum = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUploadChecksum(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "debian package")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		fields string //extra JSON fields
+		status int
+	}{
+		{"no checksum", ``, http.StatusOK},
+		{"matching", `, "sha256": "` + sha256Hex("debian package") + `", "size": 14`, http.StatusOK},
+		{"size mismatch", `, "size": 15`, http.StatusUnprocessableEntity},
+		{"sha256 mismatch", `, "sha256": "` + sha256Hex("stale package") + `"`, http.StatusUnprocessableEntity},
+		{"malformed sha256", `, "sha256": "abc"`, http.StatusBadRequest},
+		{"negative size", `, "size": -1`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			h := newTestUploadHandler(t, store, newTestStorage(t), 1<<20)
+
+			body := `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "apk_url": "` + server.URL + `"` + tt.fields + `}`
+			rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", strings.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if current := store.Get(models.ChannelStable); (current != nil) != (tt.status == http.StatusOK) {
+				t.Errorf("current = %+v after status %d", current, rec.Code)
+			}
+		})
+	}
+}
+
+func TestUploadSplit(t *testing.T) {
+	key := apktest.ECKey("release")
+	store := newTestStore(t)
+	h := newTestUploadHandler(t, store, newTestStorage(t), 1<<20)
+
+	steps := []struct {
+		name        string
+		versionCode string
+		abi         string
+		status      int
+		files       int //of the release afterwards
+	}{
+		{"universal", "1", "", http.StatusOK, 1},
+		{"abi split joins the release", "1", "arm64-v8a", http.StatusOK, 2},
+		{"split is replaced", "1", "arm64-v8a", http.StatusOK, 2},
+		{"other version code", "2", "x86_64", http.StatusUnprocessableEntity, 2},
+		{"unknown abi", "1", "mips", http.StatusBadRequest, 2},
+	}
+	for _, step := range steps {
+		versionCode, _ := strconv.Atoi(step.versionCode)
+		body, header := multipartBody(t,
+			formPart{name: "channel", value: "stable"},
+			formPart{name: "version", value: "1.0.0"},
+			formPart{name: "version_code", value: step.versionCode},
+			formPart{name: "abi", value: step.abi},
+			formPart{"apk", "app.apk", string(signedTestAPK(key, "1.0.0", versionCode))},
+		)
+		rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", body, header)
+		if rec.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		current := store.Get(models.ChannelStable)
+		if current == nil || len(current.Files()) != step.files || current.VersionCode != 1 {
+			t.Fatalf("%s: current = %+v, want %d files", step.name, current, step.files)
+		}
+		if current.FileName != "stable/sono-stable-v1.0.0.apk" {
+			t.Errorf("%s: primary file = %s, want the universal APK", step.name, current.FileName)
+		}
+	}
+}
+
+func TestArtifactName(t *testing.T) {
+	deb, _ := models.ResolveArtifactType("deb", "")
+	zip, _ := models.ResolveArtifactType("zip", "windows")
+
+	tests := []struct {
+		artifact models.Artifact
+		want     string
+	}{
+		{models.Artifact{ArtifactType: models.ArtifactTypeAPK}, "sono-stable-v1.0.0.apk"},
+		{models.Artifact{ABI: "arm64-v8a", Density: "xxhdpi", ArtifactType: models.ArtifactTypeAPK}, "sono-stable-v1.0.0-arm64-v8a-xxhdpi.apk"},
+		{models.Artifact{Density: "xxhdpi", ArtifactType: models.ArtifactTypeAPK}, "sono-stable-v1.0.0-xxhdpi.apk"},
+		{models.Artifact{ArtifactType: deb}, "sono-stable-v1.0.0-linux.deb"},
+		{models.Artifact{ABI: "arm64", ArtifactType: deb}, "sono-stable-v1.0.0-linux-arm64.deb"},
+		{models.Artifact{ArtifactType: zip}, "sono-stable-v1.0.0-windows.zip"},
+	}
+	for _, tt := range tests {
+		if got := artifactName("sono", models.ChannelStable, "1.0.0", &tt.artifact); got != tt.want {
+			t.Errorf("artifactName(%+v) = %s, want %s", tt.artifact, got, tt.want)
+		}
+	}
+}
+
+//contentTypeStorage records the content type of every upload
+type contentTypeStorage struct {
+	storage.Storage
+	contentTypes map[string]string
+}
+
+func (s *contentTypeStorage) Upload(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	s.contentTypes[key] = contentType
+	return s.Storage.Upload(ctx, key, reader, size, contentType)
+}
+
+func TestUploadArtifactTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		fields      string //JSON fields naming the type
+		status      int
+		key         string
+		contentType string
+		download    string //query selecting the file
+		fileName    string
+	}{
+		{"deb", `"artifact_type": "deb"`, http.StatusOK, "stable/sono-stable-v1.0.0-linux.deb", "application/vnd.debian.binary-package", "?platform=linux", "sono-stable-v1.0.0-linux.deb"},
+		{"archive", `"artifact_type": "zip", "platform": "windows"`, http.StatusOK, "stable/sono-stable-v1.0.0-windows.zip", "application/zip", "?platform=windows", "sono-stable-v1.0.0-windows.zip"},
+		{"archive without platform", `"artifact_type": "tar.gz"`, http.StatusBadRequest, "", "", "", ""},
+		{"platform of another type", `"artifact_type": "msi", "platform": "linux"`, http.StatusBadRequest, "", "", "", ""},
+		{"unknown type", `"artifact_type": "ipa"`, http.StatusBadRequest, "", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			s := &contentTypeStorage{Storage: newTestStorage(t), contentTypes: map[string]string{}}
+			h := newTestUploadHandler(t, store, s, 1<<20)
+
+			//artifact_base64 is the alias of apk_base64
+			body := `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_base64": "ZGF0YQ==", ` + tt.fields + `}`
+			rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", strings.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := s.contentTypes[tt.key]; got != tt.contentType {
+				t.Errorf("stored %v, want %s as %s", s.contentTypes, tt.key, tt.contentType)
+			}
+
+			download := NewDownloadHandler(s, store, nil, "sono", middleware.NewVerifier("", middleware.SignatureConfig{}))
+			rec = serve(http.MethodGet, "/download/{channel}", download.Handle, "/download/stable"+tt.download, nil, nil)
+			if rec.Code != http.StatusOK || rec.Body.String() != "data" {
+				t.Fatalf("download = %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %s, want %s", got, tt.contentType)
+			}
+			if got, want := rec.Header().Get("Content-Disposition"), `attachment; filename="`+tt.fileName+`"`; got != want {
+				t.Errorf("Content-Disposition = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestUploadRequestNormalize(t *testing.T) {
+	tests := []struct {
+		req       EnhancedUploadRequest
+		url, data string
+	}{
+		{EnhancedUploadRequest{ArtifactURL: "https://example.com/app.deb"}, "https://example.com/app.deb", ""},
+		{EnhancedUploadRequest{ArtifactBase64: "ZGF0YQ=="}, "", "ZGF0YQ=="},
+		{EnhancedUploadRequest{ApkURL: "https://example.com/app.apk", ArtifactURL: "https://example.com/app.deb"}, "https://example.com/app.apk", ""},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		req.normalize()
+		if req.ApkURL != tt.url || req.ApkBase64 != tt.data || req.ArtifactURL != "" || req.ArtifactBase64 != "" {
+			t.Errorf("normalize(%+v) = %+v", tt.req, req)
+		}
+	}
+}
+func TestUploadLocalizedNotes(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string //extra JSON fields
+		status int
+		notes  string
+		locale string
+		want   map[string]string
+	}{
+		{"no translations", `, "release_notes": "Bug fixes"`, http.StatusOK, "Bug fixes", "", nil},
+		{"release notes in the default locale", `, "release_notes": "Bug fixes", "default_locale": "en", "localized_release_notes": {"de_de": "Fehlerbehebungen", "PT-br": "Correções"}`,
+			http.StatusOK, "Bug fixes", "en", map[string]string{"en": "Bug fixes", "de-DE": "Fehlerbehebungen", "pt-BR": "Correções"}},
+		{"default locale from the translations", `, "default_locale": "de", "localized_release_notes": {"de": "Fehlerbehebungen", "en": "Bug fixes"}`,
+			http.StatusOK, "Fehlerbehebungen", "de", map[string]string{"de": "Fehlerbehebungen", "en": "Bug fixes"}},
+		{"release notes replace the default translation", `, "release_notes": "Bug fixes", "default_locale": "en", "localized_release_notes": {"en": "Old notes"}`,
+			http.StatusOK, "Bug fixes", "en", map[string]string{"en": "Bug fixes"}},
+		{"no notes for the default locale", `, "default_locale": "en", "localized_release_notes": {"de": "Fehlerbehebungen"}`, http.StatusBadRequest, "", "", nil},
+		{"no default locale", `, "release_notes": "Bug fixes", "localized_release_notes": {"de": "Fehlerbehebungen"}`, http.StatusBadRequest, "", "", nil},
+		{"invalid translation locale", `, "release_notes": "Bug fixes", "default_locale": "en", "localized_release_notes": {"de.DE": "Fehlerbehebungen"}`, http.StatusBadRequest, "", "", nil},
+		{"invalid default locale", `, "release_notes": "Bug fixes", "default_locale": "e"`, http.StatusBadRequest, "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			h := newTestUploadHandler(t, store, newTestStorage(t), 1<<20)
+
+			body := `{"channel": "stable", "version": "1.0.0", "version_code": 1, "artifact_type": "deb", "apk_base64": "ZGF0YQ=="` + tt.fields + `}`
+			rec := serve(http.MethodPost, "/upload", h.Handle, "/upload", strings.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			current := store.Get(models.ChannelStable)
+			if current.ReleaseNotes != tt.notes || current.DefaultLocale != tt.locale || !reflect.DeepEqual(current.LocalizedNotes, tt.want) {
+				t.Errorf("published notes %q in %q, translations %v, want %q in %q, %v", current.ReleaseNotes, current.DefaultLocale, current.LocalizedNotes, tt.notes, tt.locale, tt.want)
+			}
+		})
+	}
+}
\ No newline at end of file
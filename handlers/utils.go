@@ -0,0 +1,12 @@
+package handlers
+
+import "net/http"
+
+//actorFor names who performed an admin action in the release events: the
+//performed_by of the request, or the client IP if it left that out
+func actorFor(r *http.Request, performedBy string) string {
+	if performedBy != "" {
+		return performedBy
+	}
+	return getClientIP(r)
+}
\ No newline at end of file
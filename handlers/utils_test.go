@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActorFor(t *testing.T) {
+	tests := []struct {
+		performedBy  string
+		forwardedFor string
+		want         string
+	}{
+		{"ci-bot", "", "ci-bot"},
+		{"ci-bot", "203.0.113.7", "ci-bot"},
+		{"", "203.0.113.7", "203.0.113.7"},
+		{"", "203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"", "", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", nil)
+		if tt.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if got := actorFor(r, tt.performedBy); got != tt.want {
+			t.Errorf("actorFor(%q) with X-Forwarded-For %q = %q, want %q", tt.performedBy, tt.forwardedFor, got, tt.want)
+		}
+	}
+}
\ No newline at end of file
@@ -3,9 +3,13 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 
+	"sono-version-service/markdown"
 	"sono-version-service/models"
 )
 
@@ -17,16 +21,45 @@ func NewVersionHandler(vs *models.VersionStore) *VersionHandler {
 	return &VersionHandler{versionStore: vs}
 }
 
+//VersionResponse is the release a client should run plus the channel policy
+//it has to enforce
+type VersionResponse struct {
+	*models.VersionInfo
+	MinSupportedVersionCode int `json:"min_supported_version_code"`
+
+	//locale of release_notes, empty if the release has no translations and
+	//no default locale
+	ReleaseNotesLocale string `json:"release_notes_locale,omitempty"`
+
+	//notes are Markdown. Without ?format= the rendered forms are included,
+	//with it release_notes holds only the requested form.
+	ReleaseNotesFormat string `json:"release_notes_format"`
+	ReleaseNotesHTML   string `json:"release_notes_html,omitempty"`
+	ReleaseNotesText   string `json:"release_notes_text,omitempty"`
+
+	//set when the client's version_code belongs to a yanked release
+	YankNotice *YankNotice `json:"yank_notice,omitempty"`
+}
+
 func (h *VersionHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	channelStr := chi.URLParam(r, "channel")
 	channel := models.Channel(channelStr)
 
-	if !channel.IsValid() {
-		http.Error(w, "Invalid channel. Must be: stable, beta, or nightly", http.StatusBadRequest)
+	if !h.versionStore.HasChannel(channel) {
+		invalidChannel(w, h.versionStore, "channel")
 		return
 	}
 
-	versionInfo := h.versionStore.Get(channel)
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "markdown", "html", "text":
+	default:
+		http.Error(w, "Invalid format. Must be one of: markdown, html, text", http.StatusBadRequest)
+		return
+	}
+
+	installID := getInstallID(r)
+	versionInfo := h.versionStore.Resolve(channel, installID)
 	if versionInfo == nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -38,6 +71,75 @@ func (h *VersionHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//only serve the notes in the client's language, on a copy so the stored
+	//release keeps every translation
+	notes, locale := versionInfo.NotesFor(preferredLocales(r))
+	localized := *versionInfo
+	localized.ReleaseNotes, localized.LocalizedNotes = notes, nil
+
+	settings := h.versionStore.ChannelSettings(channel)
+	resp := VersionResponse{
+		VersionInfo:             &localized,
+		MinSupportedVersionCode: settings.MinSupportedVersionCode,
+		ReleaseNotesLocale:      locale,
+		ReleaseNotesFormat:      format,
+	}
+	switch format {
+	case "":
+		resp.ReleaseNotesFormat = "markdown"
+		resp.ReleaseNotesHTML = markdown.HTML(notes)
+		resp.ReleaseNotesText = markdown.Text(notes)
+	case "html":
+		localized.ReleaseNotes = markdown.HTML(notes)
+	case "text":
+		localized.ReleaseNotes = markdown.Text(notes)
+	}
+
+	//clients may report their build so they learn when it has been yanked
+	if versionCode, err := queryInt(r, "version_code", 0); err == nil && versionCode > 0 {
+		resp.YankNotice = yankNoticeFor(h.versionStore, channel, versionCode, installID)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(versionInfo)
+	w.Header().Set("Vary", "Accept-Language")
+	if locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+//preferredLocales lists the locales a client asks for, most preferred first:
+//the lang query parameter, then Accept-Language by quality
+func preferredLocales(r *http.Request) []string {
+	var locales []string
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		locales = append(locales, lang)
+	}
+
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var accepted []weighted
+	for _, item := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if tag == "" || tag == "*" || quality <= 0 {
+			continue
+		}
+		accepted = append(accepted, weighted{tag, quality})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+	for _, a := range accepted {
+		locales = append(locales, a.tag)
+	}
+	return locales
 }
\ No newline at end of file
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"sono-version-service/models"
+)
+
+func TestVersionPolicy(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	store.SetMandatory(models.ChannelStable, "1.0.0", true)
+	store.UpdateChannel(models.ChannelStable, func(s *models.ChannelSettings) { s.MinSupportedVersionCode = 1 })
+	h := NewVersionHandler(store)
+
+	tests := []struct {
+		target     string
+		status     int
+		mandatory  bool
+		minVersion int
+	}{
+		{"/version/stable", http.StatusOK, true, 1},
+		{"/version/beta", http.StatusNotFound, false, 0},
+		{"/version/canary", http.StatusBadRequest, false, 0},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodGet, "/version/{channel}", h.Handle, tt.target, nil, nil)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.target, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Mandatory               bool `json:"mandatory"`
+			MinSupportedVersionCode int  `json:"min_supported_version_code"`
+		}
+		decodeJSON(t, rec, &resp)
+		if resp.Mandatory != tt.mandatory || resp.MinSupportedVersionCode != tt.minVersion {
+			t.Errorf("GET %s = mandatory %v, min %d, want %v, %d", tt.target, resp.Mandatory, resp.MinSupportedVersionCode, tt.mandatory, tt.minVersion)
+		}
+	}
+}
+
+func TestVersionYankNotice(t *testing.T) {
+	store := newTestStore(t)
+	publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	publishTestRelease(t, store, models.ChannelStable, "1.1.0", 2)
+	store.Yank(models.ChannelStable, "1.1.0", models.YankInfo{Reason: "crash"})
+	h := NewVersionHandler(store)
+
+	tests := []struct {
+		query  string
+		notice bool
+	}{
+		{"", false},
+		{"?version_code=1", false},
+		{"?version_code=2", true},
+		{"?version_code=x", false},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodGet, "/version/{channel}", h.Handle, "/version/stable"+tt.query, nil, nil)
+		var resp VersionResponse
+		decodeJSON(t, rec, &resp)
+		if resp.Version != "1.0.0" {
+			t.Errorf("GET %s returned v%s, want 1.0.0", tt.query, resp.Version)
+		}
+		if (resp.YankNotice != nil) != tt.notice {
+			t.Errorf("GET %s: yank_notice = %+v, want %v", tt.query, resp.YankNotice, tt.notice)
+		}
+	}
+}
+func TestPreferredLocales(t *testing.T) {
+	tests := []struct {
+		query          string
+		acceptLanguage string
+		want           []string
+	}{
+		{"", "", nil},
+		{"", "de", []string{"de"}},
+		{"", "de-AT, de;q=0.8, en;q=0.5", []string{"de-AT", "de", "en"}},
+		{"", "en;q=0.5, fr;q=0.9, de", []string{"de", "fr", "en"}},
+		{"", "en;q=0.5, fr;q=0.5", []string{"en", "fr"}},
+		{"", "*, de;q=0.7", []string{"de"}},
+		{"", "fr;q=0, de;q=0.7", []string{"de"}},
+		{"", "fr;q=high, de;q=0.7", []string{"de"}},
+		{"", " , de", []string{"de"}},
+		{"?lang=pt-BR", "de, en", []string{"pt-BR", "de", "en"}},
+		{"?lang=pt-BR", "", []string{"pt-BR"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/version/stable"+tt.query, nil)
+		if tt.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if got := preferredLocales(req); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("preferredLocales(%s, %q) = %q, want %q", tt.query, tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestVersionLocalizedNotes(t *testing.T) {
+	store := newTestStore(t)
+	info := publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	info.DefaultLocale = "en"
+	info.LocalizedNotes = map[string]string{"en": "Bug fixes", "de": "Fehlerbehebungen", "pt-BR": "Correções"}
+	info.ReleaseNotes = "Bug fixes"
+	if err := store.Set(info); err != nil {
+		t.Fatal(err)
+	}
+	h := NewVersionHandler(store)
+
+	tests := []struct {
+		query          string
+		acceptLanguage string
+		notes          string
+		locale         string
+	}{
+		{"", "", "Bug fixes", "en"},
+		{"", "de-DE,de;q=0.9", "Fehlerbehebungen", "de"},
+		{"", "fr, pt;q=0.8", "Correções", "pt-BR"},
+		{"", "fr", "Bug fixes", "en"},
+		{"?lang=de", "pt-BR", "Fehlerbehebungen", "de"},
+		{"?lang=fr", "pt-BR", "Correções", "pt-BR"},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.acceptLanguage != "" {
+			header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		rec := serve(http.MethodGet, "/version/{channel}", h.Handle, "/version/stable"+tt.query, nil, header)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		if rec.Header().Get("Vary") != "Accept-Language" {
+			t.Errorf("GET %s (%s): Vary = %q", tt.query, tt.acceptLanguage, rec.Header().Get("Vary"))
+		}
+		if got := rec.Header().Get("Content-Language"); got != tt.locale {
+			t.Errorf("GET %s (%s): Content-Language = %q, want %q", tt.query, tt.acceptLanguage, got, tt.locale)
+		}
+		var resp map[string]any
+		decodeJSON(t, rec, &resp)
+		if resp["release_notes"] != tt.notes || resp["release_notes_locale"] != tt.locale {
+			t.Errorf("GET %s (%s): notes %q in %q, want %q in %q", tt.query, tt.acceptLanguage, resp["release_notes"], resp["release_notes_locale"], tt.notes, tt.locale)
+		}
+		//clients only get the negotiated notes
+		if notes, ok := resp["localized_release_notes"]; ok && notes != nil {
+			t.Errorf("GET %s (%s): localized_release_notes = %v", tt.query, tt.acceptLanguage, notes)
+		}
+	}
+
+	//releases without translations don't claim a language
+	publishTestRelease(t, store, models.ChannelBeta, "1.1.0", 2)
+	rec := serve(http.MethodGet, "/version/{channel}", h.Handle, "/version/beta", nil, http.Header{"Accept-Language": {"de"}})
+	if got := rec.Header().Get("Content-Language"); got != "" {
+		t.Errorf("Content-Language = %q for a release without translations", got)
+	}
+}
+func TestVersionNotesFormat(t *testing.T) {
+	store := newTestStore(t)
+	info := publishTestRelease(t, store, models.ChannelStable, "1.0.0", 1)
+	info.ReleaseNotes = "**Faster** sync <script>alert(1)</script>"
+	if err := store.Set(info); err != nil {
+		t.Fatal(err)
+	}
+	h := NewVersionHandler(store)
+
+	const (
+		html = "<p><strong>Faster</strong> sync &lt;script&gt;alert(1)&lt;/script&gt;</p>"
+		text = "Faster sync <script>alert(1)</script>"
+	)
+	tests := []struct {
+		query  string
+		status int
+		format string
+		notes  string
+		html   string //release_notes_html
+		text   string //release_notes_text
+	}{
+		{"", http.StatusOK, "markdown", info.ReleaseNotes, html, text},
+		{"?format=markdown", http.StatusOK, "markdown", info.ReleaseNotes, "", ""},
+		{"?format=html", http.StatusOK, "html", html, "", ""},
+		{"?format=text", http.StatusOK, "text", text, "", ""},
+		{"?format=rtf", http.StatusBadRequest, "", "", "", ""},
+	}
+	for _, tt := range tests {
+		rec := serve(http.MethodGet, "/version/{channel}", h.Handle, "/version/stable"+tt.query, nil, nil)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp VersionResponse
+		decodeJSON(t, rec, &resp)
+		if resp.ReleaseNotesFormat != tt.format || resp.ReleaseNotes != tt.notes {
+			t.Errorf("GET %s: %s notes %q, want %s %q", tt.query, resp.ReleaseNotesFormat, resp.ReleaseNotes, tt.format, tt.notes)
+		}
+		if resp.ReleaseNotesHTML != tt.html || resp.ReleaseNotesText != tt.text {
+			t.Errorf("GET %s: release_notes_html %q, release_notes_text %q, want %q, %q", tt.query, resp.ReleaseNotesHTML, resp.ReleaseNotesText, tt.html, tt.text)
+		}
+	}
+
+	//the stored release keeps its Markdown
+	if current := store.Get(models.ChannelStable); current.ReleaseNotes != info.ReleaseNotes {
+		t.Errorf("stored notes changed to %q", current.ReleaseNotes)
+	}
+}
\ No newline at end of file
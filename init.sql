@@ -1,6 +1,7 @@
 -- Version releases table
 CREATE TABLE IF NOT EXISTS releases (
     id SERIAL PRIMARY KEY,
+    app VARCHAR(50) NOT NULL DEFAULT 'sono',
     channel VARCHAR(20) NOT NULL,
     version VARCHAR(50) NOT NULL,
     version_code INTEGER NOT NULL,
@@ -9,13 +10,21 @@ CREATE TABLE IF NOT EXISTS releases (
     sha256 VARCHAR(64),
     release_notes TEXT,
     published_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    yanked BOOLEAN NOT NULL DEFAULT FALSE,
+    yank_reason TEXT,
+    yanked_at TIMESTAMP WITH TIME ZONE,
+    signer_sha256 VARCHAR(64),
+    artifacts JSONB,
+    localized_release_notes JSONB,
+    default_locale VARCHAR(35),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    UNIQUE(channel, version)
+    UNIQUE(app, channel, version)
 );
 
 -- Download tracking table
 CREATE TABLE IF NOT EXISTS downloads (
     id SERIAL PRIMARY KEY,
+    app VARCHAR(50) NOT NULL DEFAULT 'sono',
     release_id INTEGER REFERENCES releases(id),
     channel VARCHAR(20) NOT NULL,
     version VARCHAR(50) NOT NULL,
@@ -27,6 +36,7 @@ CREATE TABLE IF NOT EXISTS downloads (
 -- Upload logs table
 CREATE TABLE IF NOT EXISTS upload_logs (
     id SERIAL PRIMARY KEY,
+    app VARCHAR(50) NOT NULL DEFAULT 'sono',
     channel VARCHAR(20) NOT NULL,
     version VARCHAR(50) NOT NULL,
     status VARCHAR(20) NOT NULL,
@@ -35,6 +45,52 @@ CREATE TABLE IF NOT EXISTS upload_logs (
     uploaded_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Release events table (rollbacks, promotions, ...)
+CREATE TABLE IF NOT EXISTS release_events (
+    id SERIAL PRIMARY KEY,
+    app VARCHAR(50) NOT NULL DEFAULT 'sono',
+    channel VARCHAR(20) NOT NULL,
+    version VARCHAR(50) NOT NULL,
+    event VARCHAR(20) NOT NULL,
+    previous_version VARCHAR(50),
+    source_channel VARCHAR(20),
+    actor VARCHAR(255),
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Asynchronous upload jobs
+CREATE TABLE IF NOT EXISTS upload_jobs (
+    id VARCHAR(32) PRIMARY KEY,
+    app VARCHAR(50) NOT NULL DEFAULT 'sono',
+    status VARCHAR(20) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    version VARCHAR(50) NOT NULL,
+    source_url TEXT,
+    bytes_received BIGINT NOT NULL DEFAULT 0,
+    total_bytes BIGINT NOT NULL DEFAULT 0,
+    result JSONB,
+    error TEXT,
+    owner VARCHAR(100),
+    heartbeat_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Idempotency-Key records for upload retries
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    app VARCHAR(50) NOT NULL DEFAULT 'sono',
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64),
+    completed BOOLEAN NOT NULL DEFAULT FALSE,
+    status_code INTEGER,
+    headers JSONB,
+    body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (app, key)
+);
+
 -- API request logs table
 CREATE TABLE IF NOT EXISTS request_logs (
     id SERIAL PRIMARY KEY,
@@ -48,9 +104,12 @@ CREATE TABLE IF NOT EXISTS request_logs (
 );
 
 -- Indexes for common queries
-CREATE INDEX IF NOT EXISTS idx_releases_channel ON releases(channel);
-CREATE INDEX IF NOT EXISTS idx_downloads_channel ON downloads(channel);
+CREATE INDEX IF NOT EXISTS idx_releases_app_channel ON releases(app, channel);
+CREATE INDEX IF NOT EXISTS idx_downloads_app_channel ON downloads(app, channel);
 CREATE INDEX IF NOT EXISTS idx_downloads_date ON downloads(downloaded_at);
+CREATE INDEX IF NOT EXISTS idx_release_events_app_channel ON release_events(app, channel);
+CREATE INDEX IF NOT EXISTS idx_upload_jobs_status ON upload_jobs(app, status);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
 CREATE INDEX IF NOT EXISTS idx_request_logs_endpoint ON request_logs(endpoint);
 CREATE INDEX IF NOT EXISTS idx_request_logs_date ON request_logs(created_at);
 
@@ -61,9 +120,10 @@ SELECT
     version,
     COUNT(*) as download_count,
     MIN(downloaded_at) as first_download,
-    MAX(downloaded_at) as last_download
+    MAX(downloaded_at) as last_download,
+    app
 FROM downloads
-GROUP BY channel, version
+GROUP BY app, channel, version
 ORDER BY last_download DESC;
 
 -- View for daily download counts
@@ -71,7 +131,8 @@ CREATE OR REPLACE VIEW daily_downloads AS
 SELECT
     DATE(downloaded_at) as date,
     channel,
-    COUNT(*) as downloads
+    COUNT(*) as downloads,
+    app
 FROM downloads
-GROUP BY DATE(downloaded_at), channel
+GROUP BY DATE(downloaded_at), app, channel
 ORDER BY date DESC;
\ No newline at end of file
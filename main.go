@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -15,7 +16,6 @@ import (
 	"sono-version-service/database"
 	"sono-version-service/handlers"
 	"sono-version-service/middleware"
-	"sono-version-service/models"
 	"sono-version-service/storage"
 )
 
@@ -38,11 +38,6 @@ func main() {
 		defer db.Close()
 	}
 
-	versionStore, err := models.NewVersionStore(cfg.VersionsFile)
-	if err != nil {
-		log.Fatalf("Failed to initialize version store: %v", err)
-	}
-
 	var store storage.Storage
 
 	switch cfg.StorageType {
@@ -94,10 +89,6 @@ func main() {
 		log.Fatalf("Invalid storage type: %s", cfg.StorageType)
 	}
 
-	uploadHandler := handlers.NewUploadHandler(store, versionStore, db, cfg.BaseURL)
-	versionHandler := handlers.NewVersionHandler(versionStore)
-	downloadHandler := handlers.NewDownloadHandler(store, versionStore, db)
-
 	r := chi.NewRouter()
 
 	r.Use(chimiddleware.Logger)
@@ -115,29 +106,46 @@ func main() {
 		})
 	})
 
-	r.Get("/api/v1/version/{channel}", versionHandler.Handle)
-	r.Get("/api/v1/download/{channel}", downloadHandler.Handle)
+	//shared by all apps, so the number of concurrent background downloads
+	//doesn't grow with the number of apps
+	queue := handlers.NewJobQueue(cfg.UploadWorkers, cfg.UploadQueueSize)
 
-	r.Group(func(r chi.Router) {
-		r.Use(middleware.WebhookAuth(cfg.WebhookSecret))
-		r.Post("/api/v1/upload", uploadHandler.Handle)
-	})
+	var appInfos []map[string]string
+	for _, appCfg := range cfg.Apps {
+		a, err := newApp(cfg, appCfg, store, db, queue)
+		if err != nil {
+			log.Fatalf("Failed to initialize app %s: %v", appCfg.Name, err)
+		}
 
-	if db != nil {
-		r.Get("/api/v1/stats", func(w http.ResponseWriter, r *http.Request) {
-			stats, err := db.GetDownloadStats(r.Context())
-			if err != nil {
-				http.Error(w, "Failed to fetch stats", http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(stats)
+		go a.scheduler.Run(context.Background(), cfg.SchedulerInterval)
+		go a.poller.Run(context.Background(), cfg.GitHubPollInterval)
+		go a.uploadHandler.RunJobHeartbeat(context.Background())
+
+		a.mount(r, "/api/v1/apps/"+appCfg.Name)
+		if appCfg.Name == cfg.DefaultApp {
+			//the unscoped routes predate multi-app support and stay as aliases
+			a.mount(r, "/api/v1")
+		}
+
+		appInfos = append(appInfos, map[string]string{
+			"name":         appCfg.Name,
+			"display_name": appCfg.DisplayName,
+			"api_url":      a.apiURL,
 		})
 	}
 
+	r.Get("/api/v1/apps", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"default": cfg.DefaultApp,
+			"apps":    appInfos,
+		})
+	})
+
 	log.Printf("Starting server on :%s", cfg.Port)
 	log.Printf("Base URL: %s", cfg.BaseURL)
 	log.Printf("Storage type: %s", cfg.StorageType)
+	log.Printf("Apps: %d (default: %s)", len(cfg.Apps), cfg.DefaultApp)
 	log.Printf("Database connected: %v", db != nil)
 
 	if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
@@ -148,10 +156,12 @@ func main() {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Webhook-Secret")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Webhook-Secret, X-Hub-Signature-256, X-Signature-Timestamp, X-Install-ID, Idempotency-Key, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Idempotent-Replayed, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Length, Upload-Offset")
 
-		if r.Method == "OPTIONS" {
+		//answer CORS preflights here, plain OPTIONS requests are tus discovery
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -0,0 +1,592 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//HTML renders release notes written in Markdown. Raw HTML in the source is
+//escaped instead of passed through and links keep only http, https and
+//mailto URLs, so the result is safe to embed in a page.
+func HTML(source string) string {
+	var b strings.Builder
+	renderHTML(&b, parse(splitLines(source), 0))
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+//Text renders release notes written in Markdown as plain text: markup is
+//dropped, list markers and quotes are kept and links are followed by their
+//URL.
+func Text(source string) string {
+	return renderText(parse(splitLines(source), 0), "\n\n")
+}
+
+type blockKind int
+
+const (
+	paragraph blockKind = iota
+	heading
+	list
+	code
+	quote
+	rule
+)
+
+type block struct {
+	kind  blockKind
+	text  string //content of paragraphs, headings and code
+	level int    //of headings
+
+	ordered bool
+	start   int
+	items   [][]block
+
+	children []block //of quotes
+}
+
+//maxNesting bounds how deep quotes, lists and inline spans nest, deeper
+//markup is rendered as text
+const maxNesting = 32
+
+var (
+	headingPattern  = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	rulePattern     = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	listItemPattern = regexp.MustCompile(`^(?:[-*+]|(\d{1,9})[.)])(?:[ \t]+(.*))?$`)
+	hardBreak       = regexp.MustCompile(`(?: {2,}|\\)\n`)
+)
+
+func splitLines(source string) []string {
+	source = strings.ReplaceAll(source, "\x00", "")
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	return strings.Split(source, "\n")
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+//startsBlock reports whether a line interrupts a paragraph
+func startsBlock(line string) bool {
+	return isFence(line) || strings.HasPrefix(line, ">") ||
+		headingPattern.MatchString(line) || rulePattern.MatchString(line) || listItemPattern.MatchString(line)
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+func parse(lines []string, depth int) []block {
+	if depth > maxNesting {
+		text := strings.TrimSpace(strings.Join(lines, "\n"))
+		if text == "" {
+			return nil
+		}
+		return []block{{kind: paragraph, text: text}}
+	}
+
+	var blocks []block
+	for i := 0; i < len(lines); {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case line == "":
+			i++
+
+		case isFence(line):
+			fence := line[:3]
+			var body []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				body = append(body, lines[i])
+			}
+			i++
+			blocks = append(blocks, block{kind: code, text: strings.Join(body, "\n")})
+
+		case headingPattern.MatchString(line):
+			m := headingPattern.FindStringSubmatch(line)
+			blocks = append(blocks, block{kind: heading, level: len(m[1]), text: m[2]})
+			i++
+
+		case rulePattern.MatchString(line):
+			blocks = append(blocks, block{kind: rule})
+			i++
+
+		case strings.HasPrefix(line, ">"):
+			var body []string
+			for ; i < len(lines); i++ {
+				l := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(l, ">") {
+					break
+				}
+				body = append(body, strings.TrimPrefix(l[1:], " "))
+			}
+			blocks = append(blocks, block{kind: quote, children: parse(body, depth+1)})
+
+		case listItemPattern.MatchString(line):
+			var b block
+			b, i = parseList(lines, i, depth)
+			blocks = append(blocks, b)
+
+		default:
+			var body []string
+			for ; i < len(lines); i++ {
+				l := strings.TrimSpace(lines[i])
+				if l == "" || (len(body) > 0 && startsBlock(l)) {
+					break
+				}
+				body = append(body, strings.TrimLeft(lines[i], " \t"))
+			}
+			text := strings.TrimRight(strings.Join(body, "\n"), " \t\\")
+			blocks = append(blocks, block{kind: paragraph, text: text})
+		}
+	}
+	return blocks
+}
+
+//parseList reads the list starting at lines[i]. Lines indented by two or
+//more spaces belong to the current item, so nested lists and continued
+//paragraphs are parsed recursively. Returns the index after the list, which
+//is always past lines[i].
+func parseList(lines []string, i, depth int) (block, int) {
+	first := listItemPattern.FindStringSubmatch(strings.TrimSpace(lines[i]))
+	b := block{kind: list, ordered: first[1] != "", start: 1}
+	if b.ordered {
+		b.start, _ = strconv.Atoi(first[1])
+	}
+
+	var item []string
+	contentIndent := 2
+	flush := func() {
+		if item != nil {
+			b.items = append(b.items, parse(item, depth+1))
+			item = nil
+		}
+	}
+	sameKind := func(m []string) bool {
+		return m != nil && (m[1] != "") == b.ordered
+	}
+
+	for start := i; i < len(lines); i++ {
+		raw := lines[i]
+		line := strings.TrimSpace(raw)
+		indent := indentOf(raw)
+		//keep trailing spaces, they may be a line break
+		m := listItemPattern.FindStringSubmatch(raw[indent:])
+
+		switch {
+		case i == start:
+			//parse matched the trimmed line, which may differ from raw by
+			//whitespace other than spaces and tabs
+			if m == nil {
+				m = first
+			}
+			item = []string{m[2]}
+			contentIndent = len(raw) - len(m[2])
+			if m[2] == "" {
+				contentIndent = len(raw) + 1
+			}
+
+		case indent < 2 && sameKind(m) && !rulePattern.MatchString(line):
+			flush()
+			item = []string{m[2]}
+			contentIndent = len(raw) - len(m[2])
+			if m[2] == "" {
+				contentIndent = len(raw) + 1
+			}
+
+		case line == "":
+			//a blank line ends the list unless more of it follows
+			next := i + 1
+			for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+				next++
+			}
+			if next == len(lines) {
+				flush()
+				return b, next
+			}
+			if indentOf(lines[next]) < 2 && !sameKind(listItemPattern.FindStringSubmatch(strings.TrimSpace(lines[next]))) {
+				flush()
+				return b, next
+			}
+			for ; i < next; i++ {
+				item = append(item, "")
+			}
+			i--
+
+		case indent >= 2:
+			item = append(item, raw[min(indent, contentIndent):])
+
+		case startsBlock(line):
+			flush()
+			return b, i
+
+		default:
+			//lazy continuation of the item's paragraph
+			item = append(item, raw[indent:])
+		}
+	}
+	flush()
+	return b, i
+}
+
+func renderHTML(b *strings.Builder, blocks []block) {
+	for _, bl := range blocks {
+		switch bl.kind {
+		case paragraph:
+			fmt.Fprintf(b, "<p>%s</p>\n", inline(bl.text, true, 0))
+		case heading:
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", bl.level, inline(bl.text, true, 0), bl.level)
+		case rule:
+			b.WriteString("<hr>\n")
+		case code:
+			fmt.Fprintf(b, "<pre><code>%s</code></pre>\n", html.EscapeString(bl.text))
+		case quote:
+			b.WriteString("<blockquote>\n")
+			renderHTML(b, bl.children)
+			b.WriteString("</blockquote>\n")
+		case list:
+			tag := "ul"
+			if bl.ordered {
+				tag = "ol"
+			}
+			if bl.ordered && bl.start != 1 {
+				fmt.Fprintf(b, "<ol start=\"%d\">\n", bl.start)
+			} else {
+				fmt.Fprintf(b, "<%s>\n", tag)
+			}
+			for _, item := range bl.items {
+				b.WriteString("<li>")
+				rest := item
+				//the first paragraph of an item isn't wrapped, like a tight list
+				if len(item) > 0 && item[0].kind == paragraph {
+					b.WriteString(inline(item[0].text, true, 0))
+					rest = item[1:]
+				}
+				if len(rest) > 0 {
+					b.WriteString("\n")
+					renderHTML(b, rest)
+				}
+				b.WriteString("</li>\n")
+			}
+			fmt.Fprintf(b, "</%s>\n", tag)
+		}
+	}
+}
+
+func renderText(blocks []block, sep string) string {
+	var parts []string
+	for _, bl := range blocks {
+		switch bl.kind {
+		case paragraph, heading:
+			parts = append(parts, inline(bl.text, false, 0))
+		case code:
+			parts = append(parts, bl.text)
+		case quote:
+			parts = append(parts, prefixLines(renderText(bl.children, "\n\n"), "> ", "> "))
+		case list:
+			items := make([]string, len(bl.items))
+			for n, item := range bl.items {
+				marker := "- "
+				if bl.ordered {
+					marker = strconv.Itoa(bl.start+n) + ". "
+				}
+				items[n] = prefixLines(renderText(item, "\n"), marker, strings.Repeat(" ", len(marker)))
+			}
+			parts = append(parts, strings.Join(items, "\n"))
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+var emphasisTags = map[string]string{"**": "strong", "__": "strong", "~~": "del", "*": "em", "_": "em"}
+
+//inline renders the spans of a paragraph or heading as HTML or plain text.
+//depth counts the emphasis and links s is nested in.
+func inline(s string, asHTML bool, depth int) string {
+	//nested spans are cut from text that is already marked
+	if depth == 0 {
+		s = hardBreak.ReplaceAllString(s, "\x00")
+	}
+
+	var b strings.Builder
+	plain := func(text string) string {
+		if asHTML {
+			return html.EscapeString(text)
+		}
+		return text
+	}
+	if depth > maxNesting {
+		return plain(strings.ReplaceAll(s, "\x00", "\n"))
+	}
+	link := func(target, text string) {
+		switch {
+		case asHTML:
+			fmt.Fprintf(&b, "<a href=\"%s\" rel=\"nofollow\">%s</a>", html.EscapeString(target), text)
+		case text == target:
+			b.WriteString(text)
+		default:
+			fmt.Fprintf(&b, "%s (%s)", text, target)
+		}
+	}
+
+	//a closing delimiter or code span end that isn't found from one position
+	//isn't found from any later one either, so each is searched for once
+	unclosed := make(map[string]bool)
+	//matching brackets and parentheses, computed at the first link
+	var brackets, parens []int
+	//index of the next '>', for autolinks
+	nextGT := -1
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\x00':
+			if asHTML {
+				b.WriteString("<br>")
+			}
+			b.WriteString("\n")
+			i++
+			continue
+
+		case c == '\n':
+			if asHTML {
+				b.WriteString("\n")
+			} else {
+				b.WriteString(" ")
+			}
+			i++
+			continue
+
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", s[i+1]) >= 0:
+			b.WriteString(plain(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			ticks := s[i : i+len(s[i:])-len(strings.TrimLeft(s[i:], "`"))]
+			end := -1
+			if !unclosed[ticks] {
+				end = strings.Index(s[i+len(ticks):], ticks)
+				unclosed[ticks] = end < 0
+			}
+			if end >= 0 {
+				content := s[i+len(ticks) : i+len(ticks)+end]
+				content = strings.ReplaceAll(strings.ReplaceAll(content, "\x00", " "), "\n", " ")
+				if len(content) > 1 && content[0] == ' ' && content[len(content)-1] == ' ' {
+					content = content[1 : len(content)-1]
+				}
+				if asHTML {
+					fmt.Fprintf(&b, "<code>%s</code>", html.EscapeString(content))
+				} else {
+					b.WriteString(content)
+				}
+				i += len(ticks)*2 + end
+				continue
+			}
+			b.WriteString(ticks)
+			i += len(ticks)
+			continue
+
+		case c == '[' || (c == '!' && i+1 < len(s) && s[i+1] == '['):
+			start := i
+			if c == '!' {
+				start++
+			}
+			if brackets == nil {
+				brackets, parens = matchPairs(s, '[', ']', true), matchPairs(s, '(', ')', false)
+			}
+			if text, target, end, ok := parseLink(s, start, brackets, parens); ok {
+				rendered := inline(text, asHTML, depth+1)
+				if safeURL(target) {
+					link(target, rendered)
+				} else {
+					b.WriteString(rendered)
+				}
+				i = end
+				continue
+			}
+
+		case c == '<':
+			if nextGT < i {
+				if nextGT = strings.IndexByte(s[i:], '>'); nextGT < 0 {
+					nextGT = len(s)
+				} else {
+					nextGT += i
+				}
+			}
+			if nextGT < len(s) {
+				target := s[i+1 : nextGT]
+				if !strings.ContainsAny(target, " \n\x00<") && safeURL(target) {
+					link(target, plain(target))
+					i = nextGT + 1
+					continue
+				}
+			}
+
+		case (c == 'h' && (strings.HasPrefix(s[i:], "http://") || strings.HasPrefix(s[i:], "https://"))) && (i == 0 || !isWordChar(s[i-1])):
+			end := strings.IndexAny(s[i:], " \n\x00<")
+			if end < 0 {
+				end = len(s)
+			} else {
+				end += i
+			}
+			target := strings.TrimRight(s[i:end], ".,;:!?)'\"")
+			if safeURL(target) {
+				link(target, plain(target))
+				i += len(target)
+				continue
+			}
+			//the rest of the word isn't a link either
+			b.WriteString(plain(s[i:end]))
+			i = end
+			continue
+
+		default:
+			if delim := delimiterAt(s, i); delim != "" {
+				end := -1
+				if !unclosed[delim] {
+					end = closingDelimiter(s, i+len(delim), delim)
+					unclosed[delim] = end < 0
+				}
+				if end > 0 {
+					inner := inline(s[i+len(delim):end], asHTML, depth+1)
+					if asHTML {
+						tag := emphasisTags[delim]
+						fmt.Fprintf(&b, "<%s>%s</%s>", tag, inner, tag)
+					} else {
+						b.WriteString(inner)
+					}
+					i = end + len(delim)
+					continue
+				}
+				//an unmatched delimiter is literal text
+				b.WriteString(delim)
+				i += len(delim)
+				continue
+			}
+		}
+
+		b.WriteString(plain(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+//parseLink reads [text](target "title") at s[i], returning the index after
+//it. Balanced parentheses may appear in the target.
+func parseLink(s string, i int, brackets, parens []int) (text, target string, end int, ok bool) {
+	closeBracket := brackets[i]
+	if closeBracket == 0 || closeBracket+1 >= len(s) || s[closeBracket+1] != '(' {
+		return "", "", 0, false
+	}
+	closeParen := parens[closeBracket+1]
+	if closeParen == 0 {
+		return "", "", 0, false
+	}
+	dest := strings.TrimSpace(s[closeBracket+2 : closeParen])
+	if fields := strings.Fields(dest); len(fields) > 0 {
+		dest = fields[0]
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	return s[i+1 : closeBracket], dest, closeParen + 1, true
+}
+
+//matchPairs returns the index of the closer balancing each opener in s, or 0
+//for unbalanced ones, skipping backslash escapes if escapes is set
+func matchPairs(s string, opener, closer byte, escapes bool) []int {
+	pairs := make([]int, len(s))
+	var stack []int
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			if escapes {
+				j++
+			}
+		case opener:
+			stack = append(stack, j)
+		case closer:
+			if len(stack) > 0 {
+				pairs[stack[len(stack)-1]] = j
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return pairs
+}
+
+//safeURL allows absolute web and mail links, anything else (javascript:,
+//data:, relative paths) is dropped
+func safeURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+//delimiterAt returns the emphasis delimiter opening at s[i], if any.
+//Underscores only open at the start of a word so snake_case stays intact.
+func delimiterAt(s string, i int) string {
+	for _, delim := range []string{"**", "__", "~~", "*", "_"} {
+		if !strings.HasPrefix(s[i:], delim) {
+			continue
+		}
+		next := i + len(delim)
+		if next >= len(s) || s[next] == ' ' || s[next] == '\n' || s[next] == '\x00' {
+			return ""
+		}
+		if delim[0] == '_' && i > 0 && isWordChar(s[i-1]) {
+			return ""
+		}
+		return delim
+	}
+	return ""
+}
+
+//closingDelimiter finds the delimiter closing an emphasis opened before
+//from, returning its index or -1
+func closingDelimiter(s string, from int, delim string) int {
+	for k := from; k < len(s); k++ {
+		found := strings.Index(s[k:], delim)
+		if found < 0 {
+			return -1
+		}
+		k += found
+		after := k + len(delim)
+		switch {
+		case k == from, s[k-1] == ' ', s[k-1] == '\n':
+		case len(delim) == 1 && (s[k-1] == delim[0] || (after < len(s) && s[after] == delim[0])):
+		case delim[0] == '_' && after < len(s) && isWordChar(s[after]):
+		default:
+			return k
+		}
+	}
+	return -1
+}
\ No newline at end of file
@@ -0,0 +1,217 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"empty", "", ""},
+		{"paragraph", "Faster sync", "<p>Faster sync</p>"},
+		{"paragraphs", "One\n\nTwo", "<p>One</p>\n<p>Two</p>"},
+		{"soft break", "One\ntwo", "<p>One\ntwo</p>"},
+		{"hard break", "One  \ntwo", "<p>One<br>\ntwo</p>"},
+		{"backslash break", "One\\\ntwo", "<p>One<br>\ntwo</p>"},
+		{"CRLF", "One\r\n\r\nTwo", "<p>One</p>\n<p>Two</p>"},
+		{"headings", "# Release 2.0\n### Fixes ###", "<h1>Release 2.0</h1>\n<h3>Fixes</h3>"},
+		{"not a heading", "#hashtag", "<p>#hashtag</p>"},
+		{"rule", "One\n\n---\n\nTwo", "<p>One</p>\n<hr>\n<p>Two</p>"},
+		{"emphasis", "*new* **bold** _em_ __strong__ ~~gone~~", "<p><em>new</em> <strong>bold</strong> <em>em</em> <strong>strong</strong> <del>gone</del></p>"},
+		{"nested emphasis", "**very *new* build**", "<p><strong>very <em>new</em> build</strong></p>"},
+		{"snake_case", "use max_upload_size", "<p>use max_upload_size</p>"},
+		{"unmatched delimiter", "2 * 3", "<p>2 * 3</p>"},
+		{"escaped delimiter", `\*not em\*`, "<p>*not em*</p>"},
+		{"code span", "run `a < b`", "<p>run <code>a &lt; b</code></p>"},
+		{"double backtick code span", "``a ` b``", "<p><code>a ` b</code></p>"},
+		{"code block", "```go\nif a < b {\n}\n```", "<pre><code>if a &lt; b {\n}</code></pre>"},
+		{"tilde fence", "~~~\n*raw*\n~~~", "<pre><code>*raw*</code></pre>"},
+		{"unordered list", "- one\n- two", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>"},
+		{"ordered list", "1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>"},
+		{"ordered list start", "3) three\n4) four", "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>"},
+		{"nested list", "- one\n  - inner\n- two", "<ul>\n<li>one\n<ul>\n<li>inner</li>\n</ul>\n</li>\n<li>two</li>\n</ul>"},
+		{"lazy continuation", "- one\ncontinued", "<ul>\n<li>one\ncontinued</li>\n</ul>"},
+		{"list after paragraph", "Changes:\n- one", "<p>Changes:</p>\n<ul>\n<li>one</li>\n</ul>"},
+		{"quote", "> quoted\n> **text**", "<blockquote>\n<p>quoted\n<strong>text</strong></p>\n</blockquote>"},
+		{"link", "[docs](https://example.com/docs)", "<p><a href=\"https://example.com/docs\" rel=\"nofollow\">docs</a></p>"},
+		{"link with title", `[docs](https://example.com "Docs")`, "<p><a href=\"https://example.com\" rel=\"nofollow\">docs</a></p>"},
+		{"link with parentheses", "[wiki](https://example.com/a_(b))", "<p><a href=\"https://example.com/a_(b)\" rel=\"nofollow\">wiki</a></p>"},
+		{"mailto link", "[mail](mailto:team@example.com)", "<p><a href=\"mailto:team@example.com\" rel=\"nofollow\">mail</a></p>"},
+		{"image is a link", "![logo](https://example.com/logo.png)", "<p><a href=\"https://example.com/logo.png\" rel=\"nofollow\">logo</a></p>"},
+		{"autolink", "<https://example.com>", "<p><a href=\"https://example.com\" rel=\"nofollow\">https://example.com</a></p>"},
+		{"bare URL", "See https://example.com/a?b=1&c=2.", "<p>See <a href=\"https://example.com/a?b=1&amp;c=2\" rel=\"nofollow\">https://example.com/a?b=1&amp;c=2</a>.</p>"},
+
+		//raw HTML and unsafe URLs
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"inline HTML", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>"},
+		{"HTML block", "<div>\n<b>bold</b>\n</div>", "<p>&lt;div&gt;\n&lt;b&gt;bold&lt;/b&gt;\n&lt;/div&gt;</p>"},
+		{"HTML in a heading", "# <i>title</i>", "<h1>&lt;i&gt;title&lt;/i&gt;</h1>"},
+		{"javascript link", "[click](javascript:alert(1))", "<p>click</p>"},
+		{"javascript link in uppercase", "[click](JavaScript:alert(1))", "<p>click</p>"},
+		{"data link", "[click](data:text/html;base64,PHNjcmlwdD4=)", "<p>click</p>"},
+		{"relative link", "[click](/admin)", "<p>click</p>"},
+		{"protocol-relative link", "[click](//evil.example)", "<p>click</p>"},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>"},
+		{"quote in the URL", `[x](https://example.com/"onmouseover="alert(1))`, "<p><a href=\"https://example.com/&#34;onmouseover=&#34;alert(1)\" rel=\"nofollow\">x</a></p>"},
+		{"HTML in the link text", "[<b>x</b>](https://example.com)", "<p><a href=\"https://example.com\" rel=\"nofollow\">&lt;b&gt;x&lt;/b&gt;</a></p>"},
+		{"NUL", "a\x00b", "<p>ab</p>"},
+	}
+	for _, tt := range tests {
+		if got := HTML(tt.source); got != tt.want {
+			t.Errorf("%s: HTML(%q) =\n%s\nwant\n%s", tt.name, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"empty", "", ""},
+		{"paragraphs", "One\n\nTwo", "One\n\nTwo"},
+		{"soft break", "One\ntwo", "One two"},
+		{"hard break", "One  \ntwo", "One\ntwo"},
+		{"heading", "## Fixes", "Fixes"},
+		{"rule", "One\n\n***\n\nTwo", "One\n\nTwo"},
+		{"emphasis", "*new* **bold** ~~gone~~", "new bold gone"},
+		{"escaped", `\*literal\*`, "*literal*"},
+		{"code span", "run `make test`", "run make test"},
+		{"code block", "```\n  indented\n```", "  indented"},
+		{"unordered list", "- one\n- two", "- one\n- two"},
+		{"ordered list", "3. three\n4. four", "3. three\n4. four"},
+		{"nested list", "- one\n  - inner\n- two", "- one\n  - inner\n- two"},
+		{"quote", "> one\n>\n> two", "> one\n>\n> two"},
+		{"link", "[docs](https://example.com)", "docs (https://example.com)"},
+		{"autolink", "<https://example.com>", "https://example.com"},
+		{"bare URL", "See https://example.com.", "See https://example.com."},
+		{"unsafe link", "[click](javascript:alert(1))", "click"},
+		{"HTML is kept as text", "<b>bold</b> & more", "<b>bold</b> & more"},
+	}
+	for _, tt := range tests {
+		if got := Text(tt.source); got != tt.want {
+			t.Errorf("%s: Text(%q) =\n%s\nwant\n%s", tt.name, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		safe bool
+	}{
+		{"https://example.com", true},
+		{"HTTP://example.com/path", true},
+		{"mailto:team@example.com", true},
+		{"https://", false},
+		{"mailto:", false},
+		{"javascript:alert(1)", false},
+		{"vbscript:msgbox", false},
+		{"data:text/html,hi", false},
+		{"file:///etc/passwd", false},
+		{"/relative", false},
+		{"//example.com", false},
+		{"%zz", false},
+	}
+	for _, tt := range tests {
+		if got := safeURL(tt.url); got != tt.safe {
+			t.Errorf("safeURL(%q) = %v, want %v", tt.url, got, tt.safe)
+		}
+	}
+}
+
+//renderDeadline bounds rendering any input, notes are rendered on requests
+const renderDeadline = 2 * time.Second
+
+//allowedTag matches the markup HTML may produce
+var allowedTag = regexp.MustCompile(`</?(?:p|h[1-6]|pre|code|blockquote|ul|ol|li|em|strong|del)>|</a>|<hr>|<br>|<ol start="\d+">|<a href="(?i:https?|mailto):[^"<>]*" rel="nofollow">`)
+
+//render renders source as HTML and text, failing if that takes longer than
+//renderDeadline or the HTML contains markup that isn't allowed
+func render(t *testing.T, source string) {
+	t.Helper()
+	var out string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out = HTML(source)
+		Text(source)
+	}()
+	select {
+	case <-done:
+	case <-time.After(renderDeadline):
+		t.Fatalf("rendering %.100q took longer than %v", source, renderDeadline)
+	}
+	if rest := allowedTag.ReplaceAllString(out, ""); strings.Contains(rest, "<") {
+		t.Errorf("HTML(%.100q) = %.200q contains markup that isn't allowed", source, out)
+	}
+}
+
+func TestRenderTerminates(t *testing.T) {
+	var ticks strings.Builder
+	for n := 1; n < 300; n++ {
+		ticks.WriteString(strings.Repeat("`", n) + "a")
+	}
+
+	tests := []struct {
+		name   string
+		source string
+	}{
+		//list markers parse and parseList trimmed differently
+		{"list marker before a form feed", "*\f "},
+		{"list marker before a vertical tab", "- item\n-\v"},
+		{"list marker after a form feed", "\f- item"},
+		{"indented list marker", "   - item\n- next"},
+
+		//inputs that took quadratic time
+		{"unclosed brackets", strings.Repeat("[", 1<<16)},
+		{"unclosed link targets", strings.Repeat("[a](", 1<<14)},
+		{"unclosed autolinks", strings.Repeat("<", 1<<16) + ">"},
+		{"unclosed emphasis", strings.Repeat("*a ", 1<<15)},
+		{"unclosed code spans", ticks.String()},
+		{"invalid bare URLs", strings.Repeat("http://%zz.", 1<<13)},
+		{"blank lines in a list", "- a" + strings.Repeat("\n", 1<<16) + "  b"},
+		{"nested quotes", strings.Repeat(">", 1<<14)},
+		{"nested lists", strings.Repeat("- ", 1<<13)},
+		{"nested emphasis", strings.Repeat("**a ", 1<<13) + strings.Repeat(" a**", 1<<13)},
+		{"nested links", strings.Repeat("[", 1<<12) + "a" + strings.Repeat("](https://example.com)", 1<<12)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			render(t, tt.source)
+		})
+	}
+}
+
+func TestNestingLimit(t *testing.T) {
+	source := strings.Repeat("> ", 2*maxNesting) + "deep"
+	if got, want := strings.Count(HTML(source), "<blockquote>"), maxNesting+1; got != want {
+		t.Errorf("%d nested quotes rendered, want %d", got, want)
+	}
+	if got := Text(source); !strings.HasSuffix(got, "deep") {
+		t.Errorf("Text = %q, lost the content", got)
+	}
+}
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"# Release 2.0\n\n- **Faster** sync\n- Fixed [crash](https://example.com/issues/1)\n\n> Note: `migrate` first",
+		"1. one\n   - nested\n2. two\n\n```\ncode\n```",
+		"<script>alert(1)</script> [x](javascript:alert(1)) <https://example.com>",
+		"*\f ",
+		"- item\n-\v",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, source string) {
+		render(t, source)
+	})
+}
\ No newline at end of file
@@ -0,0 +1,270 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+//sign computes X-Hub-Signature-256 of a request signed at ts
+func sign(secret string, ts time.Time, method, target, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, strconv.FormatInt(ts.Unix(), 10)+"."+method+" "+target+"."+body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//signedRequest builds a request with the given signature headers, empty
+//values are left out
+func signedRequest(method, target, body, signature string, ts time.Time) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if signature != "" {
+		req.Header.Set("X-Hub-Signature-256", signature)
+	}
+	if !ts.IsZero() {
+		req.Header.Set("X-Signature-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+	}
+	return req
+}
+
+//serveAuth runs req through WebhookAuth and returns the status and the body
+//the handler read
+func serveAuth(v *Verifier, req *http.Request) (int, string) {
+	var got string
+	handler := WebhookAuth(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, got
+}
+
+func TestWebhookAuth(t *testing.T) {
+	now := time.Now()
+	body := `{"channel": "stable"}`
+	valid := sign(testSecret, now, http.MethodPost, "/api/v1/upload?async=1", body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		require bool
+		req     func() *http.Request
+		status  int
+	}{
+		{"no secret configured", "", true, func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(body))
+		}, http.StatusOK},
+		{"plain secret", testSecret, false, func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(body))
+			req.Header.Set("X-Webhook-Secret", testSecret)
+			return req
+		}, http.StatusOK},
+		{"wrong plain secret", testSecret, false, func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(body))
+			req.Header.Set("X-Webhook-Secret", "guess")
+			return req
+		}, http.StatusUnauthorized},
+		{"no credentials", testSecret, false, func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(body))
+		}, http.StatusUnauthorized},
+		{"plain secret when signatures are required", testSecret, true, func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", strings.NewReader(body))
+			req.Header.Set("X-Webhook-Secret", testSecret)
+			return req
+		}, http.StatusUnauthorized},
+		{"signature", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload?async=1", body, valid, now)
+		}, http.StatusOK},
+		{"uppercase hex", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload?async=1", body, "sha256="+strings.ToUpper(strings.TrimPrefix(valid, "sha256=")), now)
+		}, http.StatusOK},
+		{"wrong secret", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload", body, sign("guess", now, http.MethodPost, "/api/v1/upload", body), now)
+		}, http.StatusUnauthorized},
+		{"modified body", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload?async=1", `{"channel": "beta"}`, valid, now)
+		}, http.StatusUnauthorized},
+		{"other route", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/releases/stable/1.0.0/yank", body, valid, now)
+		}, http.StatusUnauthorized},
+		{"other query", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload", body, valid, now)
+		}, http.StatusUnauthorized},
+		{"other method", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPut, "/api/v1/upload?async=1", body, valid, now)
+		}, http.StatusUnauthorized},
+		{"timestamp not signed", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload?async=1", body, valid, now.Add(-time.Minute))
+		}, http.StatusUnauthorized},
+		{"missing timestamp", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload?async=1", body, valid, time.Time{})
+		}, http.StatusUnauthorized},
+		{"expired", testSecret, true, func() *http.Request {
+			old := now.Add(-10 * time.Minute)
+			return signedRequest(http.MethodPost, "/api/v1/upload", body, sign(testSecret, old, http.MethodPost, "/api/v1/upload", body), old)
+		}, http.StatusUnauthorized},
+		{"from the future", testSecret, true, func() *http.Request {
+			later := now.Add(10 * time.Minute)
+			return signedRequest(http.MethodPost, "/api/v1/upload", body, sign(testSecret, later, http.MethodPost, "/api/v1/upload", body), later)
+		}, http.StatusUnauthorized},
+		{"within the tolerance", testSecret, true, func() *http.Request {
+			earlier := now.Add(-4 * time.Minute)
+			return signedRequest(http.MethodPost, "/api/v1/upload", body, sign(testSecret, earlier, http.MethodPost, "/api/v1/upload", body), earlier)
+		}, http.StatusOK},
+		{"malformed signature", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload?async=1", body, strings.TrimPrefix(valid, "sha256="), now)
+		}, http.StatusUnauthorized},
+		{"short signature", testSecret, true, func() *http.Request {
+			return signedRequest(http.MethodPost, "/api/v1/upload?async=1", body, "sha256=abcd", now)
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		v := NewVerifier(tt.secret, SignatureConfig{Tolerance: 5 * time.Minute, RequireSignature: tt.require, MaxBody: 1 << 20})
+		status, got := serveAuth(v, tt.req())
+		if status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+			continue
+		}
+		//the handler reads the same body that was verified
+		if status == http.StatusOK && got != body {
+			t.Errorf("%s: handler read %q, want %q", tt.name, got, body)
+		}
+	}
+}
+
+func TestWebhookAuthReplay(t *testing.T) {
+	v := NewVerifier(testSecret, SignatureConfig{Tolerance: 5 * time.Minute, MaxBody: 1 << 20})
+	now := time.Now()
+	signature := sign(testSecret, now, http.MethodPost, "/api/v1/upload", "{}")
+
+	steps := []struct {
+		name      string
+		signature string
+		status    int
+	}{
+		{"first use", signature, http.StatusOK},
+		{"replay", signature, http.StatusUnauthorized},
+		{"replay in uppercase", "sha256=" + strings.ToUpper(strings.TrimPrefix(signature, "sha256=")), http.StatusUnauthorized},
+	}
+	for _, step := range steps {
+		if status, _ := serveAuth(v, signedRequest(http.MethodPost, "/api/v1/upload", "{}", step.signature, now)); status != step.status {
+			t.Errorf("%s: status = %d, want %d", step.name, status, step.status)
+		}
+	}
+
+	//signatures are forgotten once their timestamp is outside the window
+	v.mu.Lock()
+	for s := range v.seen {
+		v.seen[s] = time.Now().Add(-time.Second)
+	}
+	v.mu.Unlock()
+	v.markSeen("other", time.Now().Add(time.Minute))
+	if len(v.seen) != 1 {
+		t.Errorf("%d signatures remembered, want 1", len(v.seen))
+	}
+}
+
+func TestSignedBodyLimit(t *testing.T) {
+	now := time.Now()
+	large := strings.Repeat("x", maxMemoryBody+1<<10)
+
+	tests := []struct {
+		name    string
+		maxBody int64
+		status  int
+	}{
+		{"buffered in a file", 2 * maxMemoryBody, http.StatusOK},
+		{"above the limit", maxMemoryBody + 1<<9, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		v := NewVerifier(testSecret, SignatureConfig{Tolerance: 5 * time.Minute, RequireSignature: true, MaxBody: tt.maxBody})
+		req := signedRequest(http.MethodPost, "/api/v1/upload", large, sign(testSecret, now, http.MethodPost, "/api/v1/upload", large), now)
+		status, got := serveAuth(v, req)
+		if status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+			continue
+		}
+		if status == http.StatusOK && got != large {
+			t.Errorf("%s: handler read %d bytes, want %d", tt.name, len(got), len(large))
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		secret  string
+		require bool
+		header  map[string]string
+		want    bool
+	}{
+		{"no secret configured", "", true, nil, true},
+		{"no credentials", testSecret, false, nil, false},
+		{"plain secret", testSecret, false, map[string]string{"X-Webhook-Secret": testSecret}, true},
+		{"wrong plain secret", testSecret, false, map[string]string{"X-Webhook-Secret": "guess"}, false},
+		{"plain secret when signatures are required", testSecret, true, map[string]string{"X-Webhook-Secret": testSecret}, false},
+		{"signature", testSecret, true, map[string]string{
+			"X-Hub-Signature-256":   sign(testSecret, now, http.MethodGet, "/api/v1/releases/stable", ""),
+			"X-Signature-Timestamp": strconv.FormatInt(now.Unix(), 10),
+		}, true},
+		{"wrong signature", testSecret, true, map[string]string{
+			"X-Hub-Signature-256":   sign("guess", now, http.MethodGet, "/api/v1/releases/stable", ""),
+			"X-Signature-Timestamp": strconv.FormatInt(now.Unix(), 10),
+		}, false},
+	}
+	for _, tt := range tests {
+		v := NewVerifier(tt.secret, SignatureConfig{Tolerance: 5 * time.Minute, RequireSignature: tt.require, MaxBody: 1 << 20})
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/releases/stable", nil)
+		for key, value := range tt.header {
+			req.Header.Set(key, value)
+		}
+		if got := v.Authorized(req); got != tt.want {
+			t.Errorf("%s: Authorized = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+func TestSignedPrefix(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		want   string
+	}{
+		{http.MethodPost, "/api/v1/upload?async=1", "1700000000.POST /api/v1/upload?async=1."},
+		{http.MethodDelete, "/api/v1/releases/stable/1.0.0", "1700000000.DELETE /api/v1/releases/stable/1.0.0."},
+		{http.MethodGet, "/api/v1/apps/tv/releases/stable?page=2&per_page=10", "1700000000.GET /api/v1/apps/tv/releases/stable?page=2&per_page=10."},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if got := signedPrefix(req, "1700000000"); got != tt.want {
+			t.Errorf("signedPrefix(%s %s) = %q, want %q", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestGitHubBodySignatureRefused(t *testing.T) {
+	now := time.Now()
+	body := `{"channel": "stable"}`
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	io.WriteString(mac, body)
+	req := signedRequest(http.MethodPost, "/api/v1/upload", body, "sha256="+hex.EncodeToString(mac.Sum(nil)), now)
+
+	rec := httptest.NewRecorder()
+	WebhookAuth(NewVerifier(testSecret, SignatureConfig{Tolerance: 5 * time.Minute, MaxBody: 1 << 20}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a signature over the body alone was accepted")
+	})).ServeHTTP(rec, req)
+	//the response explains what is signed
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "{X-Signature-Timestamp}.{method} {path and query}.{body}") {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body)
+	}
+}
\ No newline at end of file
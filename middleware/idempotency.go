@@ -0,0 +1,332 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sono-version-service/database"
+)
+
+//a claim whose request never finished, e.g. because the process died, is
+//given up after this long
+const idempotencyClaimTimeout = time.Hour
+
+//responses larger than this are not kept for replay
+const maxReplayBody = 1 << 20
+
+//headers restored when a response is replayed
+var replayHeaders = []string{"Content-Type", "Location"}
+
+type IdempotencyRecord struct {
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+}
+
+//IdempotencyStore keeps the responses of requests sent with an
+//Idempotency-Key header
+type IdempotencyStore interface {
+	//Claim reserves key for a new request and returns nil, or returns the
+	//record of the request that already used it
+	Claim(ctx context.Context, key string, ttl time.Duration) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, key string, rec *IdempotencyRecord) error
+	Release(ctx context.Context, key string) error
+}
+
+//NewIdempotencyStore keeps keys in the database, or in memory without one
+func NewIdempotencyStore(db *database.DB) IdempotencyStore {
+	if db == nil {
+		return &memoryIdempotencyStore{records: make(map[string]*memoryIdempotencyRecord)}
+	}
+	return &dbIdempotencyStore{db: db}
+}
+
+//Idempotency makes retried requests with the same Idempotency-Key header
+//replay the first response instead of running again. Reusing a key with a
+//different body is answered with 409. Server errors free the key, so the
+//request can be retried. maxBody bounds how much of a body is hashed.
+func Idempotency(store IdempotencyStore, ttl time.Duration, maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+				return
+			}
+
+			rec, err := store.Claim(r.Context(), key, ttl)
+			if errors.Is(err, database.ErrIdempotencyKeyContended) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Idempotency-Key is being used concurrently, retry the request", http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to claim idempotency key: %v", err)
+				http.Error(w, "Failed to check Idempotency-Key", http.StatusInternalServerError)
+				return
+			}
+
+			if rec != nil {
+				if !rec.Completed {
+					http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+					return
+				}
+				fingerprint, ok := newBodyHasher(r).sum(maxBody)
+				if !ok || fingerprint != rec.Fingerprint {
+					http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusConflict)
+					return
+				}
+				for name, values := range rec.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(rec.StatusCode)
+				w.Write(rec.Body)
+				return
+			}
+
+			body := newBodyHasher(r)
+			r.Body = body
+			recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			//the client may be gone by now, the outcome still has to be kept
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			fingerprint, ok := body.sum(maxBody)
+			if !ok || recorder.statusCode >= 500 || recorder.overflow {
+				if err := store.Release(ctx, key); err != nil {
+					log.Printf("Failed to release idempotency key: %v", err)
+				}
+				return
+			}
+
+			header := make(http.Header)
+			for _, name := range replayHeaders {
+				if value := w.Header().Get(name); value != "" {
+					header.Set(name, value)
+				}
+			}
+			if err := store.Complete(ctx, key, &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				StatusCode:  recorder.statusCode,
+				Header:      header,
+				Body:        recorder.body.Bytes(),
+			}); err != nil {
+				log.Printf("Failed to save idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+//responseRecorder passes a response through while keeping a copy
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	overflow   bool
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	rr.statusCode = code
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.body.Len()+len(b) > maxReplayBody {
+		rr.overflow = true
+	} else if !rr.overflow {
+		rr.body.Write(b)
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+//bodyHasher hashes a request body while the handler reads it. Multipart
+//bodies are hashed part by part, so a retry whose client picked a new
+//boundary still matches.
+type bodyHasher struct {
+	io.Reader
+	body io.ReadCloser
+	hash hash.Hash
+	pipe *io.PipeWriter
+	done chan struct{}
+	read int64 //by the handler and by sum
+}
+
+func newBodyHasher(r *http.Request) *bodyHasher {
+	h := &bodyHasher{body: r.Body, hash: sha256.New()}
+	h.Reader = io.TeeReader(r.Body, h.hash)
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		pr, pw := io.Pipe()
+		h.Reader = io.TeeReader(r.Body, pw)
+		h.pipe = pw
+		h.done = make(chan struct{})
+		go func() {
+			defer close(h.done)
+			h.hashParts(multipart.NewReader(pr, params["boundary"]))
+			io.Copy(io.Discard, pr)
+		}()
+	}
+	return h
+}
+
+func (h *bodyHasher) hashParts(mr *multipart.Reader) {
+	for {
+		part, err := mr.NextRawPart()
+		if err != nil {
+			if err != io.EOF {
+				//malformed bodies can't match each other by accident
+				io.WriteString(h.hash, "invalid:"+err.Error())
+			}
+			return
+		}
+		for _, name := range []string{"Content-Disposition", "Content-Type"} {
+			io.WriteString(h.hash, name+": "+part.Header.Get(name)+"\n")
+		}
+		if _, err := io.Copy(h.hash, part); err != nil {
+			io.WriteString(h.hash, "invalid:"+err.Error())
+			return
+		}
+		h.hash.Write([]byte{0})
+	}
+}
+
+func (h *bodyHasher) Read(b []byte) (int, error) {
+	n, err := h.Reader.Read(b)
+	h.read += int64(n)
+	return n, err
+}
+
+func (h *bodyHasher) Close() error {
+	return h.body.Close()
+}
+
+//sum reads the rest of the body and returns its fingerprint, false if the
+//body is longer than maxBody or can't be read
+func (h *bodyHasher) sum(maxBody int64) (string, bool) {
+	_, err := io.Copy(io.Discard, io.LimitReader(h, maxBody+1-h.read))
+	if h.pipe != nil {
+		h.pipe.Close()
+		<-h.done
+	}
+	if (err != nil && !errors.Is(err, http.ErrBodyReadAfterClose)) || h.read > maxBody {
+		return "", false
+	}
+	return hex.EncodeToString(h.hash.Sum(nil)), true
+}
+
+type memoryIdempotencyRecord struct {
+	IdempotencyRecord
+	claimedAt time.Time
+	expiresAt time.Time
+}
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*memoryIdempotencyRecord
+}
+
+func (s *memoryIdempotencyStore) Claim(ctx context.Context, key string, ttl time.Duration) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, rec := range s.records {
+		if now.After(rec.expiresAt) {
+			delete(s.records, k)
+		}
+	}
+
+	if rec := s.records[key]; rec != nil && (rec.Completed || now.Sub(rec.claimedAt) < idempotencyClaimTimeout) {
+		snapshot := rec.IdempotencyRecord
+		return &snapshot, nil
+	}
+	s.records[key] = &memoryIdempotencyRecord{claimedAt: now, expiresAt: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, key string, rec *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing := s.records[key]; existing != nil {
+		existing.IdempotencyRecord = *rec
+	}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec := s.records[key]; rec != nil && !rec.Completed {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+type dbIdempotencyStore struct {
+	db *database.DB
+}
+
+func (s *dbIdempotencyStore) Claim(ctx context.Context, key string, ttl time.Duration) (*IdempotencyRecord, error) {
+	now := time.Now()
+	row, err := s.db.ClaimIdempotencyKey(ctx, key, now.Add(ttl), now.Add(-idempotencyClaimTimeout))
+	if err != nil || row == nil {
+		return nil, err
+	}
+
+	rec := &IdempotencyRecord{
+		Fingerprint: row.Fingerprint,
+		Completed:   row.Completed,
+		StatusCode:  row.StatusCode,
+		Body:        row.Body,
+	}
+	if row.Headers != "" {
+		if err := json.Unmarshal([]byte(row.Headers), &rec.Header); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+func (s *dbIdempotencyStore) Complete(ctx context.Context, key string, rec *IdempotencyRecord) error {
+	headers, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+	return s.db.CompleteIdempotencyKey(ctx, key, &database.IdempotencyRecord{
+		Fingerprint: rec.Fingerprint,
+		Completed:   true,
+		StatusCode:  rec.StatusCode,
+		Headers:     string(headers),
+		Body:        rec.Body,
+	})
+}
+
+func (s *dbIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.db.ReleaseIdempotencyKey(ctx, key)
+}
\ No newline at end of file
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sono-version-service/database"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	handler := Idempotency(NewIdempotencyStore(nil), time.Hour, 1<<10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "fail" {
+			http.Error(w, "storage unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/releases/1")
+		w.Header().Set("X-Request-Count", strings.Repeat("i", calls))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"published": "`+string(body)+`"}`)
+	}))
+
+	steps := []struct {
+		name     string
+		key      string
+		body     string
+		status   int
+		calls    int //handler calls after the step
+		replayed bool
+	}{
+		{"first request", "a", "v1", http.StatusCreated, 1, false},
+		{"retry", "a", "v1", http.StatusCreated, 1, true},
+		{"different body", "a", "v2", http.StatusConflict, 1, false},
+		{"no key", "", "v1", http.StatusCreated, 2, false},
+		{"other key", "b", "v1", http.StatusCreated, 3, false},
+		{"key too long", strings.Repeat("k", 256), "v1", http.StatusBadRequest, 3, false},
+		{"server error", "c", "fail", http.StatusInternalServerError, 4, false},
+		{"retry after a server error", "c", "fail", http.StatusInternalServerError, 5, false},
+		{"body above the limit", "d", strings.Repeat("x", 2<<10), http.StatusCreated, 6, false},
+		{"retry of a body above the limit", "d", strings.Repeat("x", 2<<10), http.StatusCreated, 7, false},
+	}
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(step.body))
+		if step.key != "" {
+			req.Header.Set("Idempotency-Key", step.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if calls != step.calls {
+			t.Errorf("%s: handler ran %d times, want %d", step.name, calls, step.calls)
+		}
+		if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != step.replayed {
+			t.Errorf("%s: replayed = %v, want %v", step.name, replayed, step.replayed)
+		}
+		if step.replayed {
+			if rec.Body.String() != `{"published": "v1"}` || rec.Header().Get("Location") != "/releases/1" || rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("%s: replayed %v %s", step.name, rec.Header(), rec.Body)
+			}
+			//only the listed headers are replayed
+			if rec.Header().Get("X-Request-Count") != "" {
+				t.Errorf("%s: replayed X-Request-Count", step.name)
+			}
+		}
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	store := NewIdempotencyStore(nil)
+	if rec, err := store.Claim(context.Background(), "a", time.Hour); rec != nil || err != nil {
+		t.Fatalf("Claim = %+v, %v", rec, err)
+	}
+	handler := Idempotency(store, time.Hour, 1<<10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the handler ran while the key was claimed")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("v1"))
+	req.Header.Set("Idempotency-Key", "a")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "in progress") {
+		t.Errorf("status = %d: %s, want %d", rec.Code, rec.Body, http.StatusConflict)
+	}
+}
+
+//multipartRequest builds a form upload with the given boundary
+func multipartRequest(t *testing.T, boundary string, fields ...string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.SetBoundary(boundary); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		mw.WriteField(fields[i], fields[i+1])
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestBodyFingerprint(t *testing.T) {
+	fingerprint := func(r *http.Request, maxBody int64) (string, bool) {
+		return newBodyHasher(r).sum(maxBody)
+	}
+	jsonRequest := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	tests := []struct {
+		name  string
+		a, b  *http.Request
+		equal bool
+	}{
+		{"same JSON", jsonRequest(`{"version": "1.0.0"}`), jsonRequest(`{"version": "1.0.0"}`), true},
+		{"different JSON", jsonRequest(`{"version": "1.0.0"}`), jsonRequest(`{"version": "1.0.1"}`), false},
+		{"new multipart boundary", multipartRequest(t, "first", "version", "1.0.0"), multipartRequest(t, "second", "version", "1.0.0"), true},
+		{"different multipart field", multipartRequest(t, "first", "version", "1.0.0"), multipartRequest(t, "first", "version", "1.0.1"), false},
+		{"different multipart field name", multipartRequest(t, "first", "version", "1.0.0"), multipartRequest(t, "first", "channel", "1.0.0"), false},
+	}
+	for _, tt := range tests {
+		a, okA := fingerprint(tt.a, 1<<10)
+		b, okB := fingerprint(tt.b, 1<<10)
+		if !okA || !okB {
+			t.Errorf("%s: fingerprint failed", tt.name)
+			continue
+		}
+		if (a == b) != tt.equal {
+			t.Errorf("%s: fingerprints %s and %s, want equal %v", tt.name, a, b, tt.equal)
+		}
+	}
+
+	if _, ok := fingerprint(jsonRequest(strings.Repeat("x", 11)), 10); ok {
+		t.Error("fingerprint of a body above the limit succeeded")
+	}
+	if _, ok := fingerprint(jsonRequest(strings.Repeat("x", 10)), 10); !ok {
+		t.Error("fingerprint of a body at the limit failed")
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewIdempotencyStore(nil).(*memoryIdempotencyStore)
+
+	s.Claim(ctx, "done", time.Hour)
+	s.Complete(ctx, "done", &IdempotencyRecord{Fingerprint: "f", Completed: true, StatusCode: http.StatusOK})
+	//a finished request keeps its key
+	s.Release(ctx, "done")
+	if rec, _ := s.Claim(ctx, "done", time.Hour); rec == nil || !rec.Completed || rec.Fingerprint != "f" {
+		t.Errorf("Claim(done) = %+v, want the completed record", rec)
+	}
+
+	s.Claim(ctx, "released", time.Hour)
+	s.Release(ctx, "released")
+	if rec, _ := s.Claim(ctx, "released", time.Hour); rec != nil {
+		t.Errorf("Claim(released) = %+v, want nil", rec)
+	}
+
+	//expired keys and abandoned claims can be used again
+	s.Claim(ctx, "expired", time.Hour)
+	s.Complete(ctx, "expired", &IdempotencyRecord{Completed: true})
+	s.Claim(ctx, "abandoned", 2*idempotencyClaimTimeout)
+	s.mu.Lock()
+	s.records["expired"].expiresAt = time.Now().Add(-time.Second)
+	s.records["abandoned"].claimedAt = time.Now().Add(-idempotencyClaimTimeout - time.Second)
+	s.mu.Unlock()
+	for _, key := range []string{"expired", "abandoned"} {
+		if rec, _ := s.Claim(ctx, key, time.Hour); rec != nil {
+			t.Errorf("Claim(%s) = %+v, want nil", key, rec)
+		}
+	}
+}
+//failingClaimStore fails every claim with err
+type failingClaimStore struct {
+	IdempotencyStore
+	err error
+}
+
+func (s failingClaimStore) Claim(ctx context.Context, key string, ttl time.Duration) (*IdempotencyRecord, error) {
+	return nil, s.err
+}
+
+func TestIdempotencyClaimErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{"key deleted while claiming", fmt.Errorf("claim: %w", database.ErrIdempotencyKeyContended), http.StatusServiceUnavailable, "1"},
+		{"database down", errors.New("connection refused"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		handler := Idempotency(failingClaimStore{err: tt.err}, time.Hour, 1<<10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("%s: the handler ran without a claimed key", tt.name)
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("v1"))
+		req.Header.Set("Idempotency-Key", "a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status || rec.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("%s: status = %d, Retry-After = %q, want %d, %q", tt.name, rec.Code, rec.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+const PlatformAndroid = "android"
+
+//ArtifactType describes the kind of file a release ships
+type ArtifactType struct {
+	Platform    string `json:"platform"` //e.g. "android", "windows", "linux"
+	Extension   string `json:"extension"`
+	ContentType string `json:"content_type"`
+}
+
+var ArtifactTypeAPK = ArtifactType{PlatformAndroid, ".apk", "application/vnd.android.package-archive"}
+
+//artifactTypes are the types an upload can declare. Archives have no
+//platform of their own, uploads of those have to name one.
+var artifactTypes = map[string]ArtifactType{
+	"apk":      ArtifactTypeAPK,
+	"exe":      {"windows", ".exe", "application/vnd.microsoft.portable-executable"},
+	"msi":      {"windows", ".msi", "application/x-msi"},
+	"msix":     {"windows", ".msix", "application/msix"},
+	"dmg":      {"macos", ".dmg", "application/x-apple-diskimage"},
+	"pkg":      {"macos", ".pkg", "application/octet-stream"},
+	"appimage": {"linux", ".AppImage", "application/vnd.appimage"},
+	"deb":      {"linux", ".deb", "application/vnd.debian.binary-package"},
+	"rpm":      {"linux", ".rpm", "application/x-rpm"},
+	"flatpak":  {"linux", ".flatpak", "application/vnd.flatpak"},
+	"zip":      {"", ".zip", "application/zip"},
+	"tar.gz":   {"", ".tar.gz", "application/gzip"},
+}
+
+var platformPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,19}$`)
+
+//ResolveArtifactType returns the type of an upload. name defaults to "apk",
+//platform is required for archives and has to match the type otherwise.
+func ResolveArtifactType(name, platform string) (ArtifactType, error) {
+	if name == "" {
+		name = "apk"
+	}
+	t, ok := artifactTypes[name]
+	if !ok {
+		return ArtifactType{}, fmt.Errorf("unknown artifact_type %q, expected one of %v", name, ArtifactTypeNames())
+	}
+	switch {
+	case t.Platform == "" && platform == "":
+		return ArtifactType{}, fmt.Errorf("artifact_type %s requires a platform", name)
+	case t.Platform == "" && !platformPattern.MatchString(platform):
+		return ArtifactType{}, fmt.Errorf("invalid platform %q", platform)
+	case t.Platform == "":
+		t.Platform = platform
+	case platform != "" && platform != t.Platform:
+		return ArtifactType{}, fmt.Errorf("artifact_type %s is a %s artifact, not %s", name, t.Platform, platform)
+	}
+	return t, nil
+}
+
+//ArtifactTypeNames lists the known artifact types, sorted
+func ArtifactTypeNames() []string {
+	names := make([]string, 0, len(artifactTypes))
+	for name := range artifactTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
\ No newline at end of file
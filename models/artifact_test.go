@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestResolveArtifactType(t *testing.T) {
+	tests := []struct {
+		name, platform string
+		want           ArtifactType
+		wantErr        bool
+	}{
+		{"", "", ArtifactTypeAPK, false},
+		{"apk", "android", ArtifactTypeAPK, false},
+		{"deb", "", ArtifactType{"linux", ".deb", "application/vnd.debian.binary-package"}, false},
+		{"appimage", "linux", ArtifactType{"linux", ".AppImage", "application/vnd.appimage"}, false},
+		{"zip", "windows", ArtifactType{"windows", ".zip", "application/zip"}, false},
+		{"tar.gz", "freebsd", ArtifactType{"freebsd", ".tar.gz", "application/gzip"}, false},
+		{"zip", "", ArtifactType{}, true},
+		{"zip", "Windows 11", ArtifactType{}, true},
+		{"deb", "windows", ArtifactType{}, true},
+		{"apk", "ios", ArtifactType{}, true},
+		{"ipa", "", ArtifactType{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveArtifactType(tt.name, tt.platform)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveArtifactType(%q, %q) = %+v, %v, want %+v, error %v", tt.name, tt.platform, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestArtifactTypeNames(t *testing.T) {
+	names := ArtifactTypeNames()
+	if !slices.IsSorted(names) || len(names) != len(artifactTypes) {
+		t.Errorf("ArtifactTypeNames = %v", names)
+	}
+	//every listed type can be uploaded
+	for _, name := range names {
+		platform := artifactTypes[name].Platform
+		if platform == "" {
+			platform = "linux"
+		}
+		if _, err := ResolveArtifactType(name, platform); err != nil {
+			t.Errorf("ResolveArtifactType(%s, %s): %v", name, platform, err)
+		}
+	}
+}
+
+func TestNormalizeArtifactType(t *testing.T) {
+	deb := ArtifactType{"linux", ".deb", "application/vnd.debian.binary-package"}
+
+	tests := []struct {
+		name string
+		data string
+		want ArtifactType
+		file ArtifactType //of the first artifact
+	}{
+		{"release from before artifact types", `{"file_name": "a.apk"}`, ArtifactTypeAPK, ArtifactType{}},
+		{"splits from before artifact types", `{"file_name": "a.apk", "artifacts": [{"file_name": "a.apk"}]}`, ArtifactTypeAPK, ArtifactTypeAPK},
+		{"typed release", `{"file_name": "a.deb", "platform": "linux", "extension": ".deb", "content_type": "application/vnd.debian.binary-package", "artifacts": [{"file_name": "a.deb", "platform": "linux", "extension": ".deb", "content_type": "application/vnd.debian.binary-package"}]}`, deb, deb},
+	}
+	for _, tt := range tests {
+		var v VersionInfo
+		if err := json.Unmarshal([]byte(tt.data), &v); err != nil {
+			t.Fatal(err)
+		}
+		v.normalize()
+		if v.ArtifactType != tt.want {
+			t.Errorf("%s: type = %+v, want %+v", tt.name, v.ArtifactType, tt.want)
+		}
+		if len(v.Artifacts) > 0 && v.Artifacts[0].ArtifactType != tt.file {
+			t.Errorf("%s: artifact type = %+v, want %+v", tt.name, v.Artifacts[0].ArtifactType, tt.file)
+		}
+		if files := v.Files(); files[0].ArtifactType != tt.want {
+			t.Errorf("%s: Files()[0] type = %+v, want %+v", tt.name, files[0].ArtifactType, tt.want)
+		}
+	}
+}
\ No newline at end of file
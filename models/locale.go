@@ -0,0 +1,63 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+//CanonicalLocale normalizes a BCP 47 language tag, e.g. "pt_br" becomes
+//"pt-BR" and "zh-hant-tw" becomes "zh-Hant-TW". ok is false for malformed
+//tags.
+func CanonicalLocale(tag string) (string, bool) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if !localePattern.MatchString(tag) {
+		return "", false
+	}
+	parts := strings.Split(tag, "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i])
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return strings.Join(parts, "-"), true
+}
+
+//MatchLocale picks the available locale that best serves a client preferring
+//the given locales, most preferred first. A preference matches exactly, then
+//by falling back to shorter tags (de-AT to de), then any locale of the same
+//language (pt to pt-BR). Returns "" if none of the preferences match.
+func MatchLocale(prefs []string, available []string) string {
+	for _, pref := range prefs {
+		pref, ok := CanonicalLocale(pref)
+		if !ok {
+			continue
+		}
+		for tag := pref; tag != ""; {
+			for _, locale := range available {
+				if locale == tag {
+					return locale
+				}
+			}
+			if i := strings.LastIndex(tag, "-"); i > 0 {
+				tag = tag[:i]
+			} else {
+				tag = ""
+			}
+		}
+		language, _, _ := strings.Cut(pref, "-")
+		for _, locale := range available {
+			if strings.HasPrefix(locale, language+"-") {
+				return locale
+			}
+		}
+	}
+	return ""
+}
\ No newline at end of file
@@ -0,0 +1,92 @@
+package models
+
+import "testing"
+
+func TestCanonicalLocale(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"de", "de", true},
+		{"EN", "en", true},
+		{"pt_br", "pt-BR", true},
+		{"pt-br", "pt-BR", true},
+		{"zh-hant-tw", "zh-Hant-TW", true},
+		{"sr-LATN", "sr-Latn", true},
+		{"es-419", "es-419", true},
+		{" fr-CA ", "fr-CA", true},
+		{"", "", false},
+		{"e", "", false},
+		{"de-", "", false},
+		{"en--US", "", false},
+		{"1en", "", false},
+		{"de;q=0.8", "", false},
+		{"x-very-long-subtag-toolong9", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := CanonicalLocale(tt.tag)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("CanonicalLocale(%q) = %q, %v, want %q, %v", tt.tag, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	available := []string{"de", "en", "pt-BR", "pt-PT", "zh-Hant"}
+
+	tests := []struct {
+		name  string
+		prefs []string
+		want  string
+	}{
+		{"exact", []string{"de"}, "de"},
+		{"case and separator", []string{"pt_br"}, "pt-BR"},
+		{"shorter tag", []string{"de-AT"}, "de"},
+		{"shorter tag with script", []string{"zh-Hant-TW"}, "zh-Hant"},
+		{"same language", []string{"pt"}, "pt-BR"},
+		{"same language, other region", []string{"pt-AO"}, "pt-BR"},
+		{"first preference wins", []string{"fr", "en", "de"}, "en"},
+		{"malformed preference is skipped", []string{"??", "de"}, "de"},
+		{"no match", []string{"fr", "ja"}, ""},
+		{"no preferences", nil, ""},
+		{"language prefix is not a language", []string{"p"}, ""},
+	}
+	for _, tt := range tests {
+		if got := MatchLocale(tt.prefs, available); got != tt.want {
+			t.Errorf("%s: MatchLocale(%v) = %q, want %q", tt.name, tt.prefs, got, tt.want)
+		}
+	}
+}
+
+func TestNotesFor(t *testing.T) {
+	v := &VersionInfo{
+		ReleaseNotes:   "Bug fixes",
+		DefaultLocale:  "en",
+		LocalizedNotes: map[string]string{"en": "Bug fixes", "de": "Fehlerbehebungen", "pt-BR": "Correções"},
+	}
+
+	tests := []struct {
+		prefs  []string
+		notes  string
+		locale string
+	}{
+		{[]string{"de-CH"}, "Fehlerbehebungen", "de"},
+		{[]string{"pt"}, "Correções", "pt-BR"},
+		{[]string{"fr", "de"}, "Fehlerbehebungen", "de"},
+		{[]string{"fr"}, "Bug fixes", "en"},
+		{nil, "Bug fixes", "en"},
+	}
+	for _, tt := range tests {
+		notes, locale := v.NotesFor(tt.prefs)
+		if notes != tt.notes || locale != tt.locale {
+			t.Errorf("NotesFor(%v) = %q, %q, want %q, %q", tt.prefs, notes, locale, tt.notes, tt.locale)
+		}
+	}
+
+	//releases without translations
+	plain := &VersionInfo{ReleaseNotes: "Bug fixes"}
+	if notes, locale := plain.NotesFor([]string{"de"}); notes != "Bug fixes" || locale != "" {
+		t.Errorf("NotesFor without translations = %q, %q", notes, locale)
+	}
+}
\ No newline at end of file
@@ -1,8 +1,15 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,10 +22,35 @@ const (
 	ChannelNightly Channel = "nightly"
 )
 
-func (c Channel) IsValid() bool {
-	return c == ChannelStable || c == ChannelBeta || c == ChannelNightly
+var channelNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,19}$`)
+
+//IsWellFormed reports whether c can be used as a channel name. Whether the
+//channel actually exists is up to the VersionStore.
+func (c Channel) IsWellFormed() bool {
+	return channelNamePattern.MatchString(string(c))
 }
 
+type Visibility string
+
+const (
+	VisibilityPublic Visibility = "public"
+	VisibilityHidden Visibility = "hidden"
+)
+
+func (v Visibility) IsValid() bool {
+	return v == VisibilityPublic || v == VisibilityHidden
+}
+
+var (
+	ErrReleaseNotFound   = errors.New("release not found")
+	ErrNoPreviousRelease = errors.New("no previous release available")
+	ErrChannelExists     = errors.New("channel already exists")
+	ErrChannelNotFound   = errors.New("channel not found")
+	ErrReleaseYanked     = errors.New("release has been yanked")
+
+	ErrVersionCodeMismatch = errors.New("release exists with a different version code")
+)
+
 type VersionInfo struct {
 	Channel      Channel   `json:"channel"`
 	Version      string    `json:"version"`
@@ -29,18 +61,343 @@ type VersionInfo struct {
 	ReleaseNotes string    `json:"release_notes"`
 	PublishedAt  time.Time `json:"published_at"`
 	FileName     string    `json:"file_name"`
+	PromotedFrom Channel   `json:"promoted_from,omitempty"`
+
+	//translations of the release notes keyed by locale, ReleaseNotes holds
+	//the notes in DefaultLocale
+	LocalizedNotes map[string]string `json:"localized_release_notes,omitempty"`
+	DefaultLocale  string            `json:"default_locale,omitempty"`
+
+	//percentage of devices that receive this release, nil means everyone
+	RolloutPercentage *int `json:"rollout_percentage,omitempty"`
+
+	//clients must install this release before they can keep using the app
+	Mandatory bool `json:"mandatory"`
+
+	//device requirements, empty values mean no restriction
+	MinSdk int      `json:"min_sdk,omitempty"`
+	ABIs   []string `json:"abis,omitempty"`
+
+	//read from the APK manifest on upload
+	PackageName string `json:"package_name,omitempty"`
+	TargetSdk   int    `json:"target_sdk,omitempty"`
+
+	//SHA-256 fingerprint of the signing certificate
+	SignerSHA256 string `json:"signer_sha256,omitempty"`
+
+	//every file of the release. The top level file fields and type describe
+	//the universal artifact, or the first split if there is none.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	ArtifactType
+
+	//set while a release waits for its scheduled publish time
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+
+	//set once a release has been revoked, e.g. for a data-corrupting bug
+	Yanked *YankInfo `json:"yanked,omitempty"`
+}
+
+type YankInfo struct {
+	Reason             string    `json:"reason"`
+	ReplacementVersion string    `json:"replacement_version,omitempty"`
+	YankedAt           time.Time `json:"yanked_at"`
+}
+
+func (v *VersionInfo) IsYanked() bool {
+	return v.Yanked != nil
+}
+
+//NotesFor returns the release notes for a client preferring the given
+//locales, most preferred first, and the locale they are in. Without a
+//matching translation the default notes are returned.
+func (v *VersionInfo) NotesFor(prefs []string) (string, string) {
+	locales := make([]string, 0, len(v.LocalizedNotes))
+	for locale := range v.LocalizedNotes {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	if locale := MatchLocale(prefs, locales); locale != "" {
+		return v.LocalizedNotes[locale], locale
+	}
+	return v.ReleaseNotes, v.DefaultLocale
+}
+
+//Artifact is one file of a release. Releases with ABI or density splits, or
+//builds for several platforms, have several; one without ABI and density is
+//universal.
+type Artifact struct {
+	FileName     string `json:"file_name"`
+	FileSize     int64  `json:"file_size"`
+	SHA256       string `json:"sha256"`
+	ABI          string `json:"abi,omitempty"`     //e.g. "arm64-v8a"
+	Density      string `json:"density,omitempty"` //e.g. "xxhdpi"
+	MinSdk       int    `json:"min_sdk,omitempty"`
+	SignerSHA256 string `json:"signer_sha256,omitempty"`
+	ArtifactType
+}
+
+//normalize fills in the type of releases saved by older versions, which were
+//always APKs
+func (v *VersionInfo) normalize() {
+	for i := range v.Artifacts {
+		if v.Artifacts[i].ArtifactType == (ArtifactType{}) {
+			v.Artifacts[i].ArtifactType = ArtifactTypeAPK
+		}
+		if v.ArtifactType == (ArtifactType{}) && v.Artifacts[i].FileName == v.FileName {
+			v.ArtifactType = v.Artifacts[i].ArtifactType
+		}
+	}
+	if v.ArtifactType == (ArtifactType{}) {
+		v.ArtifactType = ArtifactTypeAPK
+	}
+}
+
+func (a *Artifact) IsUniversal() bool {
+	return a.ABI == "" && a.Density == ""
+}
+
+//Split names the ABI and density of an artifact, empty if it is universal
+func (a *Artifact) Split() string {
+	return strings.Trim(a.ABI+"-"+a.Density, "-")
+}
+
+//Replaces reports whether a takes the place of b when added to a release
+func (a *Artifact) Replaces(b *Artifact) bool {
+	return a.Platform == b.Platform && a.Extension == b.Extension && a.Split() == b.Split()
+}
+
+//Files returns the artifacts of a release. Releases published before splits
+//were supported only have the top level file.
+func (v *VersionInfo) Files() []Artifact {
+	if len(v.Artifacts) > 0 || v.FileName == "" {
+		return v.Artifacts
+	}
+	return []Artifact{{
+		FileName:     v.FileName,
+		FileSize:     v.FileSize,
+		SHA256:       v.SHA256,
+		MinSdk:       v.MinSdk,
+		SignerSHA256: v.SignerSHA256,
+		ArtifactType: v.ArtifactType,
+	}}
+}
+
+//SetArtifact adds an artifact to the release, replacing the one with the same
+//type, ABI and density, and updates the top level file fields
+func (v *VersionInfo) SetArtifact(artifact Artifact) {
+	//copied, v may share its artifacts with a stored release
+	artifacts := append([]Artifact(nil), v.Files()...)
+	replaced := false
+	for i := range artifacts {
+		if artifact.Replaces(&artifacts[i]) {
+			artifacts[i] = artifact
+			replaced = true
+		}
+	}
+	if !replaced {
+		artifacts = append(artifacts, artifact)
+	}
+	v.Artifacts = artifacts
+
+	primary := artifacts[0]
+	v.MinSdk = primary.MinSdk
+	for i := len(artifacts) - 1; i >= 0; i-- {
+		if artifacts[i].IsUniversal() {
+			primary = artifacts[i]
+		}
+		v.MinSdk = min(v.MinSdk, artifacts[i].MinSdk)
+	}
+	v.FileName = primary.FileName
+	v.FileSize = primary.FileSize
+	v.SHA256 = primary.SHA256
+	v.SignerSHA256 = primary.SignerSHA256
+	v.ArtifactType = primary.ArtifactType
+}
+
+//SelectArtifact picks the file for a device: only artifacts of its platform
+//are considered, which defaults to the platform of the release's main file.
+//A matching ABI split beats a universal artifact, a matching density beats a
+//density neutral one, and among equals the highest min_sdk the device meets
+//wins. Splits for an unknown ABI or density (empty) never match. Returns nil
+//if nothing fits the device.
+func (v *VersionInfo) SelectArtifact(platform, abi, density string, sdk int) *Artifact {
+	if platform == "" {
+		platform = v.Platform
+	}
+	var best *Artifact
+	bestScore := -1
+	artifacts := v.Files()
+	for i := range artifacts {
+		a := &artifacts[i]
+		if a.Platform != platform || (a.ABI != "" && a.ABI != abi) || (a.Density != "" && a.Density != density) {
+			continue
+		}
+		if sdk > 0 && a.MinSdk > sdk {
+			continue
+		}
+
+		score := 0
+		if a.ABI != "" {
+			score += 2
+		}
+		if a.Density != "" {
+			score++
+		}
+		if score > bestScore || (score == bestScore && a.MinSdk > best.MinSdk) {
+			best, bestScore = a, score
+		}
+	}
+	return best
+}
+
+//SupportsDevice reports whether a device with the given SDK level and ABI can
+//install this release. Unknown device properties (0 or "") are not checked.
+func (v *VersionInfo) SupportsDevice(sdk int, abi string) bool {
+	if sdk > 0 && v.MinSdk > sdk {
+		return false
+	}
+	//a release made of ABI splits needs one for the device
+	if abi != "" && !v.hasArtifactFor(abi, sdk) {
+		return false
+	}
+	if abi == "" || len(v.ABIs) == 0 {
+		return true
+	}
+	for _, supported := range v.ABIs {
+		if supported == abi {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *VersionInfo) hasArtifactFor(abi string, sdk int) bool {
+	for _, a := range v.Files() {
+		if (a.ABI == "" || a.ABI == abi) && (sdk <= 0 || a.MinSdk <= sdk) {
+			return true
+		}
+	}
+	return len(v.Files()) == 0
+}
+
+type ChannelSettings struct {
+	DisplayName string     `json:"display_name"`
+	Visibility  Visibility `json:"visibility"`
+
+	//number of releases kept in history and storage, 0 keeps everything
+	Retention int `json:"retention"`
+
+	//clients below this version_code are blocked until they update
+	MinSupportedVersionCode int `json:"min_supported_version_code"`
+
+	//SHA-256 fingerprints of the certificates releases must be signed with,
+	//empty accepts any signer
+	AllowedSigners []string `json:"allowed_signers,omitempty"`
+}
+
+//normalize fills in defaults for settings saved by older versions
+func (c *ChannelSettings) normalize(channel Channel) {
+	if c.DisplayName == "" {
+		c.DisplayName = strings.ToUpper(string(channel[:1])) + string(channel[1:])
+	}
+	if !c.Visibility.IsValid() {
+		c.Visibility = VisibilityPublic
+	}
+	if c.Retention < 0 {
+		c.Retention = 0
+	}
+	for i, fingerprint := range c.AllowedSigners {
+		if normalized, ok := NormalizeFingerprint(fingerprint); ok {
+			c.AllowedSigners[i] = normalized
+		}
+	}
+}
+
+//AllowsSigner reports whether releases signed by the certificate with the
+//given fingerprint may be published to the channel
+func (c *ChannelSettings) AllowsSigner(fingerprint string) bool {
+	if len(c.AllowedSigners) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedSigners {
+		if allowed == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+//NormalizeFingerprint accepts a SHA-256 certificate fingerprint as printed by
+//keytool (uppercase, colon separated) or apksigner and returns it as
+//lowercase hex
+func NormalizeFingerprint(fingerprint string) (string, bool) {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+	if len(normalized) != 64 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(normalized); err != nil {
+		return "", false
+	}
+	return normalized, true
+}
+
+type ChannelInfo struct {
+	Name Channel `json:"name"`
+	ChannelSettings
+}
+
+func (v *VersionInfo) Rollout() int {
+	if v.RolloutPercentage == nil {
+		return 100
+	}
+	return *v.RolloutPercentage
+}
+
+//InRollout reports whether the device identified by clientID falls into the
+//staged rollout of this release. Buckets are derived from the release and the
+//client ID, so raising the percentage only ever adds devices.
+func (v *VersionInfo) InRollout(clientID string) bool {
+	percentage := v.Rollout()
+	if percentage >= 100 {
+		return true
+	}
+	if percentage <= 0 || clientID == "" {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(string(v.Channel) + ":" + v.Version + ":" + clientID))
+	bucket := binary.BigEndian.Uint32(hash[:4]) % 100
+	return int(bucket) < percentage
 }
 
 type VersionStore struct {
 	mu       sync.RWMutex
 	filePath string
-	Versions map[Channel]*VersionInfo `json:"versions"`
+	Versions map[Channel]*VersionInfo     `json:"versions"`
+	Releases map[Channel][]*VersionInfo   `json:"releases"`
+	Channels map[Channel]*ChannelSettings `json:"channels"`
+
+	//releases waiting for their publish time, not visible to clients
+	Scheduled map[Channel][]*VersionInfo `json:"scheduled"`
+}
+
+//file layout of versions.json
+type versionsFile struct {
+	Versions map[Channel]*VersionInfo     `json:"versions"`
+	Releases map[Channel][]*VersionInfo   `json:"releases"`
+	Channels map[Channel]*ChannelSettings `json:"channels,omitempty"`
+
+	Scheduled map[Channel][]*VersionInfo `json:"scheduled,omitempty"`
 }
 
 func NewVersionStore(filePath string) (*VersionStore, error) {
 	store := &VersionStore{
 		filePath: filePath,
 		Versions: make(map[Channel]*VersionInfo),
+		Releases: make(map[Channel][]*VersionInfo),
+		Channels: make(map[Channel]*ChannelSettings),
+
+		Scheduled: make(map[Channel][]*VersionInfo),
 	}
 
 	if err := store.load(); err != nil {
@@ -61,22 +418,72 @@ func (s *VersionStore) load() error {
 		return err
 	}
 
-	var fileData struct {
-		Versions map[Channel]*VersionInfo `json:"versions"`
-	}
+	var fileData versionsFile
 	if err := json.Unmarshal(data, &fileData); err != nil {
 		return err
 	}
 
-	s.Versions = fileData.Versions
+	if fileData.Versions != nil {
+		s.Versions = fileData.Versions
+	}
+	if fileData.Releases != nil {
+		s.Releases = fileData.Releases
+	}
+	if fileData.Channels != nil {
+		s.Channels = fileData.Channels
+	}
+	if fileData.Scheduled != nil {
+		s.Scheduled = fileData.Scheduled
+	}
+
+	//channels used before channels were configurable stay available
+	for channel := range s.Releases {
+		if s.Channels[channel] == nil {
+			s.Channels[channel] = &ChannelSettings{}
+		}
+	}
+	for channel, settings := range s.Channels {
+		settings.normalize(channel)
+	}
+	for _, releases := range s.Releases {
+		for _, info := range releases {
+			info.normalize()
+		}
+	}
+	for _, pending := range s.Scheduled {
+		for _, info := range pending {
+			info.normalize()
+		}
+	}
+	for _, info := range s.Versions {
+		info.normalize()
+	}
+
+	//files written before history was tracked only hold the latest release,
+	//otherwise point the channel at its history entry so updates hit both
+	for channel, info := range s.Versions {
+		if len(s.Releases[channel]) == 0 {
+			s.Releases[channel] = []*VersionInfo{info}
+			continue
+		}
+		for _, release := range s.Releases[channel] {
+			if release.Version == info.Version {
+				s.Versions[channel] = release
+				break
+			}
+		}
+	}
+
 	return nil
 }
 
 func (s *VersionStore) save() error {
-	data, err := json.MarshalIndent(struct {
-		Versions map[Channel]*VersionInfo `json:"versions"`
-	}{
+	data, err := json.MarshalIndent(versionsFile{
 		Versions: s.Versions,
+		Releases: s.Releases,
+		Channels: s.Channels,
+
+		Scheduled: s.Scheduled,
 	}, "", "  ")
 	if err != nil {
 		return err
@@ -96,6 +503,554 @@ func (s *VersionStore) Set(info *VersionInfo) error {
 	defer s.mu.Unlock()
 
 	s.Versions[info.Channel] = info
+	s.appendHistory(info)
+	//publishing directly supersedes a pending release of the same version
+	s.removeScheduled(info.Channel, info.Version)
+	return s.save()
+}
+
+//Schedule stores a release that becomes current once info.PublishAt has
+//passed. A pending release of the same version is replaced.
+func (s *VersionStore) Schedule(info *VersionInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := s.removeScheduled(info.Channel, info.Version)
+	s.Scheduled[info.Channel] = append(pending, info)
+	return s.save()
+}
+
+//removeScheduled drops a pending release and returns the remaining ones
+func (s *VersionStore) removeScheduled(channel Channel, version string) []*VersionInfo {
+	pending := s.Scheduled[channel]
+	for i, info := range pending {
+		if info.Version == version {
+			pending = append(pending[:i:i], pending[i+1:]...)
+			break
+		}
+	}
+	s.Scheduled[channel] = pending
+	return pending
+}
+
+//ListScheduled returns the pending releases of a channel, or of every
+//channel when channel is empty, ordered by publish time
+func (s *VersionStore) ListScheduled(channel Channel) []*VersionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pending := []*VersionInfo{}
+	for ch, releases := range s.Scheduled {
+		if channel == "" || ch == channel {
+			pending = append(pending, releases...)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].PublishAt.Before(*pending[j].PublishAt)
+	})
+	return pending
+}
+
+func (s *VersionStore) GetScheduled(channel Channel, version string) *VersionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findScheduled(channel, version)
+}
+
+func (s *VersionStore) Reschedule(channel Channel, version string, publishAt time.Time) (*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := s.findScheduled(channel, version)
+	if info == nil {
+		return nil, ErrReleaseNotFound
+	}
+	info = s.modify(info, func(v *VersionInfo) {
+		v.PublishAt = &publishAt
+	})
+	return info, s.save()
+}
+
+func (s *VersionStore) CancelScheduled(channel Channel, version string) (*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := s.findScheduled(channel, version)
+	if info == nil {
+		return nil, ErrReleaseNotFound
+	}
+	s.removeScheduled(channel, version)
+	return info, s.save()
+}
+
+func (s *VersionStore) findScheduled(channel Channel, version string) *VersionInfo {
+	for _, info := range s.Scheduled[channel] {
+		if info.Version == version {
+			return info
+		}
+	}
+	return nil
+}
+
+//ActivateDue publishes every pending release whose publish time has passed
+//and returns them in publish order
+func (s *VersionStore) ActivateDue(now time.Time) ([]*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*VersionInfo
+	for channel, pending := range s.Scheduled {
+		var waiting []*VersionInfo
+		for _, info := range pending {
+			if info.PublishAt.After(now) {
+				waiting = append(waiting, info)
+			} else {
+				due = append(due, info)
+			}
+		}
+		s.Scheduled[channel] = waiting
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].PublishAt.Before(*due[j].PublishAt)
+	})
+	for i, pending := range due {
+		info := *pending
+		info.PublishedAt = now.UTC()
+		info.PublishAt = nil
+		due[i] = &info
+		s.Versions[info.Channel] = &info
+		s.appendHistory(&info)
+	}
+	return due, s.save()
+}
+
+//appendHistory adds info as the newest release of its channel, replacing an
+//earlier entry for the same version (re-uploads overwrite the artifact too)
+func (s *VersionStore) appendHistory(info *VersionInfo) {
+	history := s.Releases[info.Channel]
+	for i, existing := range history {
+		if existing.Version == info.Version {
+			history = append(history[:i:i], history[i+1:]...)
+			break
+		}
+	}
+	s.Releases[info.Channel] = append(history, info)
+}
+
+//History returns every known release of a channel, newest first
+func (s *VersionStore) History(channel Channel) []*VersionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.Releases[channel]
+	releases := make([]*VersionInfo, len(history))
+	for i, info := range history {
+		releases[len(history)-1-i] = info
+	}
+	return releases
+}
+
+func (s *VersionStore) GetVersion(channel Channel, version string) *VersionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, info := range s.Releases[channel] {
+		if info.Version == version {
+			return info
+		}
+	}
+	return nil
+}
+
+//Resolve returns the release a device should receive. Devices outside the
+//staged rollout of the current release get the newest earlier release they
+//are eligible for.
+func (s *VersionStore) Resolve(channel Channel, clientID string) *VersionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	current := s.Versions[channel]
+	if current == nil || current.InRollout(clientID) {
+		return current
+	}
+
+	history := s.Releases[channel]
+	position := -1
+	for i, info := range history {
+		if info.Version == current.Version {
+			position = i
+			break
+		}
+	}
+	for i := position - 1; i >= 0; i-- {
+		if !history[i].IsYanked() && history[i].InRollout(clientID) {
+			return history[i]
+		}
+	}
+	return nil
+}
+
+//Yank revokes a release. If it is the current release of its channel, the
+//channel falls back to the newest earlier release that isn't yanked.
+func (s *VersionStore) Yank(channel Channel, version string, yank YankInfo) (*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := s.Releases[channel]
+	position := -1
+	for i, info := range history {
+		if info.Version == version {
+			position = i
+			break
+		}
+	}
+	if position == -1 {
+		return nil, ErrReleaseNotFound
+	}
+
+	info := s.modify(history[position], func(v *VersionInfo) {
+		v.Yanked = &yank
+	})
+
+	if current := s.Versions[channel]; current != nil && current.Version == version {
+		delete(s.Versions, channel)
+		for i := position - 1; i >= 0; i-- {
+			if !history[i].IsYanked() {
+				s.Versions[channel] = history[i]
+				break
+			}
+		}
+	}
+
+	return info, s.save()
+}
+
+//FindByVersionCode returns the newest release of a channel with the given
+//version_code
+func (s *VersionStore) FindByVersionCode(channel Channel, versionCode int) *VersionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.Releases[channel]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].VersionCode == versionCode {
+			return history[i]
+		}
+	}
+	return nil
+}
+
+//modify applies fn to a copy of a stored release and swaps the copy in
+//wherever the original is referenced. Stored releases are never changed in
+//place because handlers read them without holding the lock.
+func (s *VersionStore) modify(info *VersionInfo, fn func(*VersionInfo)) *VersionInfo {
+	updated := *info
+	fn(&updated)
+
+	channel := info.Channel
+	for i, existing := range s.Releases[channel] {
+		if existing == info {
+			s.Releases[channel][i] = &updated
+		}
+	}
+	for i, existing := range s.Scheduled[channel] {
+		if existing == info {
+			s.Scheduled[channel][i] = &updated
+		}
+	}
+	if s.Versions[channel] == info {
+		s.Versions[channel] = &updated
+	}
+	return &updated
+}
+
+//SetRollout changes the rollout percentage of a release
+func (s *VersionStore) SetRollout(channel Channel, version string, percentage int) (*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, info := range s.Releases[channel] {
+		if info.Version == version {
+			info = s.modify(info, func(v *VersionInfo) {
+				if percentage >= 100 {
+					v.RolloutPercentage = nil
+				} else {
+					v.RolloutPercentage = &percentage
+				}
+			})
+			return info, s.save()
+		}
+	}
+	return nil, ErrReleaseNotFound
+}
+
+//SetMandatory flags or unflags a release as a mandatory update
+func (s *VersionStore) SetMandatory(channel Channel, version string, mandatory bool) (*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, info := range s.Releases[channel] {
+		if info.Version == version {
+			info = s.modify(info, func(v *VersionInfo) {
+				v.Mandatory = mandatory
+			})
+			return info, s.save()
+		}
+	}
+	return nil, ErrReleaseNotFound
+}
+
+func (s *VersionStore) HasChannel(channel Channel) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Channels[channel] != nil
+}
+
+func (s *VersionStore) ChannelSettings(channel Channel) ChannelSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if settings := s.Channels[channel]; settings != nil {
+		return *settings
+	}
+	return ChannelSettings{}
+}
+
+//ListChannels returns every registered channel sorted by name. Hidden
+//channels are only included when includeHidden is set.
+func (s *VersionStore) ListChannels(includeHidden bool) []ChannelInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make([]ChannelInfo, 0, len(s.Channels))
+	for name, settings := range s.Channels {
+		if settings.Visibility == VisibilityHidden && !includeHidden {
+			continue
+		}
+		channels = append(channels, ChannelInfo{Name: name, ChannelSettings: *settings})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
+
+//CreateChannel registers a new channel
+func (s *VersionStore) CreateChannel(channel Channel, settings ChannelSettings) (ChannelSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Channels[channel] != nil {
+		return ChannelSettings{}, ErrChannelExists
+	}
+	settings.normalize(channel)
+	s.Channels[channel] = &settings
+	return settings, s.save()
+}
+
+//EnsureChannel registers a channel from configuration. Existing channels
+//take the configured display name, visibility and retention but keep their
+//version policy.
+func (s *VersionStore) EnsureChannel(channel Channel, settings ChannelSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing := s.Channels[channel]; existing != nil {
+		settings.MinSupportedVersionCode = existing.MinSupportedVersionCode
+		if len(settings.AllowedSigners) == 0 {
+			settings.AllowedSigners = existing.AllowedSigners
+		}
+	}
+	settings.normalize(channel)
+	s.Channels[channel] = &settings
+	return s.save()
+}
+
+//UpdateChannel applies fn to the settings of a channel and persists them
+func (s *VersionStore) UpdateChannel(channel Channel, fn func(*ChannelSettings)) (ChannelSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := s.Channels[channel]
+	if settings == nil {
+		return ChannelSettings{}, ErrChannelNotFound
+	}
+	fn(settings)
+	settings.normalize(channel)
+	return *settings, s.save()
+}
+
+//Prune drops the oldest releases of a channel beyond its retention limit and
+//returns them. The current release is never pruned.
+func (s *VersionStore) Prune(channel Channel) ([]*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := s.Channels[channel]
+	history := s.Releases[channel]
+	if settings == nil || settings.Retention <= 0 || len(history) <= settings.Retention {
+		return nil, nil
+	}
+
+	current := s.Versions[channel]
+	excess := len(history) - settings.Retention
+	var kept, pruned []*VersionInfo
+	for _, info := range history {
+		if excess > 0 && (current == nil || info.Version != current.Version) {
+			pruned = append(pruned, info)
+			excess--
+			continue
+		}
+		kept = append(kept, info)
+	}
+	if len(pruned) == 0 {
+		return nil, nil
+	}
+
+	s.Releases[channel] = kept
+	return pruned, s.save()
+}
+
+//ArtifactInUse reports whether any release of any channel still references
+//the storage key, e.g. after a promotion without copying
+func (s *VersionStore) ArtifactInUse(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, history := range s.Releases {
+		for _, info := range history {
+			for _, artifact := range info.Files() {
+				if artifact.FileName == key {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+//AddArtifact adds a split APK to an existing or scheduled release with the
+//same version code, see VersionInfo.SetArtifact
+func (s *VersionStore) AddArtifact(channel Channel, version string, versionCode int, artifact Artifact) (*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := s.findScheduled(channel, version)
+	if info == nil {
+		for _, existing := range s.Releases[channel] {
+			if existing.Version == version {
+				info = existing
+			}
+		}
+	}
+	if info == nil {
+		return nil, ErrReleaseNotFound
+	}
+	if info.VersionCode != versionCode {
+		return nil, ErrVersionCodeMismatch
+	}
+
+	info = s.modify(info, func(v *VersionInfo) {
+		v.SetArtifact(artifact)
+	})
+	return info, s.save()
+}
+
+//RollbackTarget resolves the release a rollback of channel would switch to.
+//An empty version selects the release published before the current one.
+func (s *VersionStore) RollbackTarget(channel Channel, version string) (*VersionInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.Releases[channel]
+	current := s.Versions[channel]
+
+	if version != "" {
+		for _, info := range history {
+			if info.Version == version {
+				if info.IsYanked() {
+					return nil, ErrReleaseYanked
+				}
+				return info, nil
+			}
+		}
+		return nil, ErrReleaseNotFound
+	}
+
+	if current == nil {
+		return nil, ErrNoPreviousRelease
+	}
+	position := -1
+	for i, info := range history {
+		if info.Version == current.Version {
+			position = i
+			break
+		}
+	}
+	for i := position - 1; i >= 0; i-- {
+		if !history[i].IsYanked() {
+			return history[i], nil
+		}
+	}
+	return nil, ErrNoPreviousRelease
+}
+
+//SetCurrent points a channel at a release already in its history without
+//changing the history itself
+func (s *VersionStore) SetCurrent(channel Channel, version string) (*VersionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, info := range s.Releases[channel] {
+		if info.Version == version {
+			s.Versions[channel] = info
+			return info, s.save()
+		}
+	}
+	return nil, ErrReleaseNotFound
+}
+
+//MergeHistory inserts releases that are missing from a channel's history,
+//e.g. ones recorded in the database before the store kept history. Releases
+//are ordered by publish time; the current version is left untouched.
+func (s *VersionStore) MergeHistory(channel Channel, releases []*VersionInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := s.Releases[channel]
+	known := make(map[string]bool, len(history))
+	for _, info := range history {
+		known[info.Version] = true
+	}
+
+	added := 0
+	for _, info := range releases {
+		if known[info.Version] {
+			continue
+		}
+		known[info.Version] = true
+		info.normalize()
+		history = append(history, info)
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+
+	if s.Channels[channel] == nil {
+		settings := &ChannelSettings{}
+		settings.normalize(channel)
+		s.Channels[channel] = settings
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].PublishedAt.Before(history[j].PublishedAt)
+	})
+	s.Releases[channel] = history
 	return s.save()
 }
 
@@ -108,7 +1063,7 @@ type UploadRequest struct {
 }
 
 func (r *UploadRequest) Validate() bool {
-	return r.Channel.IsValid() &&
+	return r.Channel.IsWellFormed() &&
 		r.Version != "" &&
 		r.VersionCode > 0 &&
 		r.ApkURL != ""
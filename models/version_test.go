@@ -0,0 +1,977 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *VersionStore {
+	t.Helper()
+	store, err := NewVersionStore(filepath.Join(t.TempDir(), "versions.json"))
+	if err != nil {
+		t.Fatalf("NewVersionStore: %v", err)
+	}
+	for _, channel := range []Channel{ChannelStable, ChannelBeta, ChannelNightly} {
+		if err := store.EnsureChannel(channel, ChannelSettings{}); err != nil {
+			t.Fatalf("EnsureChannel(%s): %v", channel, err)
+		}
+	}
+	return store
+}
+
+//publish stores a release published at a fixed offset, so history order
+//doesn't depend on the clock
+func publish(t *testing.T, store *VersionStore, channel Channel, version string, versionCode int) *VersionInfo {
+	t.Helper()
+	info := &VersionInfo{
+		Channel:     channel,
+		Version:     version,
+		VersionCode: versionCode,
+		FileName:    string(channel) + "/app-" + version + ".apk",
+		PublishedAt: time.Date(2026, 1, 1, 0, 0, versionCode, 0, time.UTC),
+	}
+	if err := store.Set(info); err != nil {
+		t.Fatalf("Set(%s v%s): %v", channel, version, err)
+	}
+	return info
+}
+
+func versions(releases []*VersionInfo) []string {
+	names := make([]string, len(releases))
+	for i, info := range releases {
+		names[i] = info.Version
+	}
+	return names
+}
+
+func TestHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		publish []string
+		want    []string
+		current string
+	}{
+		{"empty", nil, []string{}, ""},
+		{"single", []string{"1.0.0"}, []string{"1.0.0"}, "1.0.0"},
+		{"newest first", []string{"1.0.0", "1.1.0", "1.2.0"}, []string{"1.2.0", "1.1.0", "1.0.0"}, "1.2.0"},
+		{"re-upload moves to the top", []string{"1.0.0", "1.1.0", "1.0.0"}, []string{"1.0.0", "1.1.0"}, "1.0.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			for i, version := range tt.publish {
+				publish(t, store, ChannelStable, version, i+1)
+			}
+
+			if got := versions(store.History(ChannelStable)); !slices.Equal(got, tt.want) {
+				t.Errorf("History = %v, want %v", got, tt.want)
+			}
+			if len(store.History(ChannelBeta)) != 0 {
+				t.Errorf("History of another channel is not empty")
+			}
+			current := store.Get(ChannelStable)
+			if tt.current == "" {
+				if current != nil {
+					t.Errorf("Get = %s, want nil", current.Version)
+				}
+			} else if current == nil || current.Version != tt.current {
+				t.Errorf("Get = %v, want %s", current, tt.current)
+			}
+		})
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	publish(t, store, ChannelStable, "1.1.0", 2)
+	publish(t, store, ChannelBeta, "2.0.0", 3)
+
+	tests := []struct {
+		channel Channel
+		version string
+		found   bool
+	}{
+		{ChannelStable, "1.0.0", true},
+		{ChannelStable, "1.1.0", true},
+		{ChannelStable, "2.0.0", false},
+		{ChannelBeta, "2.0.0", true},
+		{ChannelNightly, "1.0.0", false},
+	}
+	for _, tt := range tests {
+		info := store.GetVersion(tt.channel, tt.version)
+		if (info != nil) != tt.found {
+			t.Errorf("GetVersion(%s, %s) found = %v, want %v", tt.channel, tt.version, info != nil, tt.found)
+		}
+		if info != nil && (info.Channel != tt.channel || info.Version != tt.version) {
+			t.Errorf("GetVersion(%s, %s) = %s v%s", tt.channel, tt.version, info.Channel, info.Version)
+		}
+	}
+}
+
+func TestHistoryPersists(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	publish(t, store, ChannelStable, "1.1.0", 2)
+
+	reloaded, err := NewVersionStore(store.filePath)
+	if err != nil {
+		t.Fatalf("NewVersionStore: %v", err)
+	}
+	if got, want := versions(reloaded.History(ChannelStable)), []string{"1.1.0", "1.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("History after reload = %v, want %v", got, want)
+	}
+	if current := reloaded.Get(ChannelStable); current == nil || current.Version != "1.1.0" {
+		t.Errorf("Get after reload = %v, want 1.1.0", current)
+	}
+}
+
+func TestLoadWithoutHistory(t *testing.T) {
+	//versions.json as written before history was tracked
+	path := filepath.Join(t.TempDir(), "versions.json")
+	data := `{"versions": {"stable": {"channel": "stable", "version": "1.0.0", "version_code": 1, "file_name": "stable/app.apk"}}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewVersionStore(path)
+	if err != nil {
+		t.Fatalf("NewVersionStore: %v", err)
+	}
+	if got := versions(store.History(ChannelStable)); !slices.Equal(got, []string{"1.0.0"}) {
+		t.Errorf("History = %v, want [1.0.0]", got)
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.1.0", 2)
+
+	err := store.MergeHistory(ChannelStable, []*VersionInfo{
+		{Channel: ChannelStable, Version: "1.0.0", VersionCode: 1, PublishedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Channel: ChannelStable, Version: "1.1.0", VersionCode: 2, PublishedAt: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatalf("MergeHistory: %v", err)
+	}
+	if got, want := versions(store.History(ChannelStable)), []string{"1.1.0", "1.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("History = %v, want %v", got, want)
+	}
+	if current := store.Get(ChannelStable); current.Version != "1.1.0" {
+		t.Errorf("current version changed to %s", current.Version)
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	publish(t, store, ChannelStable, "1.1.0", 2)
+	publish(t, store, ChannelStable, "1.2.0", 3)
+	publish(t, store, ChannelBeta, "2.0.0", 4)
+
+	tests := []struct {
+		name    string
+		channel Channel
+		version string
+		want    string
+		err     error
+	}{
+		{"previous release", ChannelStable, "", "1.1.0", nil},
+		{"explicit version", ChannelStable, "1.0.0", "1.0.0", nil},
+		{"current version", ChannelStable, "1.2.0", "1.2.0", nil},
+		{"unknown version", ChannelStable, "0.9.0", "", ErrReleaseNotFound},
+		{"version of another channel", ChannelStable, "2.0.0", "", ErrReleaseNotFound},
+		{"single release", ChannelBeta, "", "", ErrNoPreviousRelease},
+		{"empty channel", ChannelNightly, "", "", ErrNoPreviousRelease},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := store.RollbackTarget(tt.channel, tt.version)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && target.Version != tt.want {
+				t.Errorf("target = %s, want %s", target.Version, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetCurrent(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	publish(t, store, ChannelStable, "1.1.0", 2)
+
+	if _, err := store.SetCurrent(ChannelStable, "1.0.0"); err != nil {
+		t.Fatalf("SetCurrent: %v", err)
+	}
+	if current := store.Get(ChannelStable); current.Version != "1.0.0" {
+		t.Errorf("current = %s, want 1.0.0", current.Version)
+	}
+	//history is left alone, rolling forward again is possible
+	if got, want := versions(store.History(ChannelStable)), []string{"1.1.0", "1.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("History = %v, want %v", got, want)
+	}
+	if target, err := store.RollbackTarget(ChannelStable, ""); err != ErrNoPreviousRelease {
+		t.Errorf("RollbackTarget after rolling back = %v, %v, want ErrNoPreviousRelease", target, err)
+	}
+
+	if _, err := store.SetCurrent(ChannelStable, "2.0.0"); err != ErrReleaseNotFound {
+		t.Errorf("SetCurrent(unknown) err = %v, want ErrReleaseNotFound", err)
+	}
+}
+
+func TestInRollout(t *testing.T) {
+	percent := func(p int) *int { return &p }
+
+	tests := []struct {
+		name       string
+		percentage *int
+		clientID   string
+		want       bool
+	}{
+		{"no rollout", nil, "device-1", true},
+		{"no rollout without id", nil, "", true},
+		{"full rollout", percent(100), "device-1", true},
+		{"halted rollout", percent(0), "device-1", false},
+		{"partial rollout without id", percent(99), "", false},
+	}
+	for _, tt := range tests {
+		info := &VersionInfo{Channel: ChannelStable, Version: "1.0.0", RolloutPercentage: tt.percentage}
+		if got := info.InRollout(tt.clientID); got != tt.want {
+			t.Errorf("%s: InRollout(%q) = %v, want %v", tt.name, tt.clientID, got, tt.want)
+		}
+	}
+}
+
+func TestInRolloutBucketing(t *testing.T) {
+	const devices = 2000
+	ids := make([]string, devices)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("install-%d", i)
+	}
+	rollout := func(version string, percentage int) map[string]bool {
+		info := &VersionInfo{Channel: ChannelStable, Version: version, RolloutPercentage: &percentage}
+		in := make(map[string]bool)
+		for _, id := range ids {
+			if info.InRollout(id) {
+				in[id] = true
+			}
+		}
+		return in
+	}
+
+	previous := map[string]bool{}
+	for _, percentage := range []int{1, 10, 25, 50, 75, 99} {
+		in := rollout("1.0.0", percentage)
+
+		//roughly the requested share of devices
+		share := float64(len(in)) * 100 / devices
+		if share < float64(percentage)-5 || share > float64(percentage)+5 {
+			t.Errorf("%d%% rollout reached %.1f%% of devices", percentage, share)
+		}
+		//raising the percentage only adds devices
+		for id := range previous {
+			if !in[id] {
+				t.Errorf("%s dropped out when the rollout was raised to %d%%", id, percentage)
+			}
+		}
+		//the same devices every time
+		if again := rollout("1.0.0", percentage); len(again) != len(in) {
+			t.Errorf("%d%% rollout is not deterministic", percentage)
+		}
+		previous = in
+	}
+
+	//each release buckets devices independently
+	a, b := rollout("1.0.0", 50), rollout("1.1.0", 50)
+	same := 0
+	for _, id := range ids {
+		if a[id] == b[id] {
+			same++
+		}
+	}
+	if same == devices {
+		t.Errorf("two releases picked the same devices")
+	}
+}
+
+func TestResolveStagedRollout(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	publish(t, store, ChannelStable, "1.1.0", 2)
+	if _, err := store.SetRollout(ChannelStable, "1.1.0", 50); err != nil {
+		t.Fatalf("SetRollout: %v", err)
+	}
+	current := store.Get(ChannelStable)
+
+	var in, out string
+	for i := 0; in == "" || out == ""; i++ {
+		id := fmt.Sprintf("install-%d", i)
+		if current.InRollout(id) {
+			in = id
+		} else {
+			out = id
+		}
+	}
+
+	tests := []struct {
+		name     string
+		clientID string
+		want     string
+	}{
+		{"inside the rollout", in, "1.1.0"},
+		{"outside the rollout", out, "1.0.0"},
+		{"unknown device", "", "1.0.0"},
+	}
+	for _, tt := range tests {
+		if got := store.Resolve(ChannelStable, tt.clientID); got == nil || got.Version != tt.want {
+			t.Errorf("%s: Resolve = %v, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	//raising to 100% clears the percentage
+	info, err := store.SetRollout(ChannelStable, "1.1.0", 100)
+	if err != nil {
+		t.Fatalf("SetRollout: %v", err)
+	}
+	if info.RolloutPercentage != nil {
+		t.Errorf("RolloutPercentage = %d, want nil", *info.RolloutPercentage)
+	}
+	if got := store.Resolve(ChannelStable, out); got.Version != "1.1.0" {
+		t.Errorf("Resolve after full rollout = %s, want 1.1.0", got.Version)
+	}
+
+	if _, err := store.SetRollout(ChannelStable, "9.9.9", 10); err != ErrReleaseNotFound {
+		t.Errorf("SetRollout(unknown) err = %v, want ErrReleaseNotFound", err)
+	}
+}
+
+//TestCopyOnWrite checks that updates never modify a release a reader already
+//holds. Run with -race to catch unsynchronized access.
+func TestCopyOnWrite(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	before := store.Get(ChannelStable)
+
+	updates := []struct {
+		name string
+		fn   func() (*VersionInfo, error)
+	}{
+		{"SetRollout", func() (*VersionInfo, error) { return store.SetRollout(ChannelStable, "1.0.0", 10) }},
+		{"SetMandatory", func() (*VersionInfo, error) { return store.SetMandatory(ChannelStable, "1.0.0", true) }},
+		{"AddArtifact", func() (*VersionInfo, error) {
+			return store.AddArtifact(ChannelStable, "1.0.0", 1, Artifact{FileName: "stable/arm64.apk", ABI: "arm64-v8a", ArtifactType: ArtifactTypeAPK})
+		}},
+		{"Yank", func() (*VersionInfo, error) { return store.Yank(ChannelStable, "1.0.0", YankInfo{Reason: "broken"}) }},
+	}
+	for _, tt := range updates {
+		updated, err := tt.fn()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if updated == before {
+			t.Errorf("%s modified the stored release in place", tt.name)
+		}
+	}
+	if before.RolloutPercentage != nil || before.Mandatory || len(before.Artifacts) != 0 || before.Yanked != nil {
+		t.Errorf("release held by a reader changed: %+v", before)
+	}
+	if stored := store.GetVersion(ChannelStable, "1.0.0"); stored.Rollout() != 10 || !stored.Mandatory || len(stored.Artifacts) != 2 || !stored.IsYanked() {
+		t.Errorf("stored release is missing updates: %+v", stored)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for p := 0; p < 50; p++ {
+				store.SetRollout(ChannelStable, "1.0.0", p)
+				store.SetMandatory(ChannelStable, "1.0.0", p%2 == 0)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				for _, info := range store.History(ChannelStable) {
+					_ = info.InRollout("device") || info.Mandatory
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSupportsDevice(t *testing.T) {
+	tests := []struct {
+		name   string
+		minSdk int
+		abis   []string
+		sdk    int
+		abi    string
+		want   bool
+	}{
+		{"no requirements", 0, nil, 21, "arm64-v8a", true},
+		{"unknown device", 26, []string{"arm64-v8a"}, 0, "", true},
+		{"sdk met", 26, nil, 26, "", true},
+		{"sdk too old", 26, nil, 25, "", false},
+		{"abi supported", 0, []string{"arm64-v8a", "x86_64"}, 0, "x86_64", true},
+		{"abi unsupported", 0, []string{"arm64-v8a"}, 0, "armeabi-v7a", false},
+	}
+	for _, tt := range tests {
+		info := &VersionInfo{MinSdk: tt.minSdk, ABIs: tt.abis}
+		if got := info.SupportsDevice(tt.sdk, tt.abi); got != tt.want {
+			t.Errorf("%s: SupportsDevice(%d, %q) = %v, want %v", tt.name, tt.sdk, tt.abi, got, tt.want)
+		}
+	}
+}
+
+func TestChannelIsWellFormed(t *testing.T) {
+	tests := []struct {
+		channel Channel
+		want    bool
+	}{
+		{"stable", true},
+		{"qa-2", true},
+		{"internal_test", true},
+		{"0day", true},
+		{"", false},
+		{"Stable", false},
+		{"-beta", false},
+		{"beta/1", false},
+		{"../stable", false},
+		{"a-very-long-channel-name", false},
+	}
+	for _, tt := range tests {
+		if got := tt.channel.IsWellFormed(); got != tt.want {
+			t.Errorf("Channel(%q).IsWellFormed() = %v, want %v", tt.channel, got, tt.want)
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	store := newTestStore(t)
+
+	settings, err := store.CreateChannel("qa", ChannelSettings{Visibility: VisibilityHidden, Retention: -3})
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	if settings.DisplayName != "Qa" || settings.Retention != 0 {
+		t.Errorf("CreateChannel settings = %+v, want display name Qa and retention 0", settings)
+	}
+	if _, err := store.CreateChannel("qa", ChannelSettings{}); err != ErrChannelExists {
+		t.Errorf("CreateChannel(existing) err = %v, want ErrChannelExists", err)
+	}
+	if _, err := store.UpdateChannel("canary", func(*ChannelSettings) {}); err != ErrChannelNotFound {
+		t.Errorf("UpdateChannel(unknown) err = %v, want ErrChannelNotFound", err)
+	}
+
+	names := func(includeHidden bool) []string {
+		var names []string
+		for _, channel := range store.ListChannels(includeHidden) {
+			names = append(names, string(channel.Name))
+		}
+		return names
+	}
+	if got, want := names(false), []string{"beta", "nightly", "stable"}; !slices.Equal(got, want) {
+		t.Errorf("ListChannels(false) = %v, want %v", got, want)
+	}
+	if got, want := names(true), []string{"beta", "nightly", "qa", "stable"}; !slices.Equal(got, want) {
+		t.Errorf("ListChannels(true) = %v, want %v", got, want)
+	}
+
+	//configuration replaces the settings but not the version policy
+	store.UpdateChannel(ChannelStable, func(s *ChannelSettings) { s.MinSupportedVersionCode = 12 })
+	if err := store.EnsureChannel(ChannelStable, ChannelSettings{DisplayName: "Production", Retention: 5}); err != nil {
+		t.Fatalf("EnsureChannel: %v", err)
+	}
+	stable := store.ChannelSettings(ChannelStable)
+	if stable.DisplayName != "Production" || stable.Retention != 5 || stable.MinSupportedVersionCode != 12 {
+		t.Errorf("stable settings = %+v", stable)
+	}
+
+	reloaded, err := NewVersionStore(store.filePath)
+	if err != nil {
+		t.Fatalf("NewVersionStore: %v", err)
+	}
+	if !reloaded.HasChannel("qa") || reloaded.ChannelSettings("qa").Visibility != VisibilityHidden {
+		t.Errorf("channel qa was not persisted")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention int
+		current   string //rolled back to this release first, if set
+		pruned    []string
+		kept      []string
+	}{
+		{"keep everything", 0, "", nil, []string{"1.3.0", "1.2.0", "1.1.0", "1.0.0"}},
+		{"under the limit", 10, "", nil, []string{"1.3.0", "1.2.0", "1.1.0", "1.0.0"}},
+		{"oldest first", 2, "", []string{"1.0.0", "1.1.0"}, []string{"1.3.0", "1.2.0"}},
+		{"current is kept", 2, "1.0.0", []string{"1.1.0", "1.2.0"}, []string{"1.3.0", "1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			for i, version := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0"} {
+				publish(t, store, ChannelStable, version, i+1)
+			}
+			if tt.current != "" {
+				store.SetCurrent(ChannelStable, tt.current)
+			}
+			store.UpdateChannel(ChannelStable, func(s *ChannelSettings) { s.Retention = tt.retention })
+
+			pruned, err := store.Prune(ChannelStable)
+			if err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			if got := versions(pruned); !slices.Equal(got, tt.pruned) && len(got)+len(tt.pruned) > 0 {
+				t.Errorf("pruned = %v, want %v", got, tt.pruned)
+			}
+			if got := versions(store.History(ChannelStable)); !slices.Equal(got, tt.kept) {
+				t.Errorf("kept = %v, want %v", got, tt.kept)
+			}
+			if pruned != nil && store.ArtifactInUse(pruned[0].FileName) {
+				t.Errorf("pruned artifact %s is still in use", pruned[0].FileName)
+			}
+		})
+	}
+}
+
+//schedule stores a release that becomes current at publishAt
+func schedule(t *testing.T, store *VersionStore, channel Channel, version string, publishAt time.Time) {
+	t.Helper()
+	info := &VersionInfo{Channel: channel, Version: version, PublishAt: &publishAt}
+	if err := store.Schedule(info); err != nil {
+		t.Fatalf("Schedule(%s v%s): %v", channel, version, err)
+	}
+}
+
+func TestActivateDue(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		due     []string
+		pending []string
+		current string
+	}{
+		{"nothing due", base.Add(-time.Minute), nil, []string{"1.1.0", "2.0.0", "1.2.0"}, "1.0.0"},
+		{"exactly at publish time", base, []string{"1.1.0"}, []string{"2.0.0", "1.2.0"}, "1.1.0"},
+		{"publish order", base.Add(2 * time.Hour), []string{"1.1.0", "2.0.0", "1.2.0"}, []string{}, "1.2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			publish(t, store, ChannelStable, "1.0.0", 1)
+			schedule(t, store, ChannelStable, "1.2.0", base.Add(2*time.Hour))
+			schedule(t, store, ChannelStable, "1.1.0", base)
+			schedule(t, store, ChannelBeta, "2.0.0", base.Add(time.Hour))
+
+			due, err := store.ActivateDue(tt.now)
+			if err != nil {
+				t.Fatalf("ActivateDue: %v", err)
+			}
+			if got := versions(due); !slices.Equal(got, tt.due) {
+				t.Errorf("due = %v, want %v", got, tt.due)
+			}
+			for _, info := range due {
+				if info.PublishAt != nil || !info.PublishedAt.Equal(tt.now) {
+					t.Errorf("%s: PublishAt = %v, PublishedAt = %v", info.Version, info.PublishAt, info.PublishedAt)
+				}
+			}
+			if got := versions(store.ListScheduled("")); !slices.Equal(got, tt.pending) {
+				t.Errorf("pending = %v, want %v", got, tt.pending)
+			}
+			if current := store.Get(ChannelStable).Version; current != tt.current {
+				t.Errorf("current = %s, want %s", current, tt.current)
+			}
+
+			//activated releases stay published after a reload
+			reloaded, err := NewVersionStore(store.filePath)
+			if err != nil {
+				t.Fatalf("NewVersionStore: %v", err)
+			}
+			if got := len(reloaded.ListScheduled("")); got != len(tt.pending) {
+				t.Errorf("reloaded store has %d pending releases, want %d", got, len(tt.pending))
+			}
+		})
+	}
+}
+
+func TestScheduled(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := newTestStore(t)
+	schedule(t, store, ChannelStable, "1.1.0", base.Add(time.Hour))
+	schedule(t, store, ChannelStable, "1.2.0", base.Add(2*time.Hour))
+	schedule(t, store, ChannelBeta, "2.0.0", base)
+
+	if got, want := versions(store.ListScheduled(ChannelStable)), []string{"1.1.0", "1.2.0"}; !slices.Equal(got, want) {
+		t.Errorf("ListScheduled(stable) = %v, want %v", got, want)
+	}
+
+	//scheduling the same version again replaces the pending release
+	schedule(t, store, ChannelStable, "1.1.0", base.Add(3*time.Hour))
+	if got, want := versions(store.ListScheduled(ChannelStable)), []string{"1.2.0", "1.1.0"}; !slices.Equal(got, want) {
+		t.Errorf("after rescheduling by upload = %v, want %v", got, want)
+	}
+
+	info, err := store.Reschedule(ChannelStable, "1.2.0", base.Add(4*time.Hour))
+	if err != nil {
+		t.Fatalf("Reschedule: %v", err)
+	}
+	if !info.PublishAt.Equal(base.Add(4 * time.Hour)) {
+		t.Errorf("PublishAt = %v", info.PublishAt)
+	}
+	if got, want := versions(store.ListScheduled(ChannelStable)), []string{"1.1.0", "1.2.0"}; !slices.Equal(got, want) {
+		t.Errorf("after Reschedule = %v, want %v", got, want)
+	}
+
+	//publishing directly supersedes the pending release
+	publish(t, store, ChannelStable, "1.1.0", 11)
+	if store.GetScheduled(ChannelStable, "1.1.0") != nil {
+		t.Error("Set kept the pending release of the same version")
+	}
+
+	if _, err := store.CancelScheduled(ChannelStable, "1.2.0"); err != nil {
+		t.Fatalf("CancelScheduled: %v", err)
+	}
+	if n := len(store.ListScheduled(ChannelStable)); n != 0 {
+		t.Errorf("%d releases still pending", n)
+	}
+
+	for _, tt := range []struct {
+		channel Channel
+		version string
+	}{
+		{ChannelStable, "1.2.0"},
+		{ChannelStable, "2.0.0"},
+		{ChannelNightly, "2.0.0"},
+	} {
+		if _, err := store.Reschedule(tt.channel, tt.version, base); !errors.Is(err, ErrReleaseNotFound) {
+			t.Errorf("Reschedule(%s, %s) = %v, want ErrReleaseNotFound", tt.channel, tt.version, err)
+		}
+		if _, err := store.CancelScheduled(tt.channel, tt.version); !errors.Is(err, ErrReleaseNotFound) {
+			t.Errorf("CancelScheduled(%s, %s) = %v, want ErrReleaseNotFound", tt.channel, tt.version, err)
+		}
+	}
+}
+
+func TestYank(t *testing.T) {
+	tests := []struct {
+		name    string
+		yank    []string
+		current string //empty when the channel has no release left
+	}{
+		{"current release", []string{"1.2.0"}, "1.1.0"},
+		{"older release", []string{"1.1.0"}, "1.2.0"},
+		{"skips yanked releases", []string{"1.1.0", "1.2.0"}, "1.0.0"},
+		{"every release", []string{"1.0.0", "1.1.0", "1.2.0"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			for i, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+				publish(t, store, ChannelStable, version, i+1)
+			}
+
+			for _, version := range tt.yank {
+				info, err := store.Yank(ChannelStable, version, YankInfo{Reason: "crash"})
+				if err != nil {
+					t.Fatalf("Yank(%s): %v", version, err)
+				}
+				if !info.IsYanked() || info.Yanked.Reason != "crash" {
+					t.Errorf("Yank(%s) returned %+v", version, info.Yanked)
+				}
+			}
+
+			current := store.Get(ChannelStable)
+			if tt.current == "" {
+				if current != nil {
+					t.Errorf("current = %s, want none", current.Version)
+				}
+			} else if current == nil || current.Version != tt.current {
+				t.Errorf("current = %v, want %s", current, tt.current)
+			}
+			//yanked releases stay in the history
+			if n := len(store.History(ChannelStable)); n != 3 {
+				t.Errorf("history has %d releases, want 3", n)
+			}
+			for _, version := range tt.yank {
+				if !store.GetVersion(ChannelStable, version).IsYanked() {
+					t.Errorf("%s is not yanked", version)
+				}
+				if resolved := store.Resolve(ChannelStable, "device"); resolved != nil && resolved.Version == version {
+					t.Errorf("Resolve returned yanked %s", version)
+				}
+			}
+		})
+	}
+
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	if _, err := store.Yank(ChannelStable, "9.9.9", YankInfo{}); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("Yank of an unknown release = %v, want ErrReleaseNotFound", err)
+	}
+	if _, err := store.Yank(ChannelBeta, "1.0.0", YankInfo{}); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("Yank in another channel = %v, want ErrReleaseNotFound", err)
+	}
+}
+
+func TestFindByVersionCode(t *testing.T) {
+	store := newTestStore(t)
+	publish(t, store, ChannelStable, "1.0.0", 1)
+	publish(t, store, ChannelStable, "1.0.1", 2)
+	publish(t, store, ChannelBeta, "2.0.0", 3)
+
+	tests := []struct {
+		channel     Channel
+		versionCode int
+		want        string
+	}{
+		{ChannelStable, 1, "1.0.0"},
+		{ChannelStable, 2, "1.0.1"},
+		{ChannelStable, 3, ""},
+		{ChannelBeta, 3, "2.0.0"},
+	}
+	for _, tt := range tests {
+		info := store.FindByVersionCode(tt.channel, tt.versionCode)
+		got := ""
+		if info != nil {
+			got = info.Version
+		}
+		if got != tt.want {
+			t.Errorf("FindByVersionCode(%s, %d) = %q, want %q", tt.channel, tt.versionCode, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	const hexFingerprint = "a40da80a59d170caa950cf15c18c454d47a39b26989d8b640ecd745ba71bf5dc"
+	keytool := "A4:0D:A8:0A:59:D1:70:CA:A9:50:CF:15:C1:8C:45:4D:47:A3:9B:26:98:9D:8B:64:0E:CD:74:5B:A7:1B:F5:DC"
+
+	tests := []struct {
+		fingerprint string
+		want        string
+		ok          bool
+	}{
+		{hexFingerprint, hexFingerprint, true},
+		{keytool, hexFingerprint, true},
+		{"  " + keytool + "\n", hexFingerprint, true},
+		{hexFingerprint[:62], "", false},
+		{hexFingerprint + "00", "", false},
+		{"zz" + hexFingerprint[2:], "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeFingerprint(tt.fingerprint)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeFingerprint(%q) = %q, %v, want %q, %v", tt.fingerprint, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAllowsSigner(t *testing.T) {
+	const signer = "a40da80a59d170caa950cf15c18c454d47a39b26989d8b640ecd745ba71bf5dc"
+	const other = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	store := newTestStore(t)
+	//pinned the way keytool prints it, stored normalized
+	if _, err := store.UpdateChannel(ChannelStable, func(c *ChannelSettings) {
+		c.AllowedSigners = []string{"A4:0D:A8:0A:59:D1:70:CA:A9:50:CF:15:C1:8C:45:4D:47:A3:9B:26:98:9D:8B:64:0E:CD:74:5B:A7:1B:F5:DC"}
+	}); err != nil {
+		t.Fatalf("UpdateChannel: %v", err)
+	}
+	//re-registering the channel without signers keeps the pin
+	if err := store.EnsureChannel(ChannelStable, ChannelSettings{DisplayName: "Stable"}); err != nil {
+		t.Fatalf("EnsureChannel: %v", err)
+	}
+
+	tests := []struct {
+		channel     Channel
+		fingerprint string
+		want        bool
+	}{
+		{ChannelStable, signer, true},
+		{ChannelStable, other, false},
+		{ChannelStable, "", false},
+		{ChannelBeta, other, true},
+		{ChannelBeta, "", true},
+	}
+	for _, tt := range tests {
+		settings := store.ChannelSettings(tt.channel)
+		if got := settings.AllowsSigner(tt.fingerprint); got != tt.want {
+			t.Errorf("%s: AllowsSigner(%q) = %v, want %v", tt.channel, tt.fingerprint, got, tt.want)
+		}
+	}
+}
+
+func apkArtifact(name, abi, density string, minSdk int) Artifact {
+	return Artifact{FileName: name, SHA256: name, ABI: abi, Density: density, MinSdk: minSdk, ArtifactType: ArtifactTypeAPK}
+}
+
+func TestSetArtifact(t *testing.T) {
+	deb := Artifact{FileName: "app.deb", ArtifactType: ArtifactType{"linux", ".deb", "application/vnd.debian.binary-package"}}
+
+	tests := []struct {
+		name    string
+		release VersionInfo
+		add     Artifact
+		files   []string
+		primary string
+		minSdk  int
+	}{
+		{
+			name:    "first file",
+			add:     apkArtifact("universal.apk", "", "", 24),
+			files:   []string{"universal.apk"},
+			primary: "universal.apk",
+			minSdk:  24,
+		},
+		{
+			name:    "split joins the release",
+			release: VersionInfo{Artifacts: []Artifact{apkArtifact("universal.apk", "", "", 24)}},
+			add:     apkArtifact("arm64.apk", "arm64-v8a", "", 21),
+			files:   []string{"universal.apk", "arm64.apk"},
+			primary: "universal.apk",
+			minSdk:  21,
+		},
+		{
+			name:    "same split is replaced",
+			release: VersionInfo{Artifacts: []Artifact{apkArtifact("universal.apk", "", "", 24), apkArtifact("arm64.apk", "arm64-v8a", "", 24)}},
+			add:     apkArtifact("arm64-rebuilt.apk", "arm64-v8a", "", 24),
+			files:   []string{"universal.apk", "arm64-rebuilt.apk"},
+			primary: "universal.apk",
+			minSdk:  24,
+		},
+		{
+			name:    "universal file after splits",
+			release: VersionInfo{Artifacts: []Artifact{apkArtifact("arm64.apk", "arm64-v8a", "", 24)}},
+			add:     apkArtifact("universal.apk", "", "", 26),
+			files:   []string{"arm64.apk", "universal.apk"},
+			primary: "universal.apk",
+			minSdk:  24,
+		},
+		{
+			name:    "other platform",
+			release: VersionInfo{Artifacts: []Artifact{apkArtifact("universal.apk", "", "", 24)}},
+			add:     deb,
+			files:   []string{"universal.apk", "app.deb"},
+			primary: "universal.apk",
+		},
+		{
+			name:    "release from before splits",
+			release: VersionInfo{FileName: "legacy.apk", SHA256: "legacy.apk", ArtifactType: ArtifactTypeAPK},
+			add:     apkArtifact("x86_64.apk", "x86_64", "", 0),
+			files:   []string{"legacy.apk", "x86_64.apk"},
+			primary: "legacy.apk",
+		},
+	}
+	for _, tt := range tests {
+		original := slices.Clone(tt.release.Artifacts)
+		v := tt.release
+		v.SetArtifact(tt.add)
+
+		var files []string
+		for _, a := range v.Artifacts {
+			files = append(files, a.FileName)
+		}
+		if !slices.Equal(files, tt.files) {
+			t.Errorf("%s: files = %v, want %v", tt.name, files, tt.files)
+		}
+		if v.FileName != tt.primary || v.SHA256 != tt.primary {
+			t.Errorf("%s: primary file = %s, want %s", tt.name, v.FileName, tt.primary)
+		}
+		if v.MinSdk != tt.minSdk {
+			t.Errorf("%s: MinSdk = %d, want %d", tt.name, v.MinSdk, tt.minSdk)
+		}
+		if !slices.Equal(tt.release.Artifacts, original) {
+			t.Errorf("%s: SetArtifact changed the artifacts of the original release", tt.name)
+		}
+	}
+}
+
+func TestSelectArtifact(t *testing.T) {
+	release := &VersionInfo{ArtifactType: ArtifactTypeAPK, Artifacts: []Artifact{
+		apkArtifact("universal.apk", "", "", 21),
+		apkArtifact("arm64.apk", "arm64-v8a", "", 21),
+		apkArtifact("arm64-modern.apk", "arm64-v8a", "", 29),
+		apkArtifact("arm64-xxhdpi.apk", "arm64-v8a", "xxhdpi", 21),
+		apkArtifact("xhdpi.apk", "", "xhdpi", 21),
+		apkArtifact("x86_64.apk", "x86_64", "", 26),
+		{FileName: "app.deb", ArtifactType: ArtifactType{"linux", ".deb", "application/vnd.debian.binary-package"}},
+	}}
+
+	tests := []struct {
+		name                   string
+		platform, abi, density string
+		sdk                    int
+		want                   string //empty for none
+	}{
+		{"no device info", "", "", "", 0, "universal.apk"},
+		{"abi split", "", "arm64-v8a", "", 24, "arm64.apk"},
+		{"highest min_sdk the device meets", "", "arm64-v8a", "", 30, "arm64-modern.apk"},
+		{"unknown sdk", "", "arm64-v8a", "", 0, "arm64-modern.apk"},
+		{"abi and density", "", "arm64-v8a", "xxhdpi", 24, "arm64-xxhdpi.apk"},
+		{"abi beats density", "", "arm64-v8a", "xhdpi", 24, "arm64.apk"},
+		{"density split", "", "armeabi-v7a", "xhdpi", 24, "xhdpi.apk"},
+		{"falls back to universal", "", "armeabi-v7a", "mdpi", 24, "universal.apk"},
+		{"split too new for the device", "", "x86_64", "", 24, "universal.apk"},
+		{"device too old", "", "", "", 19, ""},
+		{"other platform", "linux", "", "", 0, "app.deb"},
+		{"unknown platform", "windows", "", "", 0, ""},
+	}
+	for _, tt := range tests {
+		got := release.SelectArtifact(tt.platform, tt.abi, tt.density, tt.sdk)
+		if got == nil {
+			if tt.want != "" {
+				t.Errorf("%s: SelectArtifact = nil, want %s", tt.name, tt.want)
+			}
+			continue
+		}
+		if got.FileName != tt.want {
+			t.Errorf("%s: SelectArtifact = %s, want %q", tt.name, got.FileName, tt.want)
+		}
+	}
+}
+
+func TestAddArtifact(t *testing.T) {
+	store := newTestStore(t)
+	stored := publish(t, store, ChannelStable, "1.0.0", 1)
+	split := apkArtifact("arm64.apk", "arm64-v8a", "", 24)
+
+	if _, err := store.AddArtifact(ChannelStable, "1.0.0", 2, split); !errors.Is(err, ErrVersionCodeMismatch) {
+		t.Errorf("other version code: err = %v, want %v", err, ErrVersionCodeMismatch)
+	}
+	if _, err := store.AddArtifact(ChannelStable, "9.9.9", 1, split); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("unknown release: err = %v, want %v", err, ErrReleaseNotFound)
+	}
+
+	info, err := store.AddArtifact(ChannelStable, "1.0.0", 1, split)
+	if err != nil {
+		t.Fatalf("AddArtifact: %v", err)
+	}
+	if len(info.Artifacts) != 2 || info.FileName != stored.FileName {
+		t.Errorf("AddArtifact = %+v", info)
+	}
+	if current := store.Get(ChannelStable); len(current.Files()) != 2 {
+		t.Errorf("current release has %d files, want 2", len(current.Files()))
+	}
+	//releases handed out before are not changed
+	if len(stored.Artifacts) > 1 {
+		t.Errorf("AddArtifact changed a release returned earlier")
+	}
+}
\ No newline at end of file
@@ -22,7 +22,7 @@ func (s *LocalStorage) fullPath(key string) string {
 	return filepath.Join(s.basePath, key)
 }
 
-func (s *LocalStorage) Upload(ctx context.Context, key string, reader io.Reader, size int64) error {
+func (s *LocalStorage) Upload(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
 	path := s.fullPath(key)
 
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
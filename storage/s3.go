@@ -62,13 +62,13 @@ func NewS3Storage(cfg S3Config) (*S3Storage, error) {
 	}, nil
 }
 
-func (s *S3Storage) Upload(ctx context.Context, key string, reader io.Reader, size int64) error {
+func (s *S3Storage) Upload(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
 	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:        aws.String(s.bucket),
 		Key:           aws.String(key),
 		Body:          reader,
 		ContentLength: aws.Int64(size),
-		ContentType:   aws.String("application/vnd.android.package-archive"),
+		ContentType:   aws.String(contentType),
 	})
 	return err
 }
@@ -6,12 +6,24 @@ import (
 )
 
 type Storage interface {
-	Upload(ctx context.Context, key string, reader io.Reader, size int64) error
+	Upload(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
 	Download(ctx context.Context, key string) (io.ReadCloser, int64, error)
 	Delete(ctx context.Context, key string) error
 	Exists(ctx context.Context, key string) (bool, error)
 }
 
+//Copy duplicates an object within a storage backend by streaming it through
+//the service, which works for every backend including FallbackStorage
+func Copy(ctx context.Context, s Storage, srcKey, dstKey, contentType string) error {
+	reader, size, err := s.Download(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return s.Upload(ctx, dstKey, reader, size, contentType)
+}
+
 type FallbackStorage struct {
 	primary   Storage
 	fallback  Storage
@@ -24,14 +36,20 @@ func NewFallbackStorage(primary, fallback Storage) *FallbackStorage {
 	}
 }
 
-func (s *FallbackStorage) Upload(ctx context.Context, key string, reader io.Reader, size int64) error {
+func (s *FallbackStorage) Upload(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
 	if s.primary != nil {
-		if err := s.primary.Upload(ctx, key, reader, size); err == nil {
+		if err := s.primary.Upload(ctx, key, reader, size, contentType); err == nil {
 			return nil
 		}
 	}
 	if s.fallback != nil {
-		return s.fallback.Upload(ctx, key, reader, size)
+		//the primary may have consumed part of the reader before failing
+		if seeker, ok := reader.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		return s.fallback.Upload(ctx, key, reader, size, contentType)
 	}
 	return nil
 }
@@ -75,4 +93,34 @@ func (s *FallbackStorage) Exists(ctx context.Context, key string) (bool, error)
 		return s.fallback.Exists(ctx, key)
 	}
 	return false, nil
+}
+
+//PrefixedStorage scopes every key of an underlying storage below a prefix,
+//so several apps can share one bucket or directory
+type PrefixedStorage struct {
+	inner  Storage
+	prefix string
+}
+
+func NewPrefixedStorage(inner Storage, prefix string) Storage {
+	if prefix == "" {
+		return inner
+	}
+	return &PrefixedStorage{inner: inner, prefix: prefix}
+}
+
+func (s *PrefixedStorage) Upload(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	return s.inner.Upload(ctx, s.prefix+key, reader, size, contentType)
+}
+
+func (s *PrefixedStorage) Download(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	return s.inner.Download(ctx, s.prefix+key)
+}
+
+func (s *PrefixedStorage) Delete(ctx context.Context, key string) error {
+	return s.inner.Delete(ctx, s.prefix+key)
+}
+
+func (s *PrefixedStorage) Exists(ctx context.Context, key string) (bool, error) {
+	return s.inner.Exists(ctx, s.prefix+key)
 }
\ No newline at end of file
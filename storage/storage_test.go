@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefixedStorage(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(filepath.Join(t.TempDir(), "apks"))
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	if NewPrefixedStorage(local, "") != local {
+		t.Error("an empty prefix should return the underlying storage")
+	}
+
+	tv := NewPrefixedStorage(local, "tv/")
+	if err := tv.Upload(ctx, "stable/app.apk", strings.NewReader("tv"), 2, "application/octet-stream"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	tests := []struct {
+		store  Storage
+		key    string
+		exists bool
+	}{
+		{tv, "stable/app.apk", true},
+		{local, "tv/stable/app.apk", true},
+		{local, "stable/app.apk", false},
+		{NewPrefixedStorage(local, "watch/"), "stable/app.apk", false},
+	}
+	for _, tt := range tests {
+		exists, err := tt.store.Exists(ctx, tt.key)
+		if err != nil {
+			t.Fatalf("Exists(%s): %v", tt.key, err)
+		}
+		if exists != tt.exists {
+			t.Errorf("Exists(%s) = %v, want %v", tt.key, exists, tt.exists)
+		}
+	}
+
+	reader, _, err := tv.Download(ctx, "stable/app.apk")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "tv" {
+		t.Errorf("Download = %q, want %q", data, "tv")
+	}
+
+	if err := tv.Delete(ctx, "stable/app.apk"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if exists, _ := local.Exists(ctx, "tv/stable/app.apk"); exists {
+		t.Error("Delete left the prefixed key in place")
+	}
+}
\ No newline at end of file